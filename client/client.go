@@ -41,6 +41,10 @@ const (
 	// QueryTypeOpenSessions is the build in query type for Client.QueryWorkflow() call. Use this query type to get all open
 	// sessions in the workflow. The result will be a list of SessionInfo encoded in the encoded.Value.
 	QueryTypeOpenSessions string = internal.QueryTypeOpenSessions
+
+	// QueryTypeQueryTypes is the build in query type for Client.QueryWorkflow() call. Use this query type to list the
+	// query types the workflow supports. The result will be a list of QueryTypeInfo encoded in the encoded.Value.
+	QueryTypeQueryTypes string = internal.QueryTypeQueryTypes
 )
 
 type (
@@ -68,6 +72,14 @@ type (
 	// ParentClosePolicy defines the behavior performed on a child workflow when its parent is closed
 	ParentClosePolicy = internal.ParentClosePolicy
 
+	// UpdateRejectedError is returned by Client.UpdateWorkflow when the workflow rejected the update, either because
+	// there is no handler for it or because the update validator returned an error.
+	UpdateRejectedError = internal.UpdateRejectedError
+
+	// QueryTypeInfo describes a query type of a workflow, the result of the built-in QueryTypeQueryTypes query is a
+	// list of them.
+	QueryTypeInfo = internal.QueryTypeInfo
+
 	// Client is the client for starting and getting information about a workflow executions as well as
 	// completing activities asynchronously.
 	Client interface {
@@ -317,8 +329,30 @@ type (
 		//  - InternalServiceError
 		//  - EntityNotExistError
 		//  - QueryFailError
+		//  - CustomError when the query handler returned one
 		QueryWorkflow(ctx context.Context, workflowID string, runID string, queryType string, args ...interface{}) (encoded.Value, error)
 
+		// UpdateWorkflow sends an update to a workflow execution and waits for its result. The update is validated
+		// by the workflow first and the call fails with UpdateRejectedError without writing any history if the workflow
+		// rejects it. Otherwise it is delivered to the update handler the workflow set through
+		// workflow.SetUpdateHandler(), and the call returns the result or the error of the handler. Use ctx to
+		// limit how long to wait for the update to complete, without a deadline on ctx it waits at most 10 minutes.
+		// The workflow keeps the outcomes of the last 1000 completed updates only, the call fails once the outcome of
+		// its update is evicted.
+		// - workflowID is required.
+		// - runID can be default(empty string). if empty string then it will pick the running execution of that
+		//   workflow ID, and the update is sent to that run even if it continues as new before the update completes.
+		// - updateName is the name of the update.
+		// - args... are the optional update parameters.
+		// The errors it can return:
+		//  - UpdateRejectedError
+		//  - BadRequestError
+		//  - InternalServiceError
+		//  - EntityNotExistError
+		//  - QueryFailError
+		//  - the error returned by the update handler
+		UpdateWorkflow(ctx context.Context, workflowID string, runID string, updateName string, args ...interface{}) (encoded.Value, error)
+
 		// QueryWorkflowWithOptions queries a given workflow execution and returns the query result synchronously.
 		// See QueryWorkflowWithOptionsRequest and QueryWorkflowWithOptionsResponse for more information.
 		// The errors it can return:
@@ -326,6 +360,7 @@ type (
 		//  - InternalServiceError
 		//  - EntityNotExistError
 		//  - QueryFailError
+		//  - CustomError when the query handler returned one
 		QueryWorkflowWithOptions(ctx context.Context, request *QueryWorkflowWithOptionsRequest) (*QueryWorkflowWithOptionsResponse, error)
 
 		// DescribeWorkflowExecution returns information about the specified workflow execution.
@@ -434,3 +469,18 @@ func NewValue(data []byte) encoded.Value {
 func NewValues(data []byte) encoded.Values {
 	return internal.NewValues(data)
 }
+
+// DeleteOffloadedPayloads waits until the payloads offloaded by encoded.NewOffloadingDataConverter for a workflow can
+// no longer be decoded and deletes them from the store. This is the case once the run, all the runs it continued as new
+// from and into, its parent run, its children and the workflows it signaled are closed. Payloads encoded outside of any
+// workflow, like the results of activities completed by task token, are not deleted. The parent, children and signaled
+// workflows must be in the domain of the client. Don't start a new workflow with the same workflow ID before it
+// returns. The deleted payloads are marked in the store so that they are not stored again: queries and replays of the
+// runs fail afterwards, as does a new workflow with the same workflow ID which offloads one of the deleted payloads.
+// For example:
+//   run, err := c.ExecuteWorkflow(ctx, options, workflowFn)
+//   ...
+//   go client.DeleteOffloadedPayloads(context.Background(), c, store, run.GetID(), run.GetRunID())
+func DeleteOffloadedPayloads(ctx context.Context, c Client, store encoded.BlobStore, workflowID, runID string) error {
+	return internal.DeleteOffloadedPayloads(ctx, c, store, workflowID, runID)
+}
@@ -21,7 +21,10 @@
 // Package encoded contains wrappers that are used for binary payloads deserialization.
 package encoded
 
-import "go.uber.org/cadence/internal"
+import (
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal"
+)
 
 type (
 
@@ -42,9 +45,154 @@ type (
 	// Cadence support using different DataConverters for different activity/childWorkflow in same workflow.
 	//   2. Activity/Workflow worker that run these activity/childWorkflow, through worker.Options.
 	DataConverter = internal.DataConverter
+
+	// CompressionAlgorithm is the algorithm used by the compressing DataConverter.
+	CompressionAlgorithm = internal.CompressionAlgorithm
+
+	// CompressionOptions configures the DataConverter returned by NewCompressingDataConverter.
+	CompressionOptions = internal.CompressionOptions
+
+	// KeyProvider provides the AES keys used by the encrypting DataConverter. Keys must be 16, 24 or 32 bytes long to
+	// select AES-128, AES-192 or AES-256. A key must stay available through GetDecryptionKey for as long as histories
+	// encrypted with it can be replayed or queried.
+	KeyProvider = internal.KeyProvider
+
+	// EncryptionOptions configures the DataConverter returned by NewEncryptingDataConverter.
+	EncryptionOptions = internal.EncryptionOptions
+
+	// Payload is one value of a payload envelope along with the encoding it was converted with.
+	Payload = internal.Payload
+
+	// PayloadConverter converts single values for the composite DataConverter. The encoding it returns is recorded
+	// along with each value it converts, and it is used to find the PayloadConverter which decodes the value.
+	PayloadConverter = internal.PayloadConverter
+
+	// ProtoOptions configures the DataConverter returned by NewProtoDataConverter.
+	ProtoOptions = internal.ProtoOptions
+
+	// BlobStore stores the payloads offloaded by the offloading DataConverter. Payloads are addressed by their
+	// workflow and their content, so Put is called again with the same key and data when a workflow is replayed and
+	// must be idempotent. Get must fail for keys which are not stored.
+	BlobStore = internal.BlobStore
+
+	// OffloadOptions configures the DataConverter returned by NewOffloadingDataConverter.
+	OffloadOptions = internal.OffloadOptions
+)
+
+const (
+	// CompressionAlgorithmGzip compresses payloads with gzip.
+	CompressionAlgorithmGzip = internal.CompressionAlgorithmGzip
+	// CompressionAlgorithmZlib compresses payloads with zlib.
+	CompressionAlgorithmZlib = internal.CompressionAlgorithmZlib
+)
+
+// Encodings recorded in the payload envelope by the payload converters of the composite DataConverter.
+const (
+	// PayloadEncodingNil is the encoding of nil values, the payload has no data.
+	PayloadEncodingNil = internal.PayloadEncodingNil
+	// PayloadEncodingRaw is the encoding of []byte values, the data is stored as is.
+	PayloadEncodingRaw = internal.PayloadEncodingRaw
+	// PayloadEncodingThrift is the encoding of thrift structs, the data is thrift binary.
+	PayloadEncodingThrift = internal.PayloadEncodingThrift
+	// PayloadEncodingThriftRW is the encoding of the types generated by thriftrw, like the ones of the cadence API, the
+	// data is thrift binary.
+	PayloadEncodingThriftRW = internal.PayloadEncodingThriftRW
+	// PayloadEncodingJSON is the encoding of the values which are encoded as JSON.
+	PayloadEncodingJSON = internal.PayloadEncodingJSON
+	// PayloadEncodingProto is the encoding of proto.Message values encoded with protobuf binary encoding.
+	PayloadEncodingProto = internal.PayloadEncodingProto
+	// PayloadEncodingProtoJSON is the encoding of proto.Message values encoded with protobuf JSON mapping.
+	PayloadEncodingProtoJSON = internal.PayloadEncodingProtoJSON
 )
 
 // GetDefaultDataConverter return default data converter used by Cadence worker
 func GetDefaultDataConverter() DataConverter {
 	return internal.DefaultDataConverter
 }
+
+// NewCompressingDataConverter returns a DataConverter which compresses the payloads produced by the inner
+// DataConverter when they are larger than the threshold. Payloads without the compression marker are passed to the
+// inner DataConverter as is, so histories written before the converter was enabled still replay.
+// The returned DataConverter can be used in worker.Options and client.Options, and with workflow.WithDataConverter:
+//  dc := encoded.NewCompressingDataConverter(encoded.GetDefaultDataConverter(), encoded.CompressionOptions{
+//  	Threshold: 4096,
+//  	Algorithm: encoded.CompressionAlgorithmZlib,
+//  })
+func NewCompressingDataConverter(inner DataConverter, options CompressionOptions) DataConverter {
+	return internal.NewCompressingDataConverter(inner, options)
+}
+
+// NewEncryptingDataConverter returns a DataConverter which encrypts the payloads produced by the inner DataConverter
+// with AES-GCM. The ID of the key is stored in each payload so payloads encrypted with a rotated key still decode as
+// long as the KeyProvider returns it. Decoding fails when the key is not available, or when the payload is not encrypted
+// and AllowUnencryptedPayloads is not set.
+// To combine it with compression, wrap the compressing DataConverter, as encrypted data does not compress:
+//  dc := encoded.NewEncryptingDataConverter(
+//  	encoded.NewCompressingDataConverter(encoded.GetDefaultDataConverter(), encoded.CompressionOptions{}),
+//  	encoded.EncryptionOptions{KeyProvider: encoded.NewStaticKeyProvider("key-2", keys)},
+//  )
+func NewEncryptingDataConverter(inner DataConverter, options EncryptionOptions) DataConverter {
+	return internal.NewEncryptingDataConverter(inner, options)
+}
+
+// NewStaticKeyProvider returns a KeyProvider which encrypts with the key of currentKeyID and decrypts with any of the
+// given keys. Keep the retired keys in the map after rotating to a new one.
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) KeyProvider {
+	return internal.NewStaticKeyProvider(currentKeyID, keys)
+}
+
+// GetDefaultPayloadConverters returns the payload converters used by the composite DataConverter when none is given.
+// They convert nil, []byte, thrift structs and JSON values in this order.
+func GetDefaultPayloadConverters() []PayloadConverter {
+	return internal.GetDefaultPayloadConverters()
+}
+
+// NewCompositeDataConverter returns a DataConverter which converts each value with the first PayloadConverter which
+// supports it, and records the encoding of every value in a payload envelope. Values are decoded with the
+// PayloadConverter of their recorded encoding, so calls mixing argument types decode reliably. Payloads which are not
+// in an envelope are decoded by the default DataConverter.
+func NewCompositeDataConverter(converters ...PayloadConverter) DataConverter {
+	return internal.NewCompositeDataConverter(converters...)
+}
+
+// DecodePayloadEnvelope returns the payloads of a payload envelope written by the composite DataConverter. It returns
+// false if data is not a payload envelope. It does not need the Go types of the values, so tools can use it to
+// inspect histories.
+func DecodePayloadEnvelope(data []byte) ([]Payload, bool, error) {
+	return internal.DecodePayloadEnvelope(data)
+}
+
+// NewProtoDataConverter returns a DataConverter which encodes proto.Message values with protobuf. Values are encoded
+// exactly like with the default DataConverter unless one of them is a proto message, in which case all of them are
+// stored in a payload envelope: the proto messages with protobuf and the other values like the composite DataConverter
+// with the default payload converters. Workflow and activity functions can take pointers to proto messages as
+// arguments and return them as results.
+func NewProtoDataConverter(options ProtoOptions) DataConverter {
+	return internal.NewProtoDataConverter(options)
+}
+
+// NewProtoPayloadConverter returns a PayloadConverter for proto.Message values to use with the composite
+// DataConverter. It encodes them with the protobuf JSON mapping if useJSON is set and with protobuf binary otherwise.
+func NewProtoPayloadConverter(useJSON bool) PayloadConverter {
+	return internal.NewProtoPayloadConverter(useJSON)
+}
+
+// NewOffloadingDataConverter returns a DataConverter which stores the payloads produced by the inner DataConverter in
+// a BlobStore when they are larger than the threshold, and records only a reference to them in the history. The same
+// DataConverter resolves the references, so it must be used everywhere the payloads are decoded: workers, clients,
+// the replayer (worker.ReplayWorkflowHistoryWithOptions) and the test suite.
+func NewOffloadingDataConverter(inner DataConverter, options OffloadOptions) DataConverter {
+	return internal.NewOffloadingDataConverter(inner, options)
+}
+
+// NewFileBlobStore returns a BlobStore which keeps every payload in a file of dir. It is meant for tests and local
+// development.
+func NewFileBlobStore(dir string) (BlobStore, error) {
+	return internal.NewFileBlobStore(dir)
+}
+
+// ResolveOffloadedPayloads replaces the references to offloaded payloads in the given history events with the
+// payloads from the store, so tools can decode the history without the offloading DataConverter.
+func ResolveOffloadedPayloads(store BlobStore, events ...*shared.HistoryEvent) error {
+	return internal.ResolveOffloadedPayloads(store, events...)
+}
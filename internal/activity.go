@@ -58,6 +58,12 @@ type (
 	// RegisterActivityOptions consists of options for registering an activity
 	RegisterActivityOptions struct {
 		Name string
+
+		// EnableAutoHeartbeat makes the worker heartbeat on behalf of the activity while it is running, at half
+		// of the HeartbeatTimeout the activity is scheduled with. Cancellation is delivered through the activity
+		// context as usual. The worker stops heartbeating as soon as the activity calls RecordActivityHeartbeat.
+		// Optional: default false
+		EnableAutoHeartbeat bool
 	}
 
 	// ActivityOptions stores all activity-specific parameters that will be stored inside of a context.
@@ -105,17 +111,30 @@ type (
 		// Same apply to ScheduleToCloseTimeout. See more details about RetryPolicy on the doc for RetryPolicy.
 		// Optional: default is no retry
 		RetryPolicy *RetryPolicy
+
+		// EnableAutoHeartbeat - Whether the worker should heartbeat on behalf of the activity while it is running,
+		// at half of the HeartbeatTimeout. This is useful for activities that wrap calls which can't report
+		// progress. The worker stops heartbeating as soon as the activity calls RecordActivityHeartbeat. It has no
+		// effect if HeartbeatTimeout is not set.
+		// Optional: default false
+		EnableAutoHeartbeat bool
 	}
 
 	// LocalActivityOptions stores local activity specific parameters that will be stored inside of a context.
 	LocalActivityOptions struct {
-		// ScheduleToCloseTimeout - The end to end timeout for the local activity.
+		// ScheduleToCloseTimeout - The end to end timeout for the local activity. It may exceed the decision task
+		// timeout, the worker heartbeats the decision task while the local activity is running.
 		// This field is required.
 		ScheduleToCloseTimeout time.Duration
 
 		// RetryPolicy specify how to retry activity if error happens.
 		// Optional: default is no retry
 		RetryPolicy *RetryPolicy
+
+		// ShouldRetry decides whether a failure is retried according to RetryPolicy, it can look at the typed error
+		// returned by the local activity. It runs outside of the workflow, so it must not call workflow functions.
+		// Optional: default retries every error, except the ones matching RetryPolicy.NonRetriableErrorReasons.
+		ShouldRetry RetryPredicate
 	}
 )
 
@@ -222,24 +241,34 @@ func GetWorkerStopChannel(ctx context.Context) <-chan struct{} {
 // the context with error context.Canceled.
 //  TODO: we don't have a way to distinguish between the two cases when context is cancelled because
 //  context doesn't support overriding value of ctx.Error.
-//  TODO: Implement automatic heartbeating with cancellation through ctx.
 // details - the details that you provided here can be seen in the worflow when it receives TimeoutError, you
 // can check error TimeoutType()/Details().
+// For a local activity the details are not sent to the server. The __stack_trace query of the workflow shows when
+// they were recorded, and they are returned by GetHeartbeatDetails() when the local activity is retried.
+// Details of a local activity that fail to encode are logged and dropped.
 func RecordActivityHeartbeat(ctx context.Context, details ...interface{}) {
 	env := getActivityEnv(ctx)
-	if env.isLocalActivity {
-		// no-op for local activity
-		return
-	}
 	var data []byte
 	var err error
 	// We would like to be a able to pass in "nil" as part of details(that is no progress to report to)
 	if len(details) != 1 || details[0] != nil {
 		data, err = encodeArgs(getDataConverterFromActivityCtx(ctx), details)
 		if err != nil {
+			if env.isLocalActivity {
+				// heartbeats of local activities are best effort, keep the details of the previous heartbeat
+				GetActivityLogger(ctx).Warn("Failed to encode local activity heartbeat details, dropping them.", zap.Error(err))
+				return
+			}
 			panic(err)
 		}
 	}
+	if env.isLocalActivity {
+		// local activity does not talk to the server, the details are kept on the task instead
+		if env.localActivityTask != nil {
+			env.localActivityTask.recordHeartbeat(data)
+		}
+		return
+	}
 	err = env.serviceInvoker.Heartbeat(data)
 	if err != nil {
 		log := GetActivityLogger(ctx)
@@ -336,6 +365,7 @@ func WithActivityOptions(ctx Context, options ActivityOptions) Context {
 	eap.WaitForCancellation = options.WaitForCancellation
 	eap.ActivityID = common.StringPtr(options.ActivityID)
 	eap.RetryPolicy = convertRetryPolicy(options.RetryPolicy)
+	eap.EnableAutoHeartbeat = options.EnableAutoHeartbeat
 	return ctx1
 }
 
@@ -348,6 +378,7 @@ func WithLocalActivityOptions(ctx Context, options LocalActivityOptions) Context
 
 	opts.ScheduleToCloseTimeoutSeconds = common.Int32Ceil(options.ScheduleToCloseTimeout.Seconds())
 	opts.RetryPolicy = options.RetryPolicy
+	opts.ShouldRetry = options.ShouldRetry
 	return ctx1
 }
 
@@ -24,10 +24,12 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/uber-go/tally"
 	"go.uber.org/cadence/.gen/go/cadence/workflowservicetest"
 	"go.uber.org/cadence/.gen/go/shared"
 	"go.uber.org/cadence/internal/common"
@@ -68,6 +70,25 @@ func (s *activityTestSuite) TestActivityHeartbeat() {
 	RecordActivityHeartbeat(ctx, "testDetails")
 }
 
+func (s *activityTestSuite) TestLocalActivityHeartbeat_EncodeError() {
+	task := &localActivityTask{}
+	ctx := context.WithValue(context.Background(), activityEnvContextKey, &activityEnvironment{
+		isLocalActivity:   true,
+		localActivityTask: task,
+		logger:            getLogger()})
+
+	RecordActivityHeartbeat(ctx, "testDetails")
+	details, _ := task.getHeartbeat()
+	s.NotNil(details)
+
+	// details that can't be encoded are dropped instead of failing the local activity
+	s.NotPanics(func() {
+		RecordActivityHeartbeat(ctx, make(chan int))
+	})
+	dropped, _ := task.getHeartbeat()
+	s.Equal(details, dropped)
+}
+
 func (s *activityTestSuite) TestActivityHeartbeat_InternalError() {
 	ctx, cancel := context.WithCancel(context.Background())
 	invoker := newServiceInvoker([]byte("task-token"), "identity", s.service, cancel, 1, make(chan struct{}))
@@ -226,3 +247,84 @@ func (s *activityTestSuite) TestGetWorkerStopChannel() {
 	channel := GetWorkerStopChannel(ctx)
 	s.NotNil(channel)
 }
+
+func (s *activityTestSuite) TestActivityAutoHeartbeat_CancelRequested() {
+	ctx, cancel := context.WithCancel(context.Background())
+	invoker := newServiceInvoker([]byte("task-token"), "identity", s.service, cancel, 1, make(chan struct{}))
+	defer invoker.Close(false)
+
+	s.service.EXPECT().RecordActivityTaskHeartbeat(gomock.Any(), gomock.Any(), callOptions...).
+		Return(&shared.RecordActivityTaskHeartbeatResponse{CancelRequested: common.BoolPtr(true)}, nil).Times(1)
+
+	invoker.(*cadenceInvoker).startAutoHeartbeat([]byte("last-attempt-details"))
+	select {
+	case <-ctx.Done():
+		require.Equal(s.T(), context.Canceled, ctx.Err())
+	case <-time.After(2 * time.Second):
+		s.Fail("activity is not cancelled through auto heartbeat")
+	}
+}
+
+func (s *activityTestSuite) TestActivityAutoHeartbeat_StopsOnRecordActivityHeartbeat() {
+	ctx, cancel := context.WithCancel(context.Background())
+	invoker := newServiceInvoker([]byte("task-token"), "identity", s.service, cancel, 1, make(chan struct{}))
+	ctx = context.WithValue(ctx, activityEnvContextKey, &activityEnvironment{
+		serviceInvoker: invoker,
+		logger:         getLogger()})
+
+	// only the heartbeat reported by the activity itself reaches the server
+	s.service.EXPECT().RecordActivityTaskHeartbeat(gomock.Any(), gomock.Any(), callOptions...).
+		Do(func(ctx context.Context, request *shared.RecordActivityTaskHeartbeatRequest, opts ...yarpc.CallOption) {
+			require.Contains(s.T(), string(request.Details), "testDetails")
+		}).
+		Return(&shared.RecordActivityTaskHeartbeatResponse{}, nil).Times(1)
+
+	invoker.(*cadenceInvoker).startAutoHeartbeat(nil)
+	RecordActivityHeartbeat(ctx, "testDetails")
+	time.Sleep(1200 * time.Millisecond)
+	invoker.Close(false)
+}
+
+func (s *activityTestSuite) TestIsAutoHeartbeatEnabled() {
+	fn := func(ctx context.Context) error { return nil }
+	s.False(isAutoHeartbeatEnabled(&activityExecutor{name: "a", fn: fn}, false))
+	s.True(isAutoHeartbeatEnabled(&activityExecutor{name: "a", fn: fn, options: RegisterActivityOptions{EnableAutoHeartbeat: true}}, false))
+	s.True(isAutoHeartbeatEnabled(&activityExecutor{name: "a", fn: fn}, true))
+}
+
+func (s *activityTestSuite) TestSplitAutoHeartbeatHeader() {
+	header := &shared.Header{Fields: map[string][]byte{"key": []byte("value")}}
+	h, requested := splitAutoHeartbeatHeader(header)
+	s.False(requested)
+	s.Equal(header, h)
+
+	header.Fields[autoHeartbeatHeaderKey] = []byte("true")
+	h, requested = splitAutoHeartbeatHeader(header)
+	s.True(requested)
+	// propagators only see the user headers
+	s.Equal(map[string][]byte{"key": []byte("value")}, h.Fields)
+	s.Contains(header.Fields, autoHeartbeatHeaderKey)
+}
+
+func (s *activityTestSuite) TestActivityHeartbeat_FaultInjected() {
+	_, cancel := context.WithCancel(context.Background())
+	invoker := newServiceInvoker([]byte("task-token"), "identity", s.service, cancel, 1, make(chan struct{}))
+	defer invoker.Close(false)
+	invoker.(*cadenceInvoker).faultInjector = newFaultInjector(workerExecutionParameters{
+		Logger:              getLogger(),
+		MetricsScope:        tally.NoopScope,
+		FaultInjectionRules: []FaultInjectionRule{{Type: FaultTypeHeartbeatFailure, ActivityType: "faulty", Probability: 1}},
+	})
+
+	// heartbeat of other activity types reaches the server
+	s.service.EXPECT().RecordActivityTaskHeartbeat(gomock.Any(), gomock.Any(), callOptions...).
+		Return(&shared.RecordActivityTaskHeartbeatResponse{}, nil).Times(1)
+	invoker.(*cadenceInvoker).faultScope = faultScope{activityType: "healthy"}
+	s.NoError(invoker.Heartbeat([]byte("details")))
+
+	invoker2 := newServiceInvoker([]byte("task-token"), "identity", s.service, cancel, 1, make(chan struct{}))
+	defer invoker2.Close(false)
+	invoker2.(*cadenceInvoker).faultInjector = invoker.(*cadenceInvoker).faultInjector
+	invoker2.(*cadenceInvoker).faultScope = faultScope{activityType: "faulty"}
+	s.Equal(errFaultInjected, invoker2.Heartbeat([]byte("details")))
+}
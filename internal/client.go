@@ -41,6 +41,10 @@ const (
 	// QueryTypeOpenSessions is the build in query type for Client.QueryWorkflow() call. Use this query type to get all open
 	// sessions in the workflow. The result will be a list of SessionInfo encoded in the EncodedValue.
 	QueryTypeOpenSessions string = "__open_sessions"
+
+	// QueryTypeQueryTypes is the build in query type for Client.QueryWorkflow() call. Use this query type to list the
+	// query types the workflow supports. The result will be a list of QueryTypeInfo encoded in the EncodedValue.
+	QueryTypeQueryTypes string = "__query_types"
 )
 
 type (
@@ -289,8 +293,30 @@ type (
 		//  - InternalServiceError
 		//  - EntityNotExistError
 		//  - QueryFailError
+		//  - CustomError when the query handler returned one
 		QueryWorkflow(ctx context.Context, workflowID string, runID string, queryType string, args ...interface{}) (Value, error)
 
+		// UpdateWorkflow sends an update to a workflow execution and waits for its result. The update is validated
+		// by the workflow first and the call fails with UpdateRejectedError without writing any history if the workflow
+		// rejects it. Otherwise it is delivered to the update handler the workflow set through
+		// workflow.SetUpdateHandler(), and the call returns the result or the error of the handler. Use ctx to
+		// limit how long to wait for the update to complete, without a deadline on ctx it waits at most 10 minutes.
+		// The workflow keeps the outcomes of the last 1000 completed updates only, the call fails once the outcome of
+		// its update is evicted.
+		// - workflowID is required.
+		// - runID can be default(empty string). if empty string then it will pick the running execution of that
+		//   workflow ID, and the update is sent to that run even if it continues as new before the update completes.
+		// - updateName is the name of the update.
+		// - args... are the optional update parameters.
+		// The errors it can return:
+		//  - UpdateRejectedError
+		//  - BadRequestError
+		//  - InternalServiceError
+		//  - EntityNotExistError
+		//  - QueryFailError
+		//  - the error returned by the update handler
+		UpdateWorkflow(ctx context.Context, workflowID string, runID string, updateName string, args ...interface{}) (Value, error)
+
 		// QueryWorkflowWithOptions queries a given workflow execution and returns the query result synchronously.
 		// See QueryWorkflowWithOptionsRequest and QueryWorkflowWithOptionsResponse for more information.
 		// The errors it can return:
@@ -298,6 +324,7 @@ type (
 		//  - InternalServiceError
 		//  - EntityNotExistError
 		//  - QueryFailError
+		//  - CustomError when the query handler returned one
 		QueryWorkflowWithOptions(ctx context.Context, request *QueryWorkflowWithOptionsRequest) (*QueryWorkflowWithOptionsResponse, error)
 
 		// DescribeWorkflowExecution returns information about the specified workflow execution.
@@ -22,6 +22,8 @@ package backoff
 
 import (
 	"context"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -33,43 +35,100 @@ type (
 	// IsRetryable handler can be used to exclude certain errors during retry
 	IsRetryable func(error) bool
 
+	// ErrorRetryPolicy is like RetryPolicy, but it also gets the error of the last failed attempt so the delay can
+	// depend on the kind of failure.
+	ErrorRetryPolicy interface {
+		ComputeNextDelay(err error, elapsedTime time.Duration, numAttempts int) time.Duration
+	}
+
+	// retryPolicyFunc is an adapter to use a function as a RetryPolicy.
+	retryPolicyFunc func(elapsedTime time.Duration, numAttempts int) time.Duration
+
 	// ConcurrentRetrier is used for client-side throttling. It determines whether to
 	// throttle outgoing traffic in case downstream backend server rejects
 	// requests due to out-of-quota or server busy errors.
 	ConcurrentRetrier struct {
 		sync.Mutex
-		retrier      Retrier // Backoff retrier
-		failureCount int64   // Number of consecutive failures seen
+		retrier      Retrier     // Backoff retrier
+		failureCount int64       // Number of consecutive failures seen
+		isRetryable  IsRetryable // Decides which errors passed to Done count as failures
+		jitter       float64     // Fraction of each backoff which is randomized
+		lastErr      error       // Error of the last failure passed to Done
 	}
 )
 
 // Throttle Sleep if there were failures since the last success call.
 func (c *ConcurrentRetrier) Throttle() {
-	c.throttleInternal()
+	c.throttleInternal(nil)
 }
 
-func (c *ConcurrentRetrier) throttleInternal() time.Duration {
+// ThrottleWithStop is like Throttle but returns as soon as stopCh is closed.
+func (c *ConcurrentRetrier) ThrottleWithStop(stopCh <-chan struct{}) {
+	c.throttleInternal(stopCh)
+}
+
+func (c *ConcurrentRetrier) throttleInternal(stopCh <-chan struct{}) time.Duration {
 	next := done
 
 	// Check if we have failure count.
 	c.Lock()
 	if c.failureCount > 0 {
 		next = c.retrier.NextBackOff()
+		// add jitter to avoid global synchronization
+		if next > 0 && c.jitter > 0 {
+			next -= time.Duration(rand.Float64() * c.jitter * float64(next))
+		}
 	}
 	c.Unlock()
 
-	if next != done {
-		time.Sleep(next)
+	if next > 0 {
+		timer := time.NewTimer(next)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stopCh:
+		}
 	}
 
 	return next
 }
 
+// SetIsRetryable sets the classifier used by Done. Errors it rejects reset the backoff like successes.
+func (c *ConcurrentRetrier) SetIsRetryable(isRetryable IsRetryable) {
+	c.Lock()
+	defer c.Unlock()
+	c.isRetryable = isRetryable
+}
+
+// SetJitter sets the fraction, between 0 and 1, of each backoff which is randomized. Zero disables the jitter.
+func (c *ConcurrentRetrier) SetJitter(jitter float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.jitter = math.Max(0, math.Min(1, jitter))
+}
+
+// Done marks the client request as failed for retryable errors and as succeeded otherwise.
+func (c *ConcurrentRetrier) Done(err error) {
+	c.Lock()
+	isRetryable := c.isRetryable
+	c.Unlock()
+
+	if err != nil && (isRetryable == nil || isRetryable(err)) {
+		c.Lock()
+		c.lastErr = err
+		c.Unlock()
+		c.Failed()
+	} else {
+		c.Succeeded()
+	}
+}
+
 // Succeeded marks client request succeeded.
 func (c *ConcurrentRetrier) Succeeded() {
 	defer c.Unlock()
 	c.Lock()
 	c.failureCount = 0
+	c.lastErr = nil
 	c.retrier.Reset()
 }
 
@@ -86,6 +145,22 @@ func NewConcurrentRetrier(retryPolicy RetryPolicy) *ConcurrentRetrier {
 	return &ConcurrentRetrier{retrier: retrier}
 }
 
+// NewConcurrentRetrierWithErrorPolicy returns an instance of concurrent backoff retrier which passes the error of the
+// last failure passed to Done to the retry policy.
+func NewConcurrentRetrierWithErrorPolicy(retryPolicy ErrorRetryPolicy) *ConcurrentRetrier {
+	c := &ConcurrentRetrier{}
+	// the policy is called by NextBackOff, which runs with c locked
+	c.retrier = NewRetrier(retryPolicyFunc(func(elapsedTime time.Duration, numAttempts int) time.Duration {
+		return retryPolicy.ComputeNextDelay(c.lastErr, elapsedTime, numAttempts)
+	}), SystemClock)
+	return c
+}
+
+// ComputeNextDelay calls f(elapsedTime, numAttempts).
+func (f retryPolicyFunc) ComputeNextDelay(elapsedTime time.Duration, numAttempts int) time.Duration {
+	return f(elapsedTime, numAttempts)
+}
+
 // Retry function can be used to wrap any call with retry logic using the passed in policy
 func Retry(ctx context.Context, operation Operation, policy RetryPolicy, isRetryable IsRetryable) error {
 	var err error
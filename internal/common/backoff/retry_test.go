@@ -22,6 +22,7 @@ package backoff
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -163,7 +164,7 @@ func TestConcurrentRetrier(t *testing.T) {
 	a.Equal(int64(1), retrier.failureCount)
 	retrier.Succeeded()
 	a.Equal(int64(0), retrier.failureCount)
-	sleepDuration := retrier.throttleInternal()
+	sleepDuration := retrier.throttleInternal(nil)
 	a.Equal(done, sleepDuration)
 
 	// Multiple count check.
@@ -174,7 +175,7 @@ func TestConcurrentRetrier(t *testing.T) {
 	ch := make(chan time.Duration, 3)
 	go func() {
 		for i := 0; i < 3; i++ {
-			ch <- retrier.throttleInternal()
+			ch <- retrier.throttleInternal(nil)
 		}
 	}()
 	for i := 0; i < 3; i++ {
@@ -187,7 +188,7 @@ func TestConcurrentRetrier(t *testing.T) {
 	// Verify we don't have any sleep times.
 	go func() {
 		for i := 0; i < 3; i++ {
-			ch <- retrier.throttleInternal()
+			ch <- retrier.throttleInternal(nil)
 		}
 	}()
 	for i := 0; i < 3; i++ {
@@ -197,6 +198,35 @@ func TestConcurrentRetrier(t *testing.T) {
 	}
 }
 
+func TestConcurrentRetrierDone(t *testing.T) {
+	t.Parallel()
+	a := assert.New(t)
+	policy := NewExponentialRetryPolicy(time.Hour)
+	policy.SetMaximumInterval(NoInterval)
+	policy.SetExpirationInterval(NoInterval)
+	policy.SetJitter(0)
+
+	retrier := NewConcurrentRetrier(policy)
+	retrier.SetIsRetryable(func(err error) bool {
+		_, ok := err.(*someError)
+		return ok
+	})
+	retrier.Done(errors.New("not retryable"))
+	a.Equal(int64(0), retrier.failureCount)
+	retrier.Done(&someError{})
+	a.Equal(int64(1), retrier.failureCount)
+
+	// the backoff is cut short once the stop channel is closed
+	stopCh := make(chan struct{})
+	close(stopCh)
+	retrier.SetJitter(0.5)
+	next := retrier.throttleInternal(stopCh)
+	a.True(next > 30*time.Minute && next <= time.Hour, "Duration: %v", next)
+
+	retrier.Done(nil)
+	a.Equal(int64(0), retrier.failureCount)
+}
+
 func (e *someError) Error() string {
 	return "Some Error"
 }
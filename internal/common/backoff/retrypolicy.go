@@ -37,6 +37,7 @@ const (
 	defaultMaximumInterval    = 10 * time.Second
 	defaultExpirationInterval = time.Minute
 	defaultMaximumAttempts    = noMaximumAttempts
+	defaultJitter             = 0.2
 )
 
 type (
@@ -64,6 +65,7 @@ type (
 		maximumInterval    time.Duration
 		expirationInterval time.Duration
 		maximumAttempts    int
+		jitter             float64
 	}
 
 	systemClock struct{}
@@ -87,6 +89,7 @@ func NewExponentialRetryPolicy(initialInterval time.Duration) *ExponentialRetryP
 		maximumInterval:    defaultMaximumInterval,
 		expirationInterval: defaultExpirationInterval,
 		maximumAttempts:    defaultMaximumAttempts,
+		jitter:             defaultJitter,
 	}
 
 	return p
@@ -131,6 +134,11 @@ func (p *ExponentialRetryPolicy) SetMaximumAttempts(maximumAttempts int) {
 	p.maximumAttempts = maximumAttempts
 }
 
+// SetJitter sets the fraction, between 0 and 1, of each delay which is randomized. Zero disables the jitter.
+func (p *ExponentialRetryPolicy) SetJitter(jitter float64) {
+	p.jitter = math.Max(0, math.Min(1, jitter))
+}
+
 // ComputeNextDelay returns the next delay interval.  This is used by Retrier to delay calling the operation again
 func (p *ExponentialRetryPolicy) ComputeNextDelay(elapsedTime time.Duration, numAttempts int) time.Duration {
 	// Check to see if we ran out of maximum number of attempts
@@ -164,12 +172,12 @@ func (p *ExponentialRetryPolicy) ComputeNextDelay(elapsedTime time.Duration, num
 	}
 
 	// add jitter to avoid global synchronization
-	jitterPortion := int(0.2 * nextInterval)
+	jitterPortion := int(p.jitter * nextInterval)
 	// Prevent overflow
 	if jitterPortion < 1 {
 		jitterPortion = 1
 	}
-	nextInterval = nextInterval*0.8 + float64(rand.Intn(jitterPortion))
+	nextInterval = nextInterval*(1-p.jitter) + float64(rand.Intn(jitterPortion))
 
 	return time.Duration(nextInterval)
 }
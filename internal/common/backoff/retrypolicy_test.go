@@ -166,6 +166,19 @@ func TestDefaultPublishRetryPolicy(t *testing.T) {
 	}
 }
 
+func TestJitter(t *testing.T) {
+	t.Parallel()
+	policy := createPolicy(time.Second)
+	policy.SetJitter(0)
+	r, _ := createRetrier(policy)
+	assert.Equal(t, time.Second, r.NextBackOff())
+	assert.Equal(t, 2*time.Second, r.NextBackOff())
+
+	policy.SetJitter(0.5)
+	next := r.NextBackOff()
+	assert.True(t, next >= 2*time.Second && next < 4*time.Second, "Duration: %v", next)
+}
+
 func TestNoMaxAttempts(t *testing.T) {
 	t.Parallel()
 	policy := createPolicy(50 * time.Millisecond)
@@ -49,6 +49,18 @@ type Cache interface {
 
 	// Size returns the number of entries currently stored in the Cache
 	Size() int
+
+	// Keys returns the keys of all entries currently stored in the Cache,
+	// most recently used first
+	Keys() []string
+
+	// UpdateSize re-evaluates the size of the element stored under the given
+	// key and evicts least recently used elements if the total size exceeds
+	// the configured budget
+	UpdateSize(key string)
+
+	// Bytes returns the total size of the entries currently stored in the Cache
+	Bytes() int64
 }
 
 // Options control the behavior of the cache
@@ -66,6 +78,19 @@ type Options struct {
 	// RemovedFunc is an optional function called when an element
 	// is scheduled for deletion
 	RemovedFunc RemovedFunc
+
+	// IdleTTL controls how long an entry may stay in the cache without being
+	// accessed. Unlike TTL, the expiration is pushed back on every access.
+	IdleTTL time.Duration
+
+	// MaxBytes is the budget for the total size of all entries as reported
+	// by SizeFunc. Least recently used entries are evicted to stay within the
+	// budget. Zero means no limit.
+	MaxBytes int64
+
+	// SizeFunc is an optional function that reports the size of an element
+	// in bytes. It is required for MaxBytes to take effect.
+	SizeFunc SizeFunc
 }
 
 // RemovedFunc is a type for notifying applications when an item is
@@ -73,3 +98,8 @@ type Options struct {
 // appropriate signature and i is the interface{} scheduled for
 // deletion, Cache calls go f(i)
 type RemovedFunc func(interface{})
+
+// SizeFunc is a type for reporting the size of an element stored in the
+// Cache. The Cache calls it with its internal lock held, so f must not call
+// back into the Cache.
+type SizeFunc func(interface{}) int64
@@ -39,8 +39,12 @@ type lru struct {
 	byKey    map[string]*list.Element
 	maxSize  int
 	ttl      time.Duration
+	idleTTL  time.Duration
 	pin      bool
 	rmFunc   RemovedFunc
+	sizeFunc SizeFunc
+	maxBytes int64
+	bytes    int64
 }
 
 // New creates a new cache with the given options
@@ -53,9 +57,12 @@ func New(maxSize int, opts *Options) Cache {
 		byAccess: list.New(),
 		byKey:    make(map[string]*list.Element, opts.InitialCapacity),
 		ttl:      opts.TTL,
+		idleTTL:  opts.IdleTTL,
 		maxSize:  maxSize,
 		pin:      opts.Pin,
 		rmFunc:   opts.RemovedFunc,
+		sizeFunc: opts.SizeFunc,
+		maxBytes: opts.MaxBytes,
 	}
 }
 
@@ -97,16 +104,14 @@ func (c *lru) Get(key string) interface{} {
 		cacheEntry.refCount++
 	}
 
-	if cacheEntry.refCount == 0 && !cacheEntry.expiration.IsZero() && time.Now().After(cacheEntry.expiration) {
+	now := time.Now()
+	if cacheEntry.refCount == 0 && c.isExpired(cacheEntry, now) {
 		// Entry has expired
-		if c.rmFunc != nil {
-			go c.rmFunc(cacheEntry.value)
-		}
-		c.byAccess.Remove(elt)
-		delete(c.byKey, cacheEntry.key)
+		c.deleteInternal(elt)
 		return nil
 	}
 
+	cacheEntry.lastAccess = now
 	c.byAccess.MoveToFront(elt)
 	return cacheEntry.value
 }
@@ -142,11 +147,7 @@ func (c *lru) Delete(key string) {
 
 	elt := c.byKey[key]
 	if elt != nil {
-		entry := c.byAccess.Remove(elt).(*cacheEntry)
-		if c.rmFunc != nil {
-			go c.rmFunc(entry.value)
-		}
-		delete(c.byKey, key)
+		c.deleteInternal(elt)
 	}
 }
 
@@ -165,35 +166,90 @@ func (c *lru) Size() int {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	c.evictIdle(time.Now())
 	return len(c.byKey)
 }
 
+// Keys returns the keys of all entries currently in the lru, most recently used first
+func (c *lru) Keys() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.evictIdle(time.Now())
+	keys := make([]string, 0, len(c.byKey))
+	for elt := c.byAccess.Front(); elt != nil; elt = elt.Next() {
+		keys = append(keys, elt.Value.(*cacheEntry).key)
+	}
+	return keys
+}
+
+// UpdateSize re-evaluates the size of the entry stored under the given key
+func (c *lru) UpdateSize(key string) {
+	if c.sizeFunc == nil {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	elt := c.byKey[key]
+	if elt == nil {
+		return
+	}
+
+	entry := elt.Value.(*cacheEntry)
+	size := c.sizeFunc(entry.value)
+	c.bytes += size - entry.size
+	entry.size = size
+	c.evictIdle(time.Now())
+	c.evictBytes()
+}
+
+// Bytes returns the total size of all entries currently in the lru
+func (c *lru) Bytes() int64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.bytes
+}
+
 // Put puts a new value associated with a given key, returning the existing value (if present)
 // allowUpdate flag is used to control overwrite behavior if the value exists
 func (c *lru) putInternal(key string, value interface{}, allowUpdate bool) (interface{}, error) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	now := time.Now()
 	elt := c.byKey[key]
 	if elt != nil {
 		entry := elt.Value.(*cacheEntry)
 		existing := entry.value
 		if allowUpdate {
 			entry.value = value
+			if c.sizeFunc != nil {
+				size := c.sizeFunc(value)
+				c.bytes += size - entry.size
+				entry.size = size
+			}
 		}
 		if c.ttl != 0 {
-			entry.expiration = time.Now().Add(c.ttl)
+			entry.expiration = now.Add(c.ttl)
 		}
+		entry.lastAccess = now
 		c.byAccess.MoveToFront(elt)
 		if c.pin {
 			entry.refCount++
 		}
+		c.evictBytes()
 		return existing, nil
 	}
 
+	c.evictIdle(now)
+
 	entry := &cacheEntry{
-		key:   key,
-		value: value,
+		key:        key,
+		value:      value,
+		lastAccess: now,
 	}
 
 	if c.pin {
@@ -201,14 +257,14 @@ func (c *lru) putInternal(key string, value interface{}, allowUpdate bool) (inte
 	}
 
 	if c.ttl != 0 {
-		entry.expiration = time.Now().Add(c.ttl)
+		entry.expiration = now.Add(c.ttl)
 	}
 
 	c.byKey[key] = c.byAccess.PushFront(entry)
 	if len(c.byKey) == c.maxSize {
-		oldest := c.byAccess.Back().Value.(*cacheEntry)
+		oldest := c.byAccess.Back()
 
-		if oldest.refCount > 0 {
+		if oldest.Value.(*cacheEntry).refCount > 0 {
 			// Cache is full with pinned elements
 			// revert the insert and return
 			c.byAccess.Remove(c.byAccess.Front())
@@ -216,19 +272,73 @@ func (c *lru) putInternal(key string, value interface{}, allowUpdate bool) (inte
 			return nil, ErrCacheFull
 		}
 
-		c.byAccess.Remove(c.byAccess.Back())
-		if c.rmFunc != nil {
-			go c.rmFunc(oldest.value)
-		}
-		delete(c.byKey, oldest.key)
+		c.deleteInternal(oldest)
+	}
+
+	if c.sizeFunc != nil {
+		entry.size = c.sizeFunc(value)
+		c.bytes += entry.size
+		c.evictBytes()
 	}
 
 	return nil, nil
 }
 
+func (c *lru) isExpired(entry *cacheEntry, now time.Time) bool {
+	if !entry.expiration.IsZero() && now.After(entry.expiration) {
+		return true
+	}
+	return c.idleTTL != 0 && now.Sub(entry.lastAccess) > c.idleTTL
+}
+
+// evictIdle removes entries which have not been accessed within idleTTL. The access list is ordered by last
+// access time, so it stops at the first entry from the back that is still fresh.
+func (c *lru) evictIdle(now time.Time) {
+	if c.idleTTL == 0 {
+		return
+	}
+	for elt := c.byAccess.Back(); elt != nil; {
+		entry := elt.Value.(*cacheEntry)
+		if now.Sub(entry.lastAccess) <= c.idleTTL {
+			return
+		}
+		prev := elt.Prev()
+		if entry.refCount == 0 {
+			c.deleteInternal(elt)
+		}
+		elt = prev
+	}
+}
+
+// evictBytes removes least recently used entries until the total size fits within maxBytes. The most recently
+// used entry is never evicted, so a single oversized entry can still be cached.
+func (c *lru) evictBytes() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for elt := c.byAccess.Back(); elt != nil && elt != c.byAccess.Front() && c.bytes > c.maxBytes; {
+		prev := elt.Prev()
+		if elt.Value.(*cacheEntry).refCount == 0 {
+			c.deleteInternal(elt)
+		}
+		elt = prev
+	}
+}
+
+func (c *lru) deleteInternal(elt *list.Element) {
+	entry := c.byAccess.Remove(elt).(*cacheEntry)
+	c.bytes -= entry.size
+	delete(c.byKey, entry.key)
+	if c.rmFunc != nil {
+		go c.rmFunc(entry.value)
+	}
+}
+
 type cacheEntry struct {
 	key        string
 	expiration time.Time
+	lastAccess time.Time
 	value      interface{}
 	refCount   int
+	size       int64
 }
@@ -154,3 +154,56 @@ func TestRemovedFuncWithTTL(t *testing.T) {
 		t.Error("RemovedFunc did not send true on channel ch")
 	}
 }
+
+func TestLRUWithIdleTTL(t *testing.T) {
+	cache := New(5, &Options{
+		IdleTTL: time.Millisecond * 100,
+	})
+	cache.Put("A", "foo")
+	cache.Put("B", "bar")
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 50)
+		assert.Equal(t, "foo", cache.Get("A"))
+	}
+	assert.Nil(t, cache.Get("B"))
+	assert.Equal(t, []string{"A"}, cache.Keys())
+
+	time.Sleep(time.Millisecond * 150)
+	assert.Equal(t, 0, cache.Size())
+}
+
+func TestLRUWithMaxBytes(t *testing.T) {
+	sizes := map[string]int64{"A": 10, "B": 20, "C": 30}
+	cache := New(5, &Options{
+		MaxBytes: 50,
+		SizeFunc: func(i interface{}) int64 {
+			return sizes[i.(string)]
+		},
+	})
+
+	cache.Put("A", "A")
+	cache.Put("B", "B")
+	assert.Equal(t, int64(30), cache.Bytes())
+	assert.Equal(t, []string{"B", "A"}, cache.Keys())
+
+	// A is the least recently used entry, so it goes first
+	cache.Put("C", "C")
+	assert.Equal(t, int64(50), cache.Bytes())
+	assert.Equal(t, []string{"C", "B"}, cache.Keys())
+
+	// growing B pushes C out since B was just touched
+	cache.Get("B")
+	sizes["B"] = 40
+	cache.UpdateSize("B")
+	assert.Equal(t, int64(40), cache.Bytes())
+	assert.Equal(t, []string{"B"}, cache.Keys())
+
+	// the most recently used entry is kept even if it is over budget on its own
+	sizes["B"] = 100
+	cache.UpdateSize("B")
+	assert.Equal(t, int64(100), cache.Bytes())
+	assert.Equal(t, 1, cache.Size())
+
+	cache.Delete("B")
+	assert.Equal(t, int64(0), cache.Bytes())
+}
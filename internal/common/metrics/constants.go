@@ -80,20 +80,27 @@ const (
 
 	UnhandledSignalsCounter = CadenceMetricsPrefix + "unhandled-signals"
 	CorruptedSignalsCounter = CadenceMetricsPrefix + "corrupted-signals"
+	WorkflowDeadlockCounter = CadenceMetricsPrefix + "workflow-deadlock"
 
-	WorkerStartCounter = CadenceMetricsPrefix + "worker-start"
-	PollerStartCounter = CadenceMetricsPrefix + "poller-start"
+	WorkerStartCounter   = CadenceMetricsPrefix + "worker-start"
+	PollerStartCounter   = CadenceMetricsPrefix + "poller-start"
+	FaultInjectedCounter = CadenceMetricsPrefix + "fault-injected"
 
 	CadenceRequest        = CadenceMetricsPrefix + "request"
 	CadenceError          = CadenceMetricsPrefix + "error"
 	CadenceLatency        = CadenceMetricsPrefix + "latency"
 	CadenceInvalidRequest = CadenceMetricsPrefix + "invalid-request"
 
-	StickyCacheHit   = CadenceMetricsPrefix + "sticky-cache-hit"
-	StickyCacheMiss  = CadenceMetricsPrefix + "sticky-cache-miss"
-	StickyCacheEvict = CadenceMetricsPrefix + "sticky-cache-evict"
-	StickyCacheStall = CadenceMetricsPrefix + "sticky-cache-stall"
-	StickyCacheSize  = CadenceMetricsPrefix + "sticky-cache-size"
+	StickyCacheHit         = CadenceMetricsPrefix + "sticky-cache-hit"
+	StickyCacheMiss        = CadenceMetricsPrefix + "sticky-cache-miss"
+	StickyCacheEvict       = CadenceMetricsPrefix + "sticky-cache-evict"
+	StickyCacheStall       = CadenceMetricsPrefix + "sticky-cache-stall"
+	StickyCacheSize        = CadenceMetricsPrefix + "sticky-cache-size"
+	StickyCacheMemoryBytes = CadenceMetricsPrefix + "sticky-cache-memory-bytes"
 
-	NonDeterministicError = CadenceMetricsPrefix + "non-deterministic-error"
+	NonDeterministicError          = CadenceMetricsPrefix + "non-deterministic-error"
+	InvalidSearchAttributesCounter = CadenceMetricsPrefix + "invalid-search-attributes"
+
+	PayloadCompressedCounter     = CadenceMetricsPrefix + "payload-compressed"
+	PayloadCompressionBytesSaved = CadenceMetricsPrefix + "payload-compression-bytes-saved"
 )
@@ -0,0 +1,213 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/internal/common/metrics"
+)
+
+const (
+	// CompressionAlgorithmGzip compresses payloads with gzip.
+	CompressionAlgorithmGzip CompressionAlgorithm = iota
+	// CompressionAlgorithmZlib compresses payloads with zlib.
+	CompressionAlgorithmZlib
+)
+
+// defaultCompressionThreshold is the payload size above which payloads are compressed when no threshold is set.
+const defaultCompressionThreshold = 1024
+
+// compressedPayloadMagic marks a compressed payload, it is followed by one byte for the algorithm and the compressed
+// data. Neither JSON nor thrift binary encoding can start with these bytes, so payloads written before the converter
+// was enabled are still decoded by the inner converter. A single []byte is passed through by the default
+// DataConverter as is, so new uncompressed payloads starting with these bytes are marked as stored uncompressed, while
+// such payloads written before the converter was enabled can't be decoded.
+var compressedPayloadMagic = []byte{0x00, 0xff, 'C', 'Z'}
+
+// compressionAlgorithmNone marks a payload stored uncompressed behind compressedPayloadMagic.
+const compressionAlgorithmNone = 0xff
+
+type (
+	// CompressionAlgorithm is the algorithm used by the compressing DataConverter.
+	CompressionAlgorithm int
+
+	// CompressionOptions configures the DataConverter returned by NewCompressingDataConverter.
+	CompressionOptions struct {
+		// Threshold is the payload size in bytes above which payloads are compressed. Payloads which would not get
+		// smaller are stored uncompressed.
+		// Optional: default 1024 bytes.
+		Threshold int
+
+		// Algorithm used to compress new payloads. Payloads compressed with any supported algorithm are decoded.
+		// Optional: default CompressionAlgorithmGzip.
+		Algorithm CompressionAlgorithm
+
+		// MetricsScope reports the number of compressed payloads and the bytes saved by compression.
+		// Optional: default no metrics.
+		MetricsScope tally.Scope
+	}
+
+	compressingDataConverter struct {
+		inner        DataConverter
+		threshold    int
+		algorithm    CompressionAlgorithm
+		metricsScope tally.Scope
+	}
+)
+
+// NewCompressingDataConverter returns a DataConverter which compresses the payloads produced by the inner
+// DataConverter when they are larger than the threshold. Payloads without the compression marker are passed to the
+// inner DataConverter as is, so histories written before the converter was enabled still replay.
+// The returned DataConverter can be used in worker and client options, and with workflow.WithDataConverter.
+func NewCompressingDataConverter(inner DataConverter, options CompressionOptions) DataConverter {
+	if inner == nil {
+		inner = getDefaultDataConverter()
+	}
+	threshold := options.Threshold
+	if threshold <= 0 {
+		threshold = defaultCompressionThreshold
+	}
+	metricsScope := options.MetricsScope
+	if metricsScope == nil {
+		metricsScope = tally.NoopScope
+	}
+	return &compressingDataConverter{
+		inner:        inner,
+		threshold:    threshold,
+		algorithm:    options.Algorithm,
+		metricsScope: metricsScope,
+	}
+}
+
+func (dc *compressingDataConverter) forWorkflow(workflowID string) DataConverter {
+	scoped := *dc
+	scoped.inner = dataConverterForWorkflow(dc.inner, workflowID)
+	return &scoped
+}
+
+func (dc *compressingDataConverter) ToData(values ...interface{}) ([]byte, error) {
+	data, err := dc.inner.ToData(values...)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= dc.threshold {
+		return markUncompressedPayload(data), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(compressedPayloadMagic)
+	buf.WriteByte(byte(dc.algorithm))
+	w, err := newCompressionWriter(dc.algorithm, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return markUncompressedPayload(data), nil
+	}
+
+	dc.metricsScope.Counter(metrics.PayloadCompressedCounter).Inc(1)
+	dc.metricsScope.Counter(metrics.PayloadCompressionBytesSaved).Inc(int64(len(data) - buf.Len()))
+	return buf.Bytes(), nil
+}
+
+func (dc *compressingDataConverter) FromData(input []byte, valuePtr ...interface{}) error {
+	data, err := decompressPayload(input)
+	if err != nil {
+		return err
+	}
+	return dc.inner.FromData(data, valuePtr...)
+}
+
+func (dc *compressingDataConverter) unwrapPayload(data []byte) ([]byte, DataConverter, error) {
+	data, err := decompressPayload(data)
+	return data, dc.inner, err
+}
+
+// decompressPayload returns the payload of the inner DataConverter.
+func decompressPayload(input []byte) ([]byte, error) {
+	if !isCompressedPayload(input) {
+		return input, nil
+	}
+
+	headerLen := len(compressedPayloadMagic) + 1
+	if input[headerLen-1] == compressionAlgorithmNone {
+		return input[headerLen:], nil
+	}
+	r, err := newCompressionReader(CompressionAlgorithm(input[headerLen-1]), bytes.NewReader(input[headerLen:]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress payload: %v", err)
+	}
+	return data, nil
+}
+
+// markUncompressedPayload prepends the header of an uncompressed payload to the payloads which would otherwise be
+// decoded as compressed.
+func markUncompressedPayload(data []byte) []byte {
+	if !isCompressedPayload(data) {
+		return data
+	}
+	marked := append(append([]byte{}, compressedPayloadMagic...), compressionAlgorithmNone)
+	return append(marked, data...)
+}
+
+func isCompressedPayload(data []byte) bool {
+	return len(data) > len(compressedPayloadMagic) && bytes.HasPrefix(data, compressedPayloadMagic)
+}
+
+func newCompressionWriter(algorithm CompressionAlgorithm, w io.Writer) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionAlgorithmGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionAlgorithmZlib:
+		return zlib.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %v", algorithm)
+	}
+}
+
+func newCompressionReader(algorithm CompressionAlgorithm, r io.Reader) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionAlgorithmGzip:
+		return gzip.NewReader(r)
+	case CompressionAlgorithmZlib:
+		return zlib.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %v", algorithm)
+	}
+}
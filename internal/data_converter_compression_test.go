@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal/common"
+	"go.uber.org/cadence/internal/common/metrics"
+)
+
+func TestCompressingDataConverter(t *testing.T) {
+	t.Parallel()
+	large := strings.Repeat("cadence ", 1000)
+	for _, algorithm := range []CompressionAlgorithm{CompressionAlgorithmGzip, CompressionAlgorithmZlib} {
+		scope := tally.NewTestScope("", nil)
+		dc := NewCompressingDataConverter(nil, CompressionOptions{Algorithm: algorithm, MetricsScope: scope})
+
+		data, err := dc.ToData(large, 42)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(data, compressedPayloadMagic))
+		uncompressed, err := getDefaultDataConverter().ToData(large, 42)
+		require.NoError(t, err)
+		require.True(t, len(data) < len(uncompressed))
+
+		var str string
+		var num int
+		require.NoError(t, dc.FromData(data, &str, &num))
+		require.Equal(t, large, str)
+		require.Equal(t, 42, num)
+
+		counters := scope.Snapshot().Counters()
+		require.Equal(t, int64(1), counters[metrics.PayloadCompressedCounter+"+"].Value())
+		require.Equal(t, int64(len(uncompressed)-len(data)), counters[metrics.PayloadCompressionBytesSaved+"+"].Value())
+	}
+}
+
+func TestCompressingDataConverter_Uncompressed(t *testing.T) {
+	t.Parallel()
+	dc := NewCompressingDataConverter(getDefaultDataConverter(), CompressionOptions{Threshold: 100})
+
+	// small payloads are left as they are
+	data, err := dc.ToData("small")
+	require.NoError(t, err)
+	legacy, err := getDefaultDataConverter().ToData("small")
+	require.NoError(t, err)
+	require.Equal(t, legacy, data)
+
+	// payloads written without compression, including thrift ones, are still decoded
+	thriftLegacy, err := getDefaultDataConverter().ToData(&shared.WorkflowType{Name: common.StringPtr(strings.Repeat("t", 200))})
+	require.NoError(t, err)
+	var workflowType shared.WorkflowType
+	require.NoError(t, dc.FromData(thriftLegacy, &workflowType))
+	require.Equal(t, strings.Repeat("t", 200), workflowType.GetName())
+
+	// payloads which do not get smaller are not compressed
+	random := make([]byte, 512)
+	rand.New(rand.NewSource(1)).Read(random)
+	data, err = dc.ToData(random)
+	require.NoError(t, err)
+	require.Equal(t, random, data)
+
+	// raw bytes which look like a compressed payload are marked as uncompressed
+	magicBytes := append(append([]byte{}, compressedPayloadMagic...), 1, 2, 3)
+	data, err = dc.ToData(magicBytes)
+	require.NoError(t, err)
+	require.NotEqual(t, magicBytes, data)
+	var decoded []byte
+	require.NoError(t, dc.FromData(data, &decoded))
+	require.Equal(t, magicBytes, decoded)
+
+	err = dc.FromData(append(append([]byte{}, compressedPayloadMagic...), 9, 1, 2), new(string))
+	require.EqualError(t, err, "unknown compression algorithm 9")
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_CompressingDataConverter() {
+	dc := NewCompressingDataConverter(nil, CompressionOptions{Threshold: 10})
+	workflowFn := func(ctx Context, name string) (string, error) {
+		ctx = WithActivityOptions(ctx, s.activityOptions)
+		var result string
+		err := ExecuteActivity(WithDataConverter(ctx, dc), testActivityHello, name).Get(ctx, &result)
+		return result, err
+	}
+
+	RegisterWorkflow(workflowFn)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(WorkerOptions{DataConverter: dc})
+	env.ExecuteWorkflow(workflowFn, strings.Repeat("world ", 100))
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("hello_"+strings.Repeat("world ", 100), result)
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encryptedPayloadMagic marks an encrypted payload. It is followed by one byte with the length of the key ID, the key
+// ID, the nonce and the AES-GCM sealed data. The key ID is authenticated along with the data.
+var encryptedPayloadMagic = []byte{0x00, 0xff, 'E', 'N'}
+
+var errPayloadNotEncrypted = errors.New("payload is not encrypted, set AllowUnencryptedPayloads to decode payloads written before encryption was enabled")
+
+type (
+	// KeyProvider provides the AES keys used by the encrypting DataConverter. Keys must be 16, 24 or 32 bytes long to
+	// select AES-128, AES-192 or AES-256. A key must stay available through GetDecryptionKey for as long as histories
+	// encrypted with it can be replayed or queried.
+	KeyProvider interface {
+		// GetEncryptionKey returns the key used to encrypt new payloads along with its ID. The ID is stored in the
+		// payload and is at most 255 bytes long.
+		GetEncryptionKey() (keyID string, key []byte, err error)
+		// GetDecryptionKey returns the key with the given ID.
+		GetDecryptionKey(keyID string) ([]byte, error)
+	}
+
+	// EncryptionOptions configures the DataConverter returned by NewEncryptingDataConverter.
+	EncryptionOptions struct {
+		// KeyProvider provides the keys to encrypt and decrypt payloads.
+		// This field is required.
+		KeyProvider KeyProvider
+
+		// AllowUnencryptedPayloads makes the DataConverter decode payloads which are not encrypted instead of failing.
+		// Enable it while migrating workflows which were started before encryption was turned on.
+		// Optional: default false
+		AllowUnencryptedPayloads bool
+	}
+
+	encryptingDataConverter struct {
+		inner                    DataConverter
+		keyProvider              KeyProvider
+		allowUnencryptedPayloads bool
+	}
+
+	staticKeyProvider struct {
+		currentKeyID string
+		keys         map[string][]byte
+	}
+
+	// payloadUnwrapper is implemented by the DataConverters which wrap the payloads of an inner DataConverter. It
+	// returns the payload of the inner DataConverter and the inner DataConverter.
+	payloadUnwrapper interface {
+		unwrapPayload(data []byte) ([]byte, DataConverter, error)
+	}
+)
+
+// NewEncryptingDataConverter returns a DataConverter which encrypts the payloads produced by the inner DataConverter
+// with AES-GCM. The ID of the key is stored in each payload so payloads encrypted with a rotated key still decode as
+// long as the KeyProvider returns it. Decoding fails when the key is not available, or when the payload is not encrypted
+// and AllowUnencryptedPayloads is not set.
+// To combine it with compression, wrap the compressing DataConverter, as encrypted data does not compress.
+func NewEncryptingDataConverter(inner DataConverter, options EncryptionOptions) DataConverter {
+	if inner == nil {
+		inner = getDefaultDataConverter()
+	}
+	if options.KeyProvider == nil {
+		panic("NewEncryptingDataConverter: KeyProvider is required")
+	}
+	return &encryptingDataConverter{
+		inner:                    inner,
+		keyProvider:              options.KeyProvider,
+		allowUnencryptedPayloads: options.AllowUnencryptedPayloads,
+	}
+}
+
+// NewStaticKeyProvider returns a KeyProvider which encrypts with the key of currentKeyID and decrypts with any of the
+// given keys. Keep the retired keys in the map after rotating to a new one.
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) KeyProvider {
+	return &staticKeyProvider{currentKeyID: currentKeyID, keys: keys}
+}
+
+func (p *staticKeyProvider) GetEncryptionKey() (string, []byte, error) {
+	key, err := p.GetDecryptionKey(p.currentKeyID)
+	return p.currentKeyID, key, err
+}
+
+func (p *staticKeyProvider) GetDecryptionKey(keyID string) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", keyID)
+	}
+	return key, nil
+}
+
+func (dc *encryptingDataConverter) forWorkflow(workflowID string) DataConverter {
+	scoped := *dc
+	scoped.inner = dataConverterForWorkflow(dc.inner, workflowID)
+	return &scoped
+}
+
+func (dc *encryptingDataConverter) ToData(values ...interface{}) ([]byte, error) {
+	data, err := dc.inner.ToData(values...)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, key, err := dc.keyProvider.GetEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get encryption key: %v", err)
+	}
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("encryption key ID %q is longer than 255 bytes", keyID)
+	}
+	aead, err := newAEAD(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %v", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encryptedPayloadMagic)
+	buf.WriteByte(byte(len(keyID)))
+	buf.WriteString(keyID)
+	buf.Write(nonce)
+	return aead.Seal(buf.Bytes(), nonce, data, []byte(keyID)), nil
+}
+
+func (dc *encryptingDataConverter) FromData(input []byte, valuePtr ...interface{}) error {
+	data, err := dc.decrypt(input)
+	if err != nil {
+		return err
+	}
+	return dc.inner.FromData(data, valuePtr...)
+}
+
+func (dc *encryptingDataConverter) unwrapPayload(data []byte) ([]byte, DataConverter, error) {
+	data, err := dc.decrypt(data)
+	return data, dc.inner, err
+}
+
+// decrypt returns the payload of the inner DataConverter.
+func (dc *encryptingDataConverter) decrypt(input []byte) ([]byte, error) {
+	if !bytes.HasPrefix(input, encryptedPayloadMagic) {
+		if len(input) > 0 && !dc.allowUnencryptedPayloads {
+			return nil, errPayloadNotEncrypted
+		}
+		return input, nil
+	}
+
+	header := input[len(encryptedPayloadMagic):]
+	if len(header) == 0 || len(header) < 1+int(header[0]) {
+		return nil, errors.New("malformed encrypted payload")
+	}
+	keyIDLen := int(header[0])
+	keyID := string(header[1 : 1+keyIDLen])
+	sealed := header[1+keyIDLen:]
+
+	key, err := dc.keyProvider.GetDecryptionKey(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get decryption key %q: %v", keyID, err)
+	}
+	aead, err := newAEAD(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted payload")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt payload with key %q: %v", keyID, err)
+	}
+	return data, nil
+}
+
+// isSamePayload returns true if a and b are the same payload once unwrapped from the DataConverters wrapping the
+// payloads of another one, like the encrypting DataConverter which never produces the same payload twice.
+func isSamePayload(dc DataConverter, a, b []byte) bool {
+	for !bytes.Equal(a, b) {
+		unwrapper, ok := dc.(payloadUnwrapper)
+		if !ok {
+			return false
+		}
+		var errA, errB error
+		a, dc, errA = unwrapper.unwrapPayload(a)
+		b, _, errB = unwrapper.unwrapPayload(b)
+		if errA != nil || errB != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newAEAD(keyID string, key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("encryption key %q is missing", keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %q: %v", keyID, err)
+	}
+	return cipher.NewGCM(block)
+}
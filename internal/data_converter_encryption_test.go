@@ -0,0 +1,204 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal/common"
+)
+
+func testEncryptionKeys() map[string][]byte {
+	return map[string][]byte{
+		"key-1": bytes.Repeat([]byte{1}, 16),
+		"key-2": bytes.Repeat([]byte{2}, 32),
+	}
+}
+
+func TestEncryptingDataConverter(t *testing.T) {
+	t.Parallel()
+	dc := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-1", testEncryptionKeys())})
+
+	data, err := dc.ToData("secret-ssn", 42)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, []byte("secret-ssn")))
+	require.True(t, bytes.Contains(data, []byte("key-1")))
+
+	var str string
+	var num int
+	require.NoError(t, dc.FromData(data, &str, &num))
+	require.Equal(t, "secret-ssn", str)
+	require.Equal(t, 42, num)
+
+	// after the rotation new payloads use key-2 and the ones encrypted with key-1 still decode
+	rotated := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-2", testEncryptionKeys())})
+	newData, err := rotated.ToData("secret-ssn")
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(newData, []byte("key-2")))
+	require.NoError(t, rotated.FromData(data, &str, &num))
+	require.Equal(t, "secret-ssn", str)
+
+	// key-1 is retired
+	retired := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-2", map[string][]byte{
+		"key-2": testEncryptionKeys()["key-2"],
+	})})
+	err = retired.FromData(data, &str, &num)
+	require.EqualError(t, err, `unable to get decryption key "key-1": unknown key ID "key-1"`)
+
+	// tampered payloads are rejected
+	data[len(data)-1] ^= 0xff
+	err = dc.FromData(data, &str, &num)
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), `unable to decrypt payload with key "key-1"`))
+}
+
+func TestEncryptingDataConverter_Unencrypted(t *testing.T) {
+	t.Parallel()
+	plain, err := getDefaultDataConverter().ToData("legacy")
+	require.NoError(t, err)
+
+	var str string
+	dc := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-1", testEncryptionKeys())})
+	require.Equal(t, errPayloadNotEncrypted, dc.FromData(plain, &str))
+
+	dc = NewEncryptingDataConverter(nil, EncryptionOptions{
+		KeyProvider:              NewStaticKeyProvider("key-1", testEncryptionKeys()),
+		AllowUnencryptedPayloads: true,
+	})
+	require.NoError(t, dc.FromData(plain, &str))
+	require.Equal(t, "legacy", str)
+
+	_, err = NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-3", testEncryptionKeys())}).ToData("value")
+	require.EqualError(t, err, `unable to get encryption key: unknown key ID "key-3"`)
+}
+
+func TestEncryptingDataConverter_Compressed(t *testing.T) {
+	t.Parallel()
+	dc := NewEncryptingDataConverter(
+		NewCompressingDataConverter(nil, CompressionOptions{Threshold: 10}),
+		EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-2", testEncryptionKeys())},
+	)
+	large := strings.Repeat("payload ", 1000)
+	data, err := dc.ToData(large)
+	require.NoError(t, err)
+	require.True(t, len(data) < len(large))
+
+	var str string
+	require.NoError(t, dc.FromData(data, &str))
+	require.Equal(t, large, str)
+}
+
+func testReplayWorkflowEncrypted(ctx Context, input string, continueAsNew bool) (string, error) {
+	if continueAsNew {
+		return "", NewContinueAsNewError(ctx, testReplayWorkflowEncrypted, input+"!", false)
+	}
+	return strings.ToUpper(input), nil
+}
+
+func TestReplayWorkflowHistoryWithEncryptedPayloads(t *testing.T) {
+	RegisterWorkflow(testReplayWorkflowEncrypted)
+	dc := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-1", testEncryptionKeys())})
+
+	newHistory := func(continueAsNew bool, lastEvent *shared.HistoryEvent) *shared.History {
+		input, err := dc.ToData("a", continueAsNew)
+		require.NoError(t, err)
+		return &shared.History{Events: []*shared.HistoryEvent{
+			createTestEventWorkflowExecutionStarted(1, &shared.WorkflowExecutionStartedEventAttributes{
+				WorkflowType: &shared.WorkflowType{Name: common.StringPtr(getFunctionName(testReplayWorkflowEncrypted))},
+				TaskList:     &shared.TaskList{Name: common.StringPtr("taskList1")},
+				Input:        input,
+				// needed to continue as new
+				ExecutionStartToCloseTimeoutSeconds: common.Int32Ptr(60),
+				TaskStartToCloseTimeoutSeconds:      common.Int32Ptr(10),
+			}),
+			createTestEventDecisionTaskScheduled(2, &shared.DecisionTaskScheduledEventAttributes{}),
+			createTestEventDecisionTaskStarted(3),
+			createTestEventDecisionTaskCompleted(4, &shared.DecisionTaskCompletedEventAttributes{}),
+			lastEvent,
+		}}
+	}
+
+	// the replayed payloads are encrypted with another nonce, they are compared once decrypted
+	result, err := dc.ToData("A")
+	require.NoError(t, err)
+	history := newHistory(false, createTestEventWorkflowExecutionCompleted(5, &shared.WorkflowExecutionCompletedEventAttributes{
+		Result:                       result,
+		DecisionTaskCompletedEventId: common.Int64Ptr(4),
+	}))
+	require.NoError(t, ReplayWorkflowHistoryWithOptions(nil, history, ReplayOptions{DataConverter: dc}))
+
+	wrongResult, err := dc.ToData("B")
+	require.NoError(t, err)
+	history.Events[4].WorkflowExecutionCompletedEventAttributes.Result = wrongResult
+	require.Error(t, ReplayWorkflowHistoryWithOptions(nil, history, ReplayOptions{DataConverter: dc}))
+
+	nextInput, err := dc.ToData("a!", false)
+	require.NoError(t, err)
+	history = newHistory(true, &shared.HistoryEvent{
+		EventId:   common.Int64Ptr(5),
+		EventType: common.EventTypePtr(shared.EventTypeWorkflowExecutionContinuedAsNew),
+		WorkflowExecutionContinuedAsNewEventAttributes: &shared.WorkflowExecutionContinuedAsNewEventAttributes{
+			Input:                        nextInput,
+			DecisionTaskCompletedEventId: common.Int64Ptr(4),
+		},
+	})
+	require.NoError(t, ReplayWorkflowHistoryWithOptions(nil, history, ReplayOptions{DataConverter: dc}))
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_EncryptingDataConverter() {
+	dc := NewEncryptingDataConverter(nil, EncryptionOptions{KeyProvider: NewStaticKeyProvider("key-1", testEncryptionKeys())})
+	workflowFn := func(ctx Context) error {
+		var secret string
+		err := SetQueryHandler(ctx, "secret", func() (string, error) {
+			return secret, nil
+		})
+		if err != nil {
+			return err
+		}
+		GetSignalChannel(ctx, "secret").Receive(ctx, &secret)
+		return nil
+	}
+
+	RegisterWorkflow(workflowFn)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(WorkerOptions{DataConverter: dc})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("secret", "open sesame")
+	}, time.Minute)
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	// the query result leaves the workflow encrypted and is decrypted by the caller
+	value, err := env.QueryWorkflow("secret")
+	s.NoError(err)
+	encrypted := value.(*EncodedValue).value
+	s.True(bytes.HasPrefix(encrypted, encryptedPayloadMagic))
+	s.False(bytes.Contains(encrypted, []byte("open sesame")))
+	var secret string
+	s.NoError(value.Get(&secret))
+	s.Equal("open sesame", secret)
+}
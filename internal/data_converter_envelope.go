@@ -0,0 +1,318 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"go.uber.org/cadence/internal/common/serializer"
+)
+
+// Encodings recorded in the payload envelope by the payload converters of the composite DataConverter.
+const (
+	// PayloadEncodingNil is the encoding of nil values, the payload has no data.
+	PayloadEncodingNil = "binary/null"
+	// PayloadEncodingRaw is the encoding of []byte values, the data is stored as is.
+	PayloadEncodingRaw = "binary/plain"
+	// PayloadEncodingThrift is the encoding of thrift structs, the data is thrift binary.
+	PayloadEncodingThrift = "thrift/binary"
+	// PayloadEncodingThriftRW is the encoding of the types generated by thriftrw, like the ones of the cadence API, the
+	// data is thrift binary.
+	PayloadEncodingThriftRW = "thriftrw/binary"
+	// PayloadEncodingJSON is the encoding of the values which are encoded as JSON.
+	PayloadEncodingJSON = "json/plain"
+)
+
+// payloadEnvelopeMagic marks a payload envelope. It is followed by the number of payloads, and the encoding and the
+// data of every payload, each one prefixed by its length. All numbers are unsigned varints, so binary data is stored
+// as is.
+var payloadEnvelopeMagic = []byte{0x00, 0xff, 'E', 'V'}
+
+type (
+	// Payload is one value of a payload envelope along with the encoding it was converted with.
+	Payload struct {
+		Encoding string
+		Data     []byte
+	}
+
+	// PayloadConverter converts single values for the composite DataConverter. The encoding it returns is recorded
+	// along with each value it converts, and it is used to find the PayloadConverter which decodes the value.
+	PayloadConverter interface {
+		// Encoding returns the name of the encoding of the values converted by this PayloadConverter.
+		Encoding() string
+		// ToPayload converts the value. It returns false if the value is not supported by this PayloadConverter.
+		ToPayload(value interface{}) (data []byte, ok bool, err error)
+		// FromPayload decodes data into the value pointed to by valuePtr.
+		FromPayload(data []byte, valuePtr interface{}) error
+	}
+
+	compositeDataConverter struct {
+		converters []PayloadConverter
+		encodings  map[string]PayloadConverter
+	}
+
+	nilPayloadConverter       struct{}
+	byteSlicePayloadConverter struct{}
+	thriftRWPayloadConverter  struct{}
+	thriftPayloadConverter    struct{}
+	jsonPayloadConverter      struct{}
+)
+
+// GetDefaultPayloadConverters returns the payload converters used by the composite DataConverter when none is given.
+// They convert nil, []byte, thriftrw generated types, thrift structs and JSON values in this order.
+func GetDefaultPayloadConverters() []PayloadConverter {
+	return []PayloadConverter{
+		nilPayloadConverter{},
+		byteSlicePayloadConverter{},
+		thriftRWPayloadConverter{},
+		thriftPayloadConverter{},
+		jsonPayloadConverter{},
+	}
+}
+
+// NewCompositeDataConverter returns a DataConverter which converts each value with the first PayloadConverter which
+// supports it, and records the encoding of every value in a payload envelope. Values are decoded with the
+// PayloadConverter of their recorded encoding, so calls mixing argument types decode reliably. Payloads which are not
+// in an envelope are decoded by the default DataConverter.
+func NewCompositeDataConverter(converters ...PayloadConverter) DataConverter {
+	if len(converters) == 0 {
+		converters = GetDefaultPayloadConverters()
+	}
+	encodings := make(map[string]PayloadConverter, len(converters))
+	for _, converter := range converters {
+		if _, ok := encodings[converter.Encoding()]; !ok {
+			encodings[converter.Encoding()] = converter
+		}
+	}
+	return &compositeDataConverter{converters: converters, encodings: encodings}
+}
+
+// DecodePayloadEnvelope returns the payloads of a payload envelope written by the composite DataConverter. It returns
+// false if data is not a payload envelope. It does not need the Go types of the values, so tools can use it to
+// inspect histories.
+func DecodePayloadEnvelope(data []byte) ([]Payload, bool, error) {
+	if !bytes.HasPrefix(data, payloadEnvelopeMagic) {
+		return nil, false, nil
+	}
+	payloads, err := readPayloads(bytes.NewReader(data[len(payloadEnvelopeMagic):]))
+	if err != nil {
+		return nil, true, fmt.Errorf("unable to decode payload envelope: %v", err)
+	}
+	return payloads, true, nil
+}
+
+func readPayloads(r *bytes.Reader) ([]Payload, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(r.Len()) {
+		// every payload takes at least one byte
+		return nil, io.ErrUnexpectedEOF
+	}
+	payloads := make([]Payload, 0, count)
+	for i := uint64(0); i < count; i++ {
+		encoding, err := readLengthPrefixed(r)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readLengthPrefixed(r)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, Payload{Encoding: string(encoding), Data: data})
+	}
+	return payloads, nil
+}
+
+func readLengthPrefixed(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func (dc *compositeDataConverter) ToData(values ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(payloadEnvelopeMagic)
+	writeUvarint(&buf, uint64(len(values)))
+	for i, value := range values {
+		payload, err := dc.toPayload(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode argument: %d, %v, with error: %v", i, reflect.TypeOf(value), err)
+		}
+		writeUvarint(&buf, uint64(len(payload.Encoding)))
+		buf.WriteString(payload.Encoding)
+		writeUvarint(&buf, uint64(len(payload.Data)))
+		buf.Write(payload.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+}
+
+func (dc *compositeDataConverter) toPayload(value interface{}) (Payload, error) {
+	for _, converter := range dc.converters {
+		data, ok, err := converter.ToPayload(value)
+		if err != nil {
+			return Payload{}, err
+		}
+		if ok {
+			return Payload{Encoding: converter.Encoding(), Data: data}, nil
+		}
+	}
+	return Payload{}, fmt.Errorf("no payload converter supports values of type %T", value)
+}
+
+func (dc *compositeDataConverter) FromData(input []byte, valuePtr ...interface{}) error {
+	payloads, ok, err := DecodePayloadEnvelope(input)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return getDefaultDataConverter().FromData(input, valuePtr...)
+	}
+	for i, ptr := range valuePtr {
+		if i >= len(payloads) {
+			return fmt.Errorf("unable to decode argument: %d, %v, with error: missing payload", i, reflect.TypeOf(ptr))
+		}
+		converter, ok := dc.encodings[payloads[i].Encoding]
+		if !ok {
+			return fmt.Errorf("unable to decode argument: %d, %v, with error: unknown payload encoding %q",
+				i, reflect.TypeOf(ptr), payloads[i].Encoding)
+		}
+		if err := converter.FromPayload(payloads[i].Data, ptr); err != nil {
+			return fmt.Errorf("unable to decode argument: %d, %v, with error: %v", i, reflect.TypeOf(ptr), err)
+		}
+	}
+	return nil
+}
+
+func (nilPayloadConverter) Encoding() string {
+	return PayloadEncodingNil
+}
+
+func (nilPayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	return nil, value == nil, nil
+}
+
+func (nilPayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	rv := reflect.ValueOf(valuePtr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("non-nil pointer is required to decode, got %T", valuePtr)
+	}
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	return nil
+}
+
+func (byteSlicePayloadConverter) Encoding() string {
+	return PayloadEncodingRaw
+}
+
+func (byteSlicePayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	data, ok := value.([]byte)
+	return data, ok, nil
+}
+
+func (byteSlicePayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	ptr, ok := valuePtr.(*[]byte)
+	if !ok || ptr == nil {
+		return fmt.Errorf("pointer to []byte is required to decode raw bytes, got %T", valuePtr)
+	}
+	*ptr = data
+	return nil
+}
+
+func (thriftRWPayloadConverter) Encoding() string {
+	return PayloadEncodingThriftRW
+}
+
+func (thriftRWPayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	obj, ok := value.(serializer.ThriftObject)
+	if !ok || reflect.ValueOf(value).Kind() != reflect.Ptr || reflect.ValueOf(value).IsNil() {
+		return nil, false, nil
+	}
+	data, err := serializer.Encode(obj)
+	return data, true, err
+}
+
+func (thriftRWPayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	// valuePtr points to a pointer to the thriftrw generated struct
+	rv := reflect.ValueOf(valuePtr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("pointer to pointer to thriftrw type is required to decode, got %T", valuePtr)
+	}
+	obj, ok := reflect.New(rv.Elem().Type().Elem()).Interface().(serializer.ThriftObject)
+	if !ok {
+		return fmt.Errorf("pointer to pointer to thriftrw type is required to decode, got %T", valuePtr)
+	}
+	if err := serializer.Decode(data, obj); err != nil {
+		return err
+	}
+	rv.Elem().Set(reflect.ValueOf(obj))
+	return nil
+}
+
+func (thriftPayloadConverter) Encoding() string {
+	return PayloadEncodingThrift
+}
+
+func (thriftPayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	if value == nil || !isThriftType(value) {
+		return nil, false, nil
+	}
+	data, err := thriftEncoding{}.Marshal([]interface{}{value})
+	return data, true, err
+}
+
+func (thriftPayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	return thriftEncoding{}.Unmarshal(data, []interface{}{valuePtr})
+}
+
+func (jsonPayloadConverter) Encoding() string {
+	return PayloadEncodingJSON
+}
+
+func (jsonPayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	data, err := json.Marshal(value)
+	return data, true, err
+}
+
+func (jsonPayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	return json.Unmarshal(data, valuePtr)
+}
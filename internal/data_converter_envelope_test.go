@@ -0,0 +1,156 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal/common"
+)
+
+type testEnvelopeStruct struct {
+	Name  string
+	Count int
+}
+
+func TestCompositeDataConverter(t *testing.T) {
+	t.Parallel()
+	dc := NewCompositeDataConverter()
+
+	workflowType := &shared.WorkflowType{Name: common.StringPtr("wf")}
+	data, err := dc.ToData("text", []byte{0x00, 0x01}, nil, workflowType, testEnvelopeStruct{Name: "n", Count: 2})
+	require.NoError(t, err)
+
+	payloads, ok, err := DecodePayloadEnvelope(data)
+	require.NoError(t, err)
+	require.True(t, ok)
+	var encodings []string
+	for _, p := range payloads {
+		encodings = append(encodings, p.Encoding)
+	}
+	require.Equal(t, []string{
+		PayloadEncodingJSON, PayloadEncodingRaw, PayloadEncodingNil, PayloadEncodingThriftRW, PayloadEncodingJSON,
+	}, encodings)
+	require.Equal(t, `"text"`, string(payloads[0].Data))
+	require.Equal(t, []byte{0x00, 0x01}, payloads[1].Data)
+
+	var str string
+	var raw []byte
+	strPtr := &str
+	var decodedType *shared.WorkflowType
+	var decodedStruct testEnvelopeStruct
+	require.NoError(t, dc.FromData(data, &str, &raw, &strPtr, &decodedType, &decodedStruct))
+	require.Equal(t, "text", str)
+	require.Equal(t, []byte{0x00, 0x01}, raw)
+	require.Nil(t, strPtr)
+	require.Equal(t, "wf", decodedType.GetName())
+	require.Equal(t, testEnvelopeStruct{Name: "n", Count: 2}, decodedStruct)
+
+	// extra payloads are ignored like with the default DataConverter, missing ones are an error
+	require.NoError(t, dc.FromData(data, &str))
+	err = dc.FromData(data, &str, &raw, &strPtr, &decodedType, &decodedStruct, &str)
+	require.EqualError(t, err, "unable to decode argument: 5, *string, with error: missing payload")
+}
+
+func TestCompositeDataConverter_BinaryFraming(t *testing.T) {
+	t.Parallel()
+	raw := make([]byte, 1024)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	data, err := NewCompositeDataConverter().ToData(raw)
+	require.NoError(t, err)
+	// magic, payload count, encoding and data lengths and the encoding name are the only overhead
+	require.Equal(t, len(payloadEnvelopeMagic)+1+1+len(PayloadEncodingRaw)+2+len(raw), len(data))
+
+	for i := len(payloadEnvelopeMagic); i < len(data); i++ {
+		_, ok, err := DecodePayloadEnvelope(data[:i])
+		require.True(t, ok)
+		require.Error(t, err, "truncated at %d", i)
+	}
+}
+
+func TestCompositeDataConverter_Errors(t *testing.T) {
+	t.Parallel()
+	data, err := NewCompositeDataConverter().ToData([]byte("raw"))
+	require.NoError(t, err)
+
+	// a converter which does not know the encoding can not guess
+	var raw []byte
+	err = NewCompositeDataConverter(jsonPayloadConverter{}).FromData(data, &raw)
+	require.EqualError(t, err, `unable to decode argument: 0, *[]uint8, with error: unknown payload encoding "binary/plain"`)
+
+	_, err = NewCompositeDataConverter(nilPayloadConverter{}).ToData("text")
+	require.EqualError(t, err, "unable to encode argument: 0, string, with error: no payload converter supports values of type string")
+
+	// payloads written by the default DataConverter are still decoded
+	legacy, err := getDefaultDataConverter().ToData("legacy", 1)
+	require.NoError(t, err)
+	_, ok, err := DecodePayloadEnvelope(legacy)
+	require.NoError(t, err)
+	require.False(t, ok)
+	var str string
+	var num int
+	require.NoError(t, NewCompositeDataConverter().FromData(legacy, &str, &num))
+	require.Equal(t, "legacy", str)
+	require.Equal(t, 1, num)
+}
+
+func testEnvelopeActivity(ctx context.Context, data []byte, workflowType *shared.WorkflowType, count int) (*shared.WorkflowType, error) {
+	return &shared.WorkflowType{Name: common.StringPtr(fmt.Sprintf("%s-%s-%d", data, workflowType.GetName(), count))}, nil
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_CompositeDataConverter() {
+	// a workflow taking []byte first gets the raw input, so it comes second
+	workflowFn := func(ctx Context, workflowType *shared.WorkflowType, data []byte) (*shared.WorkflowType, error) {
+		ctx = WithActivityOptions(ctx, s.activityOptions)
+		var result *shared.WorkflowType
+		err := ExecuteActivity(ctx, testEnvelopeActivity, data, workflowType, 3).Get(ctx, &result)
+		return result, err
+	}
+
+	RegisterWorkflow(workflowFn)
+	RegisterActivity(testEnvelopeActivity)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(WorkerOptions{DataConverter: NewCompositeDataConverter()})
+	var encodings []string
+	env.SetOnActivityStartedListener(func(activityInfo *ActivityInfo, ctx context.Context, args Values) {
+		payloads, ok, err := DecodePayloadEnvelope(args.(*EncodedValues).values)
+		s.True(ok)
+		s.NoError(err)
+		for _, payload := range payloads {
+			encodings = append(encodings, payload.Encoding)
+		}
+	})
+	env.ExecuteWorkflow(workflowFn, &shared.WorkflowType{Name: common.StringPtr("thriftrw")}, []byte("raw"))
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result *shared.WorkflowType
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("raw-thriftrw-3", result.GetName())
+	// every argument of the activity is recorded with the encoding it was converted with
+	s.Equal([]string{PayloadEncodingRaw, PayloadEncodingThriftRW, PayloadEncodingJSON}, encodings)
+}
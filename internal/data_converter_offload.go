@@ -0,0 +1,421 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"go.uber.org/cadence/.gen/go/shared"
+)
+
+// defaultOffloadThreshold is the payload size above which payloads are offloaded when no threshold is set.
+const defaultOffloadThreshold = 256 * 1024
+
+// deletedPayloadSuffix is appended to the key of a payload deleted by DeleteOffloadedPayloads to mark it as deleted.
+const deletedPayloadSuffix = ".deleted"
+
+// offloadedPayloadMagic marks a reference to an offloaded payload, it is followed by the JSON encoded reference.
+var offloadedPayloadMagic = []byte{0x00, 0xff, 'O', 'F'}
+
+type (
+	// BlobStore stores the payloads offloaded by the offloading DataConverter. Payloads are addressed by their
+	// workflow and their content, so Put is called again with the same key and data every time a workflow is
+	// replayed. It must be idempotent and should be cheap when the key already exists. Get must fail for keys
+	// which are not stored.
+	BlobStore interface {
+		// Put stores data under key.
+		Put(key string, data []byte) error
+		// Get returns the data stored under key.
+		Get(key string) ([]byte, error)
+		// Delete removes the data stored under key. Deleting a missing key is not an error.
+		Delete(key string) error
+	}
+
+	// OffloadOptions configures the DataConverter returned by NewOffloadingDataConverter.
+	OffloadOptions struct {
+		// Store keeps the offloaded payloads.
+		// This field is required.
+		Store BlobStore
+
+		// Threshold is the payload size in bytes above which payloads are offloaded to the Store.
+		// Optional: default 256KB.
+		Threshold int
+
+		// KeyPrefix is prepended to the keys of the offloaded payloads, use it to separate the payloads of different
+		// applications sharing a Store.
+		// Optional: default no prefix.
+		KeyPrefix string
+	}
+
+	offloadingDataConverter struct {
+		inner      DataConverter
+		store      BlobStore
+		threshold  int
+		keyPrefix  string
+		workflowID string
+	}
+
+	offloadedPayloadReference struct {
+		Key        string `json:"key"`
+		Size       int    `json:"size"`
+		SHA256     string `json:"sha256"`
+		WorkflowID string `json:"workflowID,omitempty"`
+	}
+
+	// workflowScopedDataConverter is implemented by the DataConverters which keep the payloads of every workflow
+	// apart, and by the DataConverters wrapping them.
+	workflowScopedDataConverter interface {
+		forWorkflow(workflowID string) DataConverter
+	}
+
+	// historyGetter is the part of Client needed by DeleteOffloadedPayloads.
+	historyGetter interface {
+		GetWorkflowHistory(ctx context.Context, workflowID string, runID string, isLongPoll bool,
+			filterType shared.HistoryEventFilterType) HistoryEventIterator
+	}
+
+	fileBlobStore struct {
+		dir string
+	}
+)
+
+// NewOffloadingDataConverter returns a DataConverter which stores the payloads produced by the inner DataConverter in
+// a BlobStore when they are larger than the threshold, and records only a reference to them in the history. The same
+// DataConverter resolves the references, so it must be used everywhere the payloads are decoded: workers, clients,
+// the replayer and the test suite.
+// The payloads encoded by workers and clients for a workflow are stored under keys of that workflow, so they can be
+// deleted with DeleteOffloadedPayloads once it is closed. They are stored again every time the workflow is replayed,
+// unless they were deleted.
+func NewOffloadingDataConverter(inner DataConverter, options OffloadOptions) DataConverter {
+	if inner == nil {
+		inner = getDefaultDataConverter()
+	}
+	if options.Store == nil {
+		panic("NewOffloadingDataConverter: Store is required")
+	}
+	threshold := options.Threshold
+	if threshold <= 0 {
+		threshold = defaultOffloadThreshold
+	}
+	return &offloadingDataConverter{
+		inner:     inner,
+		store:     options.Store,
+		threshold: threshold,
+		keyPrefix: options.KeyPrefix,
+	}
+}
+
+// NewFileBlobStore returns a BlobStore which keeps every payload in a file of dir. It is meant for tests and local
+// development.
+func NewFileBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileBlobStore{dir: dir}, nil
+}
+
+// ResolveOffloadedPayloads replaces the references to offloaded payloads in the given history events with the
+// payloads from the store, so tools can decode the history without the offloading DataConverter.
+func ResolveOffloadedPayloads(store BlobStore, events ...*shared.HistoryEvent) error {
+	for _, event := range events {
+		err := walkPayloads(reflect.ValueOf(event), func(payload reflect.Value) error {
+			ref, ok, err := decodeOffloadedPayloadReference(payload.Bytes())
+			if err != nil || !ok {
+				return err
+			}
+			data, err := getOffloadedPayload(store, ref)
+			if err != nil {
+				return err
+			}
+			payload.SetBytes(data)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteOffloadedPayloads waits until the payloads offloaded for a workflow can no longer be decoded and deletes them
+// from the store. This is the case once the run, all the runs it continued as new from and into, its parent run, its
+// children and the workflows it signaled are closed. Payloads encoded outside of any workflow, like the results of
+// activities completed by task token, are not deleted. The parent, children and signaled workflows must be in the
+// domain of the client. Don't start a new workflow with the same workflow ID before it returns.
+// The deleted payloads are marked in the store so that they are not stored again: queries and replays of the runs fail
+// afterwards, as does a new workflow with the same workflow ID which offloads one of the deleted payloads.
+func DeleteOffloadedPayloads(ctx context.Context, client historyGetter, store BlobStore, workflowID, runID string) error {
+	if workflowID == "" || runID == "" {
+		return errors.New("workflowID and runID are required")
+	}
+	runs := []string{runID}
+	visited := map[string]bool{runID: true}
+	addRun := func(runID string) {
+		if runID != "" && !visited[runID] {
+			visited[runID] = true
+			runs = append(runs, runID)
+		}
+	}
+	var keys []string
+	var others []shared.WorkflowExecution
+	for len(runs) > 0 {
+		runID := runs[0]
+		runs = runs[1:]
+		if err := waitForWorkflowClose(ctx, client, workflowID, runID); err != nil {
+			return err
+		}
+		iter := client.GetWorkflowHistory(ctx, workflowID, runID, false, shared.HistoryEventFilterTypeAllEvent)
+		for iter.HasNext() {
+			event, err := iter.Next()
+			if err != nil {
+				return err
+			}
+			switch event.GetEventType() {
+			case shared.EventTypeWorkflowExecutionStarted:
+				attributes := event.WorkflowExecutionStartedEventAttributes
+				addRun(attributes.GetContinuedExecutionRunId())
+				if attributes.ParentWorkflowExecution != nil {
+					others = append(others, *attributes.ParentWorkflowExecution)
+				}
+			case shared.EventTypeWorkflowExecutionContinuedAsNew:
+				addRun(event.WorkflowExecutionContinuedAsNewEventAttributes.GetNewExecutionRunId())
+			case shared.EventTypeChildWorkflowExecutionStarted:
+				others = append(others, *event.ChildWorkflowExecutionStartedEventAttributes.WorkflowExecution)
+			case shared.EventTypeSignalExternalWorkflowExecutionInitiated:
+				others = append(others, *event.SignalExternalWorkflowExecutionInitiatedEventAttributes.WorkflowExecution)
+			}
+			err = walkPayloads(reflect.ValueOf(event), func(payload reflect.Value) error {
+				ref, ok, err := decodeOffloadedPayloadReference(payload.Bytes())
+				if ok && ref.WorkflowID == workflowID {
+					keys = append(keys, ref.Key)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, execution := range others {
+		err := waitForWorkflowClose(ctx, client, execution.GetWorkflowId(), execution.GetRunId())
+		if _, ok := err.(*shared.EntityNotExistsError); ok {
+			// the history was already deleted after the retention period
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		// the marker is stored first, so a replay running concurrently can't store the payload again
+		if err := store.Put(key+deletedPayloadSuffix, nil); err != nil {
+			return err
+		}
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitForWorkflowClose(ctx context.Context, client historyGetter, workflowID, runID string) error {
+	iter := client.GetWorkflowHistory(ctx, workflowID, runID, true, shared.HistoryEventFilterTypeCloseEvent)
+	for iter.HasNext() {
+		if _, err := iter.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataConverterForWorkflow returns the DataConverter to encode the payloads of the given workflow with.
+func dataConverterForWorkflow(dc DataConverter, workflowID string) DataConverter {
+	if scoped, ok := dc.(workflowScopedDataConverter); ok && workflowID != "" {
+		return scoped.forWorkflow(workflowID)
+	}
+	return dc
+}
+
+func (dc *offloadingDataConverter) forWorkflow(workflowID string) DataConverter {
+	scoped := *dc
+	scoped.inner = dataConverterForWorkflow(dc.inner, workflowID)
+	scoped.workflowID = workflowID
+	return &scoped
+}
+
+func (dc *offloadingDataConverter) ToData(values ...interface{}) ([]byte, error) {
+	data, err := dc.inner.ToData(values...)
+	if err != nil || len(data) <= dc.threshold {
+		return data, err
+	}
+
+	sum := sha256.Sum256(data)
+	ref := offloadedPayloadReference{
+		Key:        dc.keyPrefix + "sha256-" + hex.EncodeToString(sum[:]),
+		Size:       len(data),
+		SHA256:     hex.EncodeToString(sum[:]),
+		WorkflowID: dc.workflowID,
+	}
+	if dc.workflowID != "" {
+		ref.Key = dc.keyPrefix + "workflow/" + url.PathEscape(dc.workflowID) + "/sha256-" + ref.SHA256
+	}
+	if dc.workflowID != "" {
+		if _, err := dc.store.Get(ref.Key + deletedPayloadSuffix); err == nil {
+			return nil, fmt.Errorf("unable to offload payload: %v was deleted by DeleteOffloadedPayloads", ref.Key)
+		}
+	}
+	// replays of the workflow store the payload again under the same key
+	if err := dc.store.Put(ref.Key, data); err != nil {
+		return nil, fmt.Errorf("unable to offload payload: %v", err)
+	}
+	refData, err := json.Marshal(ref)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, offloadedPayloadMagic...), refData...), nil
+}
+
+func (dc *offloadingDataConverter) FromData(input []byte, valuePtr ...interface{}) error {
+	ref, ok, err := decodeOffloadedPayloadReference(input)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dc.inner.FromData(input, valuePtr...)
+	}
+	data, err := getOffloadedPayload(dc.store, ref)
+	if err != nil {
+		return err
+	}
+	return dc.inner.FromData(data, valuePtr...)
+}
+
+func decodeOffloadedPayloadReference(data []byte) (*offloadedPayloadReference, bool, error) {
+	if !bytes.HasPrefix(data, offloadedPayloadMagic) {
+		return nil, false, nil
+	}
+	var ref offloadedPayloadReference
+	if err := json.Unmarshal(data[len(offloadedPayloadMagic):], &ref); err != nil {
+		return nil, true, fmt.Errorf("unable to decode offloaded payload reference: %v", err)
+	}
+	return &ref, true, nil
+}
+
+func getOffloadedPayload(store BlobStore, ref *offloadedPayloadReference) ([]byte, error) {
+	data, err := store.Get(ref.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get offloaded payload %q: %v", ref.Key, err)
+	}
+	sum := sha256.Sum256(data)
+	if len(data) != ref.Size || hex.EncodeToString(sum[:]) != ref.SHA256 {
+		return nil, fmt.Errorf("offloaded payload %q is corrupted", ref.Key)
+	}
+	return data, nil
+}
+
+// walkPayloads calls fn for every non empty []byte field, slice element and map value reachable from v.
+func walkPayloads(v reflect.Value, fn func(payload reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walkPayloads(v.Elem(), fn)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue // unexported
+			}
+			if err := walkPayloads(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > 0 && v.CanSet() {
+				return fn(v)
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := walkPayloads(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Slice || v.Type().Elem().Elem().Kind() != reflect.Uint8 {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			// map values are not addressable, resolve a copy and store it back
+			payload := reflect.New(v.Type().Elem()).Elem()
+			payload.Set(v.MapIndex(key))
+			if err := walkPayloads(payload, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, payload)
+		}
+	}
+	return nil
+}
+
+func (s *fileBlobStore) Put(key string, data []byte) error {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *fileBlobStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(key))
+}
+
+func (s *fileBlobStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileBlobStore) path(key string) string {
+	// keys may contain a prefix with path separators, keep all blobs in the same directory
+	return filepath.Join(s.dir, url.PathEscape(key))
+}
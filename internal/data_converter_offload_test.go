@@ -0,0 +1,353 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal/common"
+)
+
+func newTestFileBlobStore(t *testing.T) (BlobStore, string) {
+	dir, err := ioutil.TempDir("", "cadence-blobs")
+	require.NoError(t, err)
+	store, err := NewFileBlobStore(dir)
+	require.NoError(t, err)
+	return store, dir
+}
+
+func TestOffloadingDataConverter(t *testing.T) {
+	t.Parallel()
+	store, dir := newTestFileBlobStore(t)
+	defer os.RemoveAll(dir)
+	dc := NewOffloadingDataConverter(nil, OffloadOptions{Store: store, Threshold: 100, KeyPrefix: "app/"})
+
+	// small payloads stay in the history
+	data, err := dc.ToData("small")
+	require.NoError(t, err)
+	require.Equal(t, "\"small\"\n", string(data))
+
+	large := strings.Repeat("x", 1000)
+	data, err = dc.ToData(large)
+	require.NoError(t, err)
+	require.True(t, len(data) < 200)
+	ref, ok, err := decodeOffloadedPayloadReference(data)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(ref.Key, "app/sha256-"))
+
+	// the same payload gets the same reference, so replay writes the same history
+	again, err := dc.ToData(large)
+	require.NoError(t, err)
+	require.Equal(t, data, again)
+
+	var str string
+	require.NoError(t, dc.FromData(data, &str))
+	require.Equal(t, large, str)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, files[0].Name()), []byte("\"tampered\"\n"), 0644))
+	require.EqualError(t, dc.FromData(data, &str), `offloaded payload "`+ref.Key+`" is corrupted`)
+
+	require.NoError(t, store.Delete(ref.Key))
+	require.NoError(t, store.Delete(ref.Key))
+	err = dc.FromData(data, &str)
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), `unable to get offloaded payload "`+ref.Key+`"`))
+}
+
+func TestOffloadingDataConverter_WorkflowScope(t *testing.T) {
+	t.Parallel()
+	store, dir := newTestFileBlobStore(t)
+	defer os.RemoveAll(dir)
+	dc := NewCompressingDataConverter(
+		NewOffloadingDataConverter(nil, OffloadOptions{Store: store, Threshold: 10, KeyPrefix: "app/"}),
+		CompressionOptions{Threshold: 1 << 20},
+	)
+
+	large := strings.Repeat("x", 100)
+	unscoped, err := dc.ToData(large)
+	require.NoError(t, err)
+	scoped, err := dataConverterForWorkflow(dc, "wf/1").ToData(large)
+	require.NoError(t, err)
+	ref, _, err := decodeOffloadedPayloadReference(scoped)
+	require.NoError(t, err)
+	require.Equal(t, "wf/1", ref.WorkflowID)
+	require.True(t, strings.HasPrefix(ref.Key, "app/workflow/wf%2F1/sha256-"))
+	require.NotEqual(t, unscoped, scoped)
+
+	var str string
+	require.NoError(t, dc.FromData(scoped, &str))
+	require.Equal(t, large, str)
+}
+
+type testHistoryEventIterator struct {
+	events []*shared.HistoryEvent
+	err    error
+}
+
+func (it *testHistoryEventIterator) HasNext() bool {
+	return len(it.events) > 0 || it.err != nil
+}
+
+func (it *testHistoryEventIterator) Next() (*shared.HistoryEvent, error) {
+	if it.err != nil {
+		err := it.err
+		it.err = nil
+		return nil, err
+	}
+	event := it.events[0]
+	it.events = it.events[1:]
+	return event, nil
+}
+
+// testHistoryGetter returns the histories of closed workflows, keyed by workflow ID and run ID.
+type testHistoryGetter struct {
+	histories map[string][]*shared.HistoryEvent
+	waited    []string
+}
+
+func (g *testHistoryGetter) GetWorkflowHistory(ctx context.Context, workflowID string, runID string, isLongPoll bool,
+	filterType shared.HistoryEventFilterType) HistoryEventIterator {
+	events, ok := g.histories[workflowID+"/"+runID]
+	if !ok {
+		return &testHistoryEventIterator{err: &shared.EntityNotExistsError{}}
+	}
+	if filterType == shared.HistoryEventFilterTypeCloseEvent {
+		g.waited = append(g.waited, workflowID+"/"+runID)
+		return &testHistoryEventIterator{events: events[len(events)-1:]}
+	}
+	return &testHistoryEventIterator{events: events}
+}
+
+func TestResolveAndDeleteOffloadedPayloads(t *testing.T) {
+	t.Parallel()
+	store, dir := newTestFileBlobStore(t)
+	defer os.RemoveAll(dir)
+	dc := NewOffloadingDataConverter(nil, OffloadOptions{Store: store, Threshold: 10})
+	workflowDC := dataConverterForWorkflow(dc, "wf")
+	childDC := dataConverterForWorkflow(dc, "child")
+
+	input, err := workflowDC.ToData(strings.Repeat("i", 100))
+	require.NoError(t, err)
+	memo, err := workflowDC.ToData(strings.Repeat("m", 100))
+	require.NoError(t, err)
+	result, err := workflowDC.ToData(strings.Repeat("r", 100))
+	require.NoError(t, err)
+	childResult, err := childDC.ToData(strings.Repeat("c", 100))
+	require.NoError(t, err)
+	// payloads encoded outside of a workflow are kept
+	unscoped, err := dc.ToData(strings.Repeat("u", 100))
+	require.NoError(t, err)
+
+	child := &shared.WorkflowExecution{WorkflowId: common.StringPtr("child"), RunId: common.StringPtr("c1")}
+	getter := &testHistoryGetter{histories: map[string][]*shared.HistoryEvent{
+		"wf/r1": {
+			createTestEventWorkflowExecutionStarted(1, &shared.WorkflowExecutionStartedEventAttributes{
+				Input:                   input,
+				ParentWorkflowExecution: &shared.WorkflowExecution{WorkflowId: common.StringPtr("parent"), RunId: common.StringPtr("p1")},
+			}),
+			{
+				EventId:   common.Int64Ptr(2),
+				EventType: common.EventTypePtr(shared.EventTypeWorkflowExecutionContinuedAsNew),
+				WorkflowExecutionContinuedAsNewEventAttributes: &shared.WorkflowExecutionContinuedAsNewEventAttributes{
+					NewExecutionRunId: common.StringPtr("r2"),
+					Input:             input,
+				},
+			},
+		},
+		"wf/r2": {
+			createTestEventWorkflowExecutionStarted(1, &shared.WorkflowExecutionStartedEventAttributes{
+				Input:                   input,
+				Memo:                    &shared.Memo{Fields: map[string][]byte{"memo": memo}},
+				ContinuedExecutionRunId: common.StringPtr("r1"),
+			}),
+			{
+				EventId:   common.Int64Ptr(2),
+				EventType: common.EventTypePtr(shared.EventTypeChildWorkflowExecutionStarted),
+				ChildWorkflowExecutionStartedEventAttributes: &shared.ChildWorkflowExecutionStartedEventAttributes{
+					WorkflowExecution: child,
+				},
+			},
+			{
+				EventId:   common.Int64Ptr(3),
+				EventType: common.EventTypePtr(shared.EventTypeChildWorkflowExecutionCompleted),
+				ChildWorkflowExecutionCompletedEventAttributes: &shared.ChildWorkflowExecutionCompletedEventAttributes{
+					WorkflowExecution: child,
+					Result:            childResult,
+				},
+			},
+			{
+				EventId:   common.Int64Ptr(4),
+				EventType: common.EventTypePtr(shared.EventTypeSignalExternalWorkflowExecutionInitiated),
+				SignalExternalWorkflowExecutionInitiatedEventAttributes: &shared.SignalExternalWorkflowExecutionInitiatedEventAttributes{
+					WorkflowExecution: &shared.WorkflowExecution{WorkflowId: common.StringPtr("gone")},
+					Input:             unscoped,
+				},
+			},
+			createTestEventWorkflowExecutionCompleted(5, &shared.WorkflowExecutionCompletedEventAttributes{Result: result}),
+		},
+		"parent/p1": {createTestEventWorkflowExecutionCompleted(1, &shared.WorkflowExecutionCompletedEventAttributes{})},
+		"child/c1":  {createTestEventWorkflowExecutionCompleted(1, &shared.WorkflowExecutionCompletedEventAttributes{})},
+	}}
+
+	resolved := []*shared.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &shared.WorkflowExecutionStartedEventAttributes{
+			Input: input,
+			Memo:  &shared.Memo{Fields: map[string][]byte{"memo": memo}},
+		}),
+		createTestEventWorkflowExecutionCompleted(2, &shared.WorkflowExecutionCompletedEventAttributes{Result: result}),
+	}
+	require.NoError(t, ResolveOffloadedPayloads(store, resolved...))
+	var str string
+	require.NoError(t, getDefaultDataConverter().FromData(resolved[0].WorkflowExecutionStartedEventAttributes.Input, &str))
+	require.Equal(t, strings.Repeat("i", 100), str)
+	require.NoError(t, getDefaultDataConverter().FromData(resolved[0].WorkflowExecutionStartedEventAttributes.Memo.Fields["memo"], &str))
+	require.Equal(t, strings.Repeat("m", 100), str)
+	require.NoError(t, getDefaultDataConverter().FromData(resolved[1].WorkflowExecutionCompletedEventAttributes.Result, &str))
+	require.Equal(t, strings.Repeat("r", 100), str)
+
+	require.EqualError(t, DeleteOffloadedPayloads(context.Background(), getter, store, "wf", ""), "workflowID and runID are required")
+	// starting from any run of the chain deletes the payloads of all of them
+	require.NoError(t, DeleteOffloadedPayloads(context.Background(), getter, store, "wf", "r2"))
+	// the signaled workflow is gone, so it is not waited for
+	require.Equal(t, []string{"wf/r2", "wf/r1", "child/c1", "parent/p1"}, getter.waited)
+	require.NoError(t, dc.FromData(childResult, &str))
+	require.NoError(t, dc.FromData(unscoped, &str))
+	for _, data := range [][]byte{input, memo, result} {
+		require.Error(t, dc.FromData(data, &str))
+	}
+	// a replay or a query of the workflow does not store the deleted payloads again
+	_, err = workflowDC.ToData(strings.Repeat("r", 100))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "was deleted by DeleteOffloadedPayloads")
+	_, err = childDC.ToData(strings.Repeat("c", 100))
+	require.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	// the payloads which are kept and the markers of the deleted ones
+	require.Equal(t, 5, len(files))
+}
+
+func testReplayWorkflowOffloaded(ctx Context, input string) (string, error) {
+	return strings.ToUpper(input), nil
+}
+
+func TestReplayWorkflowHistoryWithOffloadedPayloads(t *testing.T) {
+	RegisterWorkflow(testReplayWorkflowOffloaded)
+	store, dir := newTestFileBlobStore(t)
+	defer os.RemoveAll(dir)
+	dc := NewOffloadingDataConverter(nil, OffloadOptions{Store: store, Threshold: 10})
+	workflowDC := dataConverterForWorkflow(dc, "wf")
+
+	input, err := workflowDC.ToData(strings.Repeat("a", 100))
+	require.NoError(t, err)
+	result, err := workflowDC.ToData(strings.Repeat("A", 100))
+	require.NoError(t, err)
+	history := &shared.History{Events: []*shared.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &shared.WorkflowExecutionStartedEventAttributes{
+			WorkflowType: &shared.WorkflowType{Name: common.StringPtr(getFunctionName(testReplayWorkflowOffloaded))},
+			TaskList:     &shared.TaskList{Name: common.StringPtr("taskList1")},
+			Input:        input,
+		}),
+		createTestEventDecisionTaskScheduled(2, &shared.DecisionTaskScheduledEventAttributes{}),
+		createTestEventDecisionTaskStarted(3),
+		createTestEventDecisionTaskCompleted(4, &shared.DecisionTaskCompletedEventAttributes{}),
+		createTestEventWorkflowExecutionCompleted(5, &shared.WorkflowExecutionCompletedEventAttributes{
+			Result:                       result,
+			DecisionTaskCompletedEventId: common.Int64Ptr(4),
+		}),
+	}}
+
+	options := ReplayOptions{DataConverter: dc, WorkflowID: "wf"}
+	require.NoError(t, ReplayWorkflowHistoryWithOptions(nil, history, options))
+	require.Error(t, ReplayWorkflowHistory(nil, history))
+	// the offloaded result is stored under the keys of the workflow
+	require.Error(t, ReplayWorkflowHistoryWithOptions(nil, history, ReplayOptions{DataConverter: dc}))
+
+	historyJSON, err := json.Marshal(history.Events)
+	require.NoError(t, err)
+	historyFile := filepath.Join(dir, "history.json")
+	require.NoError(t, ioutil.WriteFile(historyFile, historyJSON, 0644))
+	require.NoError(t, ReplayWorkflowHistoryFromJSONFileWithOptions(nil, historyFile, options))
+	require.Error(t, ReplayWorkflowHistoryFromJSONFile(nil, historyFile))
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_OffloadingDataConverter() {
+	store, dir := newTestFileBlobStore(s.T())
+	defer os.RemoveAll(dir)
+	dc := NewOffloadingDataConverter(nil, OffloadOptions{Store: store, Threshold: 10})
+	var workflowID string
+	workflowFn := func(ctx Context, name string) (string, error) {
+		workflowID = GetWorkflowInfo(ctx).WorkflowExecution.ID
+		ctx = WithActivityOptions(ctx, s.activityOptions)
+		var result string
+		err := ExecuteActivity(WithDataConverter(ctx, dc), testActivityHello, name).Get(ctx, &result)
+		return result, err
+	}
+
+	RegisterWorkflow(workflowFn)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(WorkerOptions{DataConverter: dc})
+	env.ExecuteWorkflow(workflowFn, strings.Repeat("world", 10))
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("hello_"+strings.Repeat("world", 10), result)
+
+	// the payloads encoded by the workflow and its activity are stored under keys of the workflow, the input of the
+	// workflow is encoded by the test environment outside of any workflow
+	sha256Key := func(value string) string {
+		data, err := getDefaultDataConverter().ToData(value)
+		s.NoError(err)
+		sum := sha256.Sum256(data)
+		return "sha256-" + hex.EncodeToString(sum[:])
+	}
+	workflowKey := func(value string) string {
+		return url.PathEscape("workflow/" + url.PathEscape(workflowID) + "/" + sha256Key(value))
+	}
+	files, err := ioutil.ReadDir(dir)
+	s.NoError(err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	s.ElementsMatch([]string{
+		sha256Key(strings.Repeat("world", 10)),
+		workflowKey(strings.Repeat("world", 10)),
+		workflowKey("hello_" + strings.Repeat("world", 10)),
+	}, names)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Encodings recorded in the payload envelope by the protobuf payload converters.
+const (
+	// PayloadEncodingProto is the encoding of proto.Message values encoded with protobuf binary encoding.
+	PayloadEncodingProto = "proto/binary"
+	// PayloadEncodingProtoJSON is the encoding of proto.Message values encoded with protobuf JSON mapping.
+	PayloadEncodingProtoJSON = "json/protobuf"
+)
+
+var typeOfProtoMessage = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+type (
+	// ProtoOptions configures the DataConverter returned by NewProtoDataConverter.
+	ProtoOptions struct {
+		// UseJSON encodes proto.Message values with the protobuf JSON mapping instead of the binary encoding, which
+		// makes them readable in the history. Values encoded either way are decoded.
+		// Optional: default false
+		UseJSON bool
+	}
+
+	protoPayloadConverter struct {
+		useJSON bool
+	}
+
+	protoDataConverter struct {
+		envelope DataConverter
+	}
+)
+
+// NewProtoDataConverter returns a DataConverter which encodes proto.Message values with protobuf. Values are encoded
+// exactly like with the default DataConverter unless one of them is a proto message, in which case all of them are
+// stored in a payload envelope: the proto messages with protobuf and the other values like the composite DataConverter
+// with the default payload converters. Workflow and activity functions can take pointers to proto messages as
+// arguments and return them as results.
+func NewProtoDataConverter(options ProtoOptions) DataConverter {
+	converters := []PayloadConverter{
+		NewProtoPayloadConverter(options.UseJSON),
+		NewProtoPayloadConverter(!options.UseJSON),
+	}
+	return &protoDataConverter{
+		envelope: NewCompositeDataConverter(append(converters, GetDefaultPayloadConverters()...)...),
+	}
+}
+
+func (dc *protoDataConverter) ToData(values ...interface{}) ([]byte, error) {
+	for _, value := range values {
+		if isProtoMessage(value) {
+			return dc.envelope.ToData(values...)
+		}
+	}
+	return getDefaultDataConverter().ToData(values...)
+}
+
+func (dc *protoDataConverter) FromData(input []byte, valuePtr ...interface{}) error {
+	// the composite DataConverter decodes data without an envelope with the default DataConverter
+	return dc.envelope.FromData(input, valuePtr...)
+}
+
+func isProtoMessage(value interface{}) bool {
+	_, ok := value.(proto.Message)
+	return ok && !reflect.ValueOf(value).IsNil()
+}
+
+// NewProtoPayloadConverter returns a PayloadConverter for proto.Message values to use with the composite
+// DataConverter. It encodes them with the protobuf JSON mapping if useJSON is set and with protobuf binary otherwise.
+func NewProtoPayloadConverter(useJSON bool) PayloadConverter {
+	return &protoPayloadConverter{useJSON: useJSON}
+}
+
+func (c *protoPayloadConverter) Encoding() string {
+	if c.useJSON {
+		return PayloadEncodingProtoJSON
+	}
+	return PayloadEncodingProto
+}
+
+func (c *protoPayloadConverter) ToPayload(value interface{}) ([]byte, bool, error) {
+	if !isProtoMessage(value) {
+		// nil messages are left to the other converters, so they decode as nil pointers
+		return nil, false, nil
+	}
+	message := value.(proto.Message)
+	if c.useJSON {
+		var buf bytes.Buffer
+		err := (&jsonpb.Marshaler{}).Marshal(&buf, message)
+		return buf.Bytes(), true, err
+	}
+	data, err := proto.Marshal(message)
+	return data, true, err
+}
+
+func (c *protoPayloadConverter) FromPayload(data []byte, valuePtr interface{}) error {
+	message, err := newProtoMessageFor(valuePtr)
+	if err != nil {
+		return err
+	}
+	if c.useJSON {
+		return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(data), message)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// newProtoMessageFor returns the message to decode into for valuePtr, which is either a pointer to a proto message
+// or a pointer to a pointer to a proto message as created by decodeArgs for arguments of a proto message type. In the
+// latter case a new message is allocated and assigned.
+func newProtoMessageFor(valuePtr interface{}) (proto.Message, error) {
+	rv := reflect.ValueOf(valuePtr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, fmt.Errorf("pointer to proto.Message is required, got %T", valuePtr)
+	}
+	if message, ok := valuePtr.(proto.Message); ok {
+		return message, nil
+	}
+	elemType := rv.Elem().Type()
+	if elemType.Kind() != reflect.Ptr || !elemType.Implements(typeOfProtoMessage) {
+		return nil, fmt.Errorf("pointer to proto.Message is required, got %T", valuePtr)
+	}
+	message := reflect.New(elemType.Elem())
+	rv.Elem().Set(message)
+	return message.Interface().(proto.Message), nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoDataConverter(t *testing.T) {
+	t.Parallel()
+	for _, useJSON := range []bool{false, true} {
+		dc := NewProtoDataConverter(ProtoOptions{UseJSON: useJSON})
+		ts := &timestamp.Timestamp{Seconds: 1500000000, Nanos: 7}
+		data, err := dc.ToData(ts, "text", (*wrappers.StringValue)(nil))
+		require.NoError(t, err)
+
+		payloads, _, err := DecodePayloadEnvelope(data)
+		require.NoError(t, err)
+		if useJSON {
+			require.Equal(t, PayloadEncodingProtoJSON, payloads[0].Encoding)
+			require.Equal(t, `"2017-07-14T02:40:00.000000007Z"`, string(payloads[0].Data))
+		} else {
+			require.Equal(t, PayloadEncodingProto, payloads[0].Encoding)
+		}
+		require.Equal(t, PayloadEncodingJSON, payloads[1].Encoding)
+
+		// messages decode into a pointer to a message and into a pointer to a pointer to a message
+		var decoded timestamp.Timestamp
+		var decodedPtr *timestamp.Timestamp
+		var str string
+		nilValue := &wrappers.StringValue{Value: "not nil"}
+		require.NoError(t, dc.FromData(data, &decoded, &str, &nilValue))
+		require.True(t, proto.Equal(ts, &decoded))
+		require.Equal(t, "text", str)
+		require.Nil(t, nilValue)
+		require.NoError(t, dc.FromData(data, &decodedPtr))
+		require.True(t, proto.Equal(ts, decodedPtr))
+
+		// payloads encoded the other way are decoded as well
+		other := NewProtoDataConverter(ProtoOptions{UseJSON: !useJSON})
+		decodedPtr = nil
+		require.NoError(t, other.FromData(data, &decodedPtr))
+		require.True(t, proto.Equal(ts, decodedPtr))
+	}
+}
+
+func TestProtoDataConverter_WithoutMessages(t *testing.T) {
+	t.Parallel()
+	values := []interface{}{"text", 1, []byte("raw"), (*wrappers.StringValue)(nil)}
+	expected, err := getDefaultDataConverter().ToData(values...)
+	require.NoError(t, err)
+	data, err := NewProtoDataConverter(ProtoOptions{}).ToData(values...)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+
+	var str string
+	var num int
+	require.NoError(t, NewProtoDataConverter(ProtoOptions{}).FromData(data, &str, &num))
+	require.Equal(t, "text", str)
+	require.Equal(t, 1, num)
+}
+
+func TestProtoDataConverter_NotMessage(t *testing.T) {
+	t.Parallel()
+	dc := NewProtoDataConverter(ProtoOptions{})
+	data, err := dc.ToData(&wrappers.StringValue{Value: "v"})
+	require.NoError(t, err)
+	var str string
+	err = dc.FromData(data, &str)
+	require.EqualError(t, err, "unable to decode argument: 0, *string, with error: pointer to proto.Message is required, got *string")
+}
+
+func testProtoGreetActivity(ctx context.Context, name *wrappers.StringValue) (*wrappers.StringValue, error) {
+	return &wrappers.StringValue{Value: "hello_" + name.GetValue()}, nil
+}
+
+func testProtoUpperActivity(ctx context.Context, greeting string) (string, error) {
+	return strings.ToUpper(greeting), nil
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_ProtoDataConverter() {
+	workflowFn := func(ctx Context, name *wrappers.StringValue) (string, error) {
+		ctx = WithActivityOptions(ctx, s.activityOptions)
+		var greeting *wrappers.StringValue
+		if err := ExecuteActivity(ctx, testProtoGreetActivity, name).Get(ctx, &greeting); err != nil {
+			return "", err
+		}
+		var result string
+		err := ExecuteActivity(ctx, testProtoUpperActivity, greeting.GetValue()).Get(ctx, &result)
+		return result, err
+	}
+
+	RegisterWorkflow(workflowFn)
+	RegisterActivity(testProtoGreetActivity)
+	RegisterActivity(testProtoUpperActivity)
+	env := s.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(WorkerOptions{DataConverter: NewProtoDataConverter(ProtoOptions{UseJSON: true})})
+	results := map[string][]byte{}
+	env.SetOnActivityCompletedListener(func(activityInfo *ActivityInfo, result Value, err error) {
+		results[activityInfo.ActivityType.Name] = result.(*EncodedValue).value
+	})
+	env.ExecuteWorkflow(workflowFn, &wrappers.StringValue{Value: "proto"})
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("HELLO_PROTO", result)
+
+	// the proto message is recorded with the protobuf JSON mapping, the string like with the default DataConverter
+	payloads, ok, err := DecodePayloadEnvelope(results[getFunctionName(testProtoGreetActivity)])
+	s.True(ok)
+	s.NoError(err)
+	s.Equal([]Payload{{Encoding: PayloadEncodingProtoJSON, Data: []byte(`"hello_proto"`)}}, payloads)
+	plain, err := getDefaultDataConverter().ToData("HELLO_PROTO")
+	s.NoError(err)
+	s.Equal(plain, results[getFunctionName(testProtoUpperActivity)])
+}
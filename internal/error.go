@@ -132,6 +132,20 @@ type (
 
 	// UnknownExternalWorkflowExecutionError can be returned when external workflow doesn't exist
 	UnknownExternalWorkflowExecutionError struct{}
+
+	// UpdateRejectedError is returned by Client.UpdateWorkflow when the workflow rejected the update, either because
+	// there is no handler for it or because the update validator returned an error.
+	UpdateRejectedError struct {
+		message string
+	}
+
+	// AggregateError is returned when some operations of a group failed, for example by the futures created
+	// through AllOf and AnyOf or by Saga.Compensate.
+	AggregateError struct {
+		errors []error
+		// operation names what failed in the error message, "future" unless set
+		operation string
+	}
 )
 
 const (
@@ -373,3 +387,29 @@ func newUnknownExternalWorkflowExecutionError() *UnknownExternalWorkflowExecutio
 func (e *UnknownExternalWorkflowExecutionError) Error() string {
 	return "UnknownExternalWorkflowExecution"
 }
+
+// Error from error interface
+func (e *AggregateError) Error() string {
+	operation := e.operation
+	if operation == "" {
+		operation = "future"
+	}
+	var msgs []string
+	for i, err := range e.errors {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s %d: %v", operation, i, err))
+		}
+	}
+	return fmt.Sprintf("%d of %d %ss failed: %s", len(msgs), len(e.errors), operation, strings.Join(msgs, "; "))
+}
+
+// Errors returns the errors of the combined futures in the order the futures were passed in, or of the executed
+// compensations of a Saga in execution order. The error is nil for the ones that succeeded.
+func (e *AggregateError) Errors() []error {
+	return e.errors
+}
+
+// Error from error interface
+func (e *UpdateRejectedError) Error() string {
+	return "update rejected: " + e.message
+}
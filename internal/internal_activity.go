@@ -63,11 +63,13 @@ type (
 		WaitForCancellation           bool
 		OriginalTaskListName          string
 		RetryPolicy                   *shared.RetryPolicy
+		EnableAutoHeartbeat           bool
 	}
 
 	localActivityOptions struct {
 		ScheduleToCloseTimeoutSeconds int32
 		RetryPolicy                   *RetryPolicy
+		ShouldRetry                   RetryPredicate
 	}
 
 	executeActivityParams struct {
@@ -87,6 +89,8 @@ type (
 		DataConverter DataConverter
 		Attempt       int32
 		ScheduledTime time.Time
+		// HeartbeatDetails recorded by the previous attempt, only set when the local activity is retried
+		HeartbeatDetails []byte
 	}
 
 	// asyncActivityClient for requesting activity execution
@@ -131,6 +135,7 @@ type (
 		workerStopChannel  <-chan struct{}
 		contextPropagators []ContextPropagator
 		tracer             opentracing.Tracer
+		localActivityTask  *localActivityTask // set only for local activities
 	}
 
 	// context.WithValue need this type instead of basic type string to avoid lint error
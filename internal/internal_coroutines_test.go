@@ -270,6 +270,159 @@ func TestNotBlockingSelect(t *testing.T) {
 	require.EqualValues(t, expected, history)
 }
 
+func TestAwait(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		flag := false
+		c := NewChannel(ctx)
+		Go(ctx, func(ctx Context) {
+			c.Receive(ctx, nil)
+			history = append(history, "child-set-flag")
+			flag = true
+		})
+		Go(ctx, func(ctx Context) {
+			history = append(history, "child-send")
+			c.Send(ctx, nil)
+		})
+		history = append(history, "root-await")
+		err := Await(ctx, func() bool { return flag })
+		assert.NoError(t, err)
+		history = append(history, "root-done")
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+
+	expected := []string{
+		"root-await",
+		"child-send",
+		"child-set-flag",
+		"root-done",
+	}
+	require.EqualValues(t, expected, history)
+}
+
+func TestAwaitCancellation(t *testing.T) {
+	var awaitErr error
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		ctx, cancel := WithCancel(ctx)
+		Go(ctx, func(ctx Context) {
+			cancel()
+		})
+		awaitErr = Await(ctx, func() bool { return false })
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.Equal(t, ErrCanceled, awaitErr)
+}
+
+func TestMutex(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		m := NewMutex(ctx)
+		assert.True(t, m.TryLock())
+		assert.False(t, m.TryLock())
+		for i := 1; i <= 2; i++ {
+			ii := i
+			Go(ctx, func(ctx Context) {
+				assert.NoError(t, m.Lock(ctx))
+				history = append(history, fmt.Sprintf("child-%v-locked", ii))
+				m.Unlock()
+			})
+		}
+		history = append(history, "root-unlock")
+		m.Unlock()
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+
+	expected := []string{
+		"root-unlock",
+		"child-1-locked",
+		"child-2-locked",
+	}
+	require.EqualValues(t, expected, history)
+}
+
+func TestMutexStackTraceAndCancellation(t *testing.T) {
+	var lockErr error
+	var cancel CancelFunc
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		m := NewNamedMutex(ctx, "sharedState")
+		require.NoError(t, m.Lock(ctx))
+		ctx, cancel = WithCancel(ctx)
+		lockErr = m.Lock(ctx)
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.False(t, d.IsDone())
+	require.Contains(t, d.StackTrace(), "[blocked on sharedState.Lock]:")
+
+	cancel()
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.Equal(t, ErrCanceled, lockErr)
+}
+
+func TestSemaphore(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		s := NewSemaphore(ctx, 3)
+		c := NewChannel(ctx)
+		running := 0
+		for i := 1; i <= 4; i++ {
+			ii := i
+			Go(ctx, func(ctx Context) {
+				assert.NoError(t, s.Acquire(ctx, 2))
+				running += 2
+				history = append(history, fmt.Sprintf("child-%v-acquired-%v", ii, running))
+				c.Receive(ctx, nil)
+				running -= 2
+				s.Release(2)
+			})
+		}
+		for i := 0; i < 4; i++ {
+			c.Send(ctx, nil)
+		}
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+
+	expected := []string{
+		"child-1-acquired-2",
+		"child-2-acquired-2",
+		"child-3-acquired-2",
+		"child-4-acquired-2",
+	}
+	require.EqualValues(t, expected, history)
+}
+
+func TestSemaphoreFairness(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		s := NewSemaphore(ctx, 3)
+		assert.Panics(t, func() { s.TryAcquire(4) })
+		assert.Panics(t, func() { s.Release(1) })
+		assert.True(t, s.TryAcquire(1))
+
+		c := NewChannel(ctx)
+		Go(ctx, func(ctx Context) {
+			assert.NoError(t, s.Acquire(ctx, 3))
+			history = append(history, "child-acquired")
+			s.Release(3)
+		})
+		Go(ctx, func(ctx Context) {
+			c.Send(ctx, nil)
+		})
+		c.Receive(ctx, nil)
+		// the permits are available but the child waits for them first
+		assert.False(t, s.TryAcquire(1))
+		history = append(history, "root-release")
+		s.Release(1)
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"root-release", "child-acquired"}, history)
+}
+
 func TestBlockingSelect(t *testing.T) {
 	var history []string
 	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
@@ -687,6 +840,29 @@ func TestPanic(t *testing.T) {
 	require.Contains(t, err.(*workflowPanicError).StackTrace(), "cadence/internal.TestPanic")
 }
 
+func TestDeadlockDetection(t *testing.T) {
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		GoNamed(ctx, "busy", func(ctx Context) {
+			<-make(chan struct{}) // never yields back to the dispatcher
+		})
+		NewChannel(ctx).Receive(ctx, nil) // blocked forever
+	})
+	d.deadlockDetectionTimeout = 50 * time.Millisecond
+
+	err := d.ExecuteUntilAllBlocked()
+	require.NotNil(t, err)
+	require.IsType(t, (*workflowPanicError)(nil), err)
+	require.IsType(t, (*potentialDeadlockError)(nil), err.(*workflowPanicError).value)
+	require.Contains(t, err.Error(), "coroutine busy didn't yield for over 50ms")
+	stackTrace := err.(*workflowPanicError).StackTrace()
+	require.True(t, strings.HasPrefix(stackTrace, "coroutine busy [potential deadlock]:"), stackTrace)
+	require.Contains(t, stackTrace, "cadence/internal.TestDeadlockDetection")
+
+	// neither stack trace nor close waits for the deadlocked coroutine
+	require.Contains(t, d.StackTrace(), "coroutine busy [potential deadlock]:")
+	d.Close()
+}
+
 func TestFutureSetValue(t *testing.T) {
 	var history []string
 	var f Future
@@ -1164,3 +1340,292 @@ func TestChainedFuture(t *testing.T) {
 	require.NoError(t, env.GetWorkflowResult(&out))
 	require.Equal(t, 5, out)
 }
+
+func TestFutureChainReady(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		f1, s1 := NewFuture(ctx)
+		s1.SetValue("value1")
+		f2, s2 := NewFuture(ctx)
+		s2.Chain(f1)
+		require.True(t, f2.IsReady())
+		var v string
+		require.NoError(t, f2.Get(ctx, &v))
+		history = append(history, v)
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"value1"}, history)
+}
+
+func TestAllOf(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		f1, s1 := NewFuture(ctx)
+		f2, s2 := NewFuture(ctx)
+		f3, s3 := NewFuture(ctx)
+		all := AllOf(ctx, f1, f2, f3)
+		Go(ctx, func(ctx Context) {
+			s3.SetValue("value3")
+			s1.SetValue("value1")
+			history = append(history, "set-1-3")
+			s2.SetError(errors.New("error2"))
+		})
+		var values []string
+		err := all.Get(ctx, &values)
+		history = append(history, "all-ready")
+		require.Error(t, err)
+		aggregateErr, ok := err.(*AggregateError)
+		require.True(t, ok)
+		require.Equal(t, 3, len(aggregateErr.Errors()))
+		require.NoError(t, aggregateErr.Errors()[0])
+		require.EqualError(t, aggregateErr.Errors()[1], "error2")
+		require.NoError(t, aggregateErr.Errors()[2])
+		require.Equal(t, "1 of 3 futures failed: future 1: error2", err.Error())
+		require.EqualValues(t, []string{"value1", "", "value3"}, values)
+
+		require.NoError(t, AllOf(ctx).Get(ctx, nil))
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"set-1-3", "all-ready"}, history)
+}
+
+func TestAnyOf(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		f1, s1 := NewFuture(ctx)
+		f2, s2 := NewFuture(ctx)
+		f3, s3 := NewFuture(ctx)
+		any := AnyOf(ctx, f1, f2, f3)
+		Go(ctx, func(ctx Context) {
+			s1.SetError(errors.New("error1"))
+			s3.SetValue("value3")
+		})
+		var value string
+		require.NoError(t, any.Get(ctx, &value))
+		history = append(history, value)
+		s2.SetValue("value2")
+
+		f4, s4 := NewFuture(ctx)
+		f5, s5 := NewFuture(ctx)
+		s4.SetError(errors.New("error4"))
+		s5.SetError(errors.New("error5"))
+		err := AnyOf(ctx, f4, f5).Get(ctx, &value)
+		require.Error(t, err)
+		aggregateErr, ok := err.(*AggregateError)
+		require.True(t, ok)
+		require.EqualError(t, aggregateErr.Errors()[0], "error4")
+		require.EqualError(t, aggregateErr.Errors()[1], "error5")
+		history = append(history, "all-failed")
+
+		err = AnyOf(ctx).Get(ctx, &value)
+		require.EqualError(t, err, "AnyOf requires at least one future")
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"value3", "all-failed"}, history)
+}
+
+// testPrefixFuture is a Future that wasn't created by the workflow package
+type testPrefixFuture struct {
+	Future
+	prefix string
+}
+
+func (f testPrefixFuture) Get(ctx Context, valuePtr interface{}) error {
+	var value string
+	if err := f.Future.Get(ctx, &value); err != nil {
+		return err
+	}
+	if valuePtr != nil {
+		*valuePtr.(*interface{}) = f.prefix + value
+	}
+	return nil
+}
+
+func TestAnyOfCustomFuture(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		f1, s1 := NewFuture(ctx)
+		f2, s2 := NewFuture(ctx)
+		any := AnyOf(ctx, f1, testPrefixFuture{Future: f2, prefix: "custom-"})
+		Go(ctx, func(ctx Context) {
+			s2.SetValue("value2")
+		})
+		var value string
+		require.NoError(t, any.Get(ctx, &value))
+		history = append(history, value)
+		s1.SetValue("value1")
+
+		f3, s3 := NewFuture(ctx)
+		s3.SetError(errors.New("error3"))
+		err := AnyOf(ctx, testPrefixFuture{Future: f3}).Get(ctx, &value)
+		require.EqualError(t, err, "1 of 1 futures failed: future 0: error3")
+		history = append(history, "failed")
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"custom-value2", "failed"}, history)
+}
+
+func TestForEachParallel(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		settables := map[int]Settable{}
+		inFlight, maxInFlight := 0, 0
+		done := NewChannel(ctx)
+		Go(ctx, func(ctx Context) {
+			for i := 0; i < 5; i++ {
+				// complete the items in reverse order of the ones currently in flight
+				for j := 4; j >= 0; j-- {
+					if s, ok := settables[j]; ok {
+						delete(settables, j)
+						inFlight--
+						s.SetValue(j)
+						break
+					}
+				}
+				done.Receive(ctx, nil)
+			}
+		})
+		err := ForEachParallel(ctx, []int{0, 1, 2, 3, 4}, 2, func(ctx Context, item interface{}) Future {
+			f, s := NewFuture(ctx)
+			settables[item.(int)] = s
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			history = append(history, fmt.Sprintf("start-%v", item))
+			Go(ctx, func(ctx Context) {
+				f.Get(ctx, nil)
+				done.Send(ctx, nil)
+			})
+			return f
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, maxInFlight)
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"start-0", "start-1", "start-2", "start-3", "start-4"}, history)
+}
+
+func TestForEachParallelCancelsOnError(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		err := ForEachParallel(ctx, []string{"a", "b", "c"}, 2, func(ctx Context, item interface{}) Future {
+			history = append(history, "start-"+item.(string))
+			f, s := NewFuture(ctx)
+			Go(ctx, func(ctx Context) {
+				if item == "a" {
+					s.SetError(errors.New("failed-a"))
+					return
+				}
+				// wait for the cancellation caused by the failure of "a"
+				ctx.Done().Receive(ctx, nil)
+				history = append(history, "canceled-"+item.(string))
+				s.SetError(ctx.Err())
+			})
+			return f
+		})
+		require.EqualError(t, err, "failed-a")
+		require.Panics(t, func() { ForEachParallel(ctx, "not a slice", 1, nil) })
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"start-a", "start-b", "canceled-b"}, history)
+}
+
+func TestSelectorHasPendingAndRemove(t *testing.T) {
+	var history []string
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		c1 := NewChannel(ctx)
+		c2 := NewBufferedChannel(ctx, 1)
+		f, settable := NewFuture(ctx)
+		s := NewSelector(ctx)
+		hasPending := func() bool {
+			pending, err := SelectorHasPending(s)
+			require.NoError(t, err)
+			return pending
+		}
+		s.AddReceive(c1, func(c Channel, more bool) {
+			var v string
+			c.Receive(ctx, &v)
+			history = append(history, "c1-"+v)
+		})
+		s.AddFuture(f, func(f Future) {
+			history = append(history, "future")
+		})
+		require.False(t, hasPending())
+
+		s.AddSend(c2, "value", func() {
+			history = append(history, "send")
+		})
+		require.True(t, hasPending())
+		s.Select(ctx)
+		// the buffer of c2 is full now
+		require.False(t, hasPending())
+		require.NoError(t, RemoveSelectorSend(s, c2))
+
+		settable.SetValue(true)
+		require.True(t, hasPending())
+		s.Select(ctx)
+		require.False(t, hasPending())
+		// the selected future case is kept until it is removed
+		require.Len(t, s.(*selectorImpl).cases, 2)
+		require.NoError(t, RemoveSelectorFuture(s, f))
+		require.Len(t, s.(*selectorImpl).cases, 1)
+
+		Go(ctx, func(ctx Context) {
+			c1.Send(ctx, "one")
+			c1.Send(ctx, "two")
+		})
+		s.Select(ctx)
+		// replace the function of the c1 case
+		require.NoError(t, RemoveSelectorReceive(s, c1))
+		s.AddReceive(c1, func(c Channel, more bool) {
+			var v string
+			c.Receive(ctx, &v)
+			history = append(history, "replaced-"+v)
+		})
+		s.Select(ctx)
+
+		s.AddDefault(func() {
+			history = append(history, "default")
+		})
+		s.Select(ctx)
+		require.NoError(t, RemoveSelectorDefault(s))
+		require.NoError(t, RemoveSelectorReceive(s, c1))
+		require.False(t, hasPending())
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+	require.EqualValues(t, []string{"send", "future", "c1-one", "replaced-two", "default"}, history)
+}
+
+type testForeignSelector struct {
+	Selector
+}
+
+func TestSelectorHelpersRejectForeignSelector(t *testing.T) {
+	d, _ := newDispatcher(createRootTestContext(), func(ctx Context) {
+		s := testForeignSelector{Selector: NewSelector(ctx)}
+		c := NewChannel(ctx)
+		f, _ := NewFuture(ctx)
+		expected := "Selector wasn't created with workflow.NewSelector"
+
+		selected, err := SelectWithTimeout(ctx, s, time.Minute)
+		require.EqualError(t, err, expected)
+		require.False(t, selected)
+		pending, err := SelectorHasPending(s)
+		require.EqualError(t, err, expected)
+		require.False(t, pending)
+		require.EqualError(t, RemoveSelectorReceive(s, c), expected)
+		require.EqualError(t, RemoveSelectorSend(s, c), expected)
+		require.EqualError(t, RemoveSelectorFuture(s, f), expected)
+		require.EqualError(t, RemoveSelectorDefault(s), expected)
+	})
+	requireNoExecuteErr(t, d.ExecuteUntilAllBlocked())
+	require.True(t, d.IsDone())
+}
@@ -27,6 +27,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -42,6 +43,14 @@ import (
 
 const (
 	queryResultSizeLimit = 2000000 // 2MB
+
+	// estimatedCoroutineMemoryBytes is a rough per coroutine cost (goroutine stack and bookkeeping) used to
+	// estimate how much memory a cached workflow execution holds on to.
+	estimatedCoroutineMemoryBytes = 8 * 1024
+
+	// estimatedHistoryEventOverheadBytes is a rough serialized size of the IDs, timestamps and other fixed fields of a
+	// history event, on top of its payloads.
+	estimatedHistoryEventOverheadBytes = 128
 )
 
 // Assert that structs do indeed implement the interfaces
@@ -101,6 +110,7 @@ type (
 		counterID         int32     // To generate sequence IDs for activity/timer etc.
 		currentReplayTime time.Time // Indicates current replay time of the decision.
 		currentLocalTime  time.Time // Local time when currentReplayTime was updated.
+		historySizeBytes  int64     // Estimated serialized size of the history events processed so far.
 
 		completeHandler completionHandler               // events completion handler
 		cancelHandler   func()                          // A cancel handler to be invoked on a cancel notification
@@ -116,6 +126,10 @@ type (
 		dataConverter      DataConverter
 		contextPropagators []ContextPropagator
 		tracer             opentracing.Tracer
+
+		deadlockDetectionTimeout time.Duration
+		searchAttributesSchema   *searchAttributesSchema // nil when the upserts are not validated
+		searchAttributesErr      error                   // first failed validation of an upsert, fails the decision task
 	}
 
 	localActivityTask struct {
@@ -130,6 +144,9 @@ type (
 		attempt      int32 // attempt starting from 0
 		retryPolicy  *RetryPolicy
 		expireTime   time.Time
+
+		heartbeatDetails  []byte // last details recorded by the local activity, kept across attempts
+		lastHeartbeatTime time.Time
 	}
 
 	localActivityMarkerData struct {
@@ -141,6 +158,9 @@ type (
 		ReplayTime   time.Time     `json:"replayTime,omitempty"`
 		Attempt      int32         `json:"attempt,omitempty"` // record attempt, starting from 0.
 		Backoff      time.Duration `json:"backoff,omitempty"` // retry backoff duration
+		// HeartbeatDetails are the last details recorded by a failed attempt, handed to the next attempt. They are
+		// recorded so that they survive the eviction of the workflow and the restart of the worker.
+		HeartbeatDetails []byte `json:"heartbeatDetails,omitempty"`
 	}
 
 	// wrapper around zapcore.Core that will be aware of replay
@@ -179,6 +199,8 @@ func newWorkflowExecutionEventHandler(
 	dataConverter DataConverter,
 	contextPropagators []ContextPropagator,
 	tracer opentracing.Tracer,
+	deadlockDetectionTimeout time.Duration,
+	searchAttributesSchema *searchAttributesSchema,
 ) workflowExecutionEventHandler {
 	context := &workflowEnvironmentImpl{
 		workflowInfo:          workflowInfo,
@@ -192,9 +214,12 @@ func newWorkflowExecutionEventHandler(
 		completeHandler:       completeHandler,
 		enableLoggingInReplay: enableLoggingInReplay,
 		hostEnv:               hostEnv,
-		dataConverter:         dataConverter,
+		dataConverter:         dataConverterForWorkflow(dataConverter, workflowInfo.WorkflowExecution.ID),
 		contextPropagators:    contextPropagators,
 		tracer:                tracer,
+
+		deadlockDetectionTimeout: deadlockDetectionTimeout,
+		searchAttributesSchema:   searchAttributesSchema,
 	}
 	context.logger = logger.With(
 		zapcore.Field{Key: tagWorkflowType, Type: zapcore.StringType, String: workflowInfo.WorkflowType.Name},
@@ -297,6 +322,17 @@ func (wc *workflowEnvironmentImpl) UpsertSearchAttributes(attributes map[string]
 	if err != nil {
 		return err
 	}
+	if wc.searchAttributesSchema != nil && !wc.isReplay {
+		// The schema is loaded by this worker and can differ from the one the history was written with, so the
+		// outcome must not reach the workflow code. A failed validation fails the decision task, which is retried,
+		// and replayed upserts are not validated as they were accepted already.
+		if err := wc.searchAttributesSchema.validate(attributes); err != nil {
+			if wc.searchAttributesErr == nil {
+				wc.searchAttributesErr = err
+			}
+			return nil
+		}
+	}
 
 	var upsertID string
 	if changeVersion, ok := attributes[CadenceChangeVersion]; ok {
@@ -414,6 +450,10 @@ func (wc *workflowEnvironmentImpl) GetDataConverter() DataConverter {
 	return wc.dataConverter
 }
 
+func (wc *workflowEnvironmentImpl) GetDeadlockDetectionTimeout() time.Duration {
+	return wc.deadlockDetectionTimeout
+}
+
 func (wc *workflowEnvironmentImpl) GetContextPropagators() []ContextPropagator {
 	return wc.contextPropagators
 }
@@ -499,6 +539,8 @@ func newLocalActivityTask(params executeLocalActivityParams, callback laResultHa
 		callback:    callback,
 		retryPolicy: params.RetryPolicy,
 		attempt:     params.Attempt,
+
+		heartbeatDetails: params.HeartbeatDetails,
 	}
 
 	if params.RetryPolicy != nil && params.RetryPolicy.ExpirationInterval > 0 {
@@ -507,6 +549,19 @@ func newLocalActivityTask(params executeLocalActivityParams, callback laResultHa
 	return task
 }
 
+func (t *localActivityTask) recordHeartbeat(details []byte) {
+	t.Lock()
+	defer t.Unlock()
+	t.heartbeatDetails = details
+	t.lastHeartbeatTime = time.Now()
+}
+
+func (t *localActivityTask) getHeartbeat() ([]byte, time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	return t.heartbeatDetails, t.lastHeartbeatTime
+}
+
 func (wc *workflowEnvironmentImpl) RequestCancelLocalActivity(activityID string) {
 	if task, ok := wc.pendingLaTasks[activityID]; ok {
 		task.cancel()
@@ -677,9 +732,13 @@ func (wc *workflowEnvironmentImpl) MutableSideEffect(id string, f func() interfa
 
 func (wc *workflowEnvironmentImpl) isEqualValue(newValue interface{}, encodedOldValue []byte, equals func(a, b interface{}) bool) bool {
 	if newValue == nil {
-		// new value is nil
-		newEncodedValue := wc.encodeValue(nil)
-		return bytes.Equal(newEncodedValue, encodedOldValue)
+		// new value is nil, compare the decoded old value as the encoding of a DataConverter is not necessarily
+		// deterministic, an encrypting one uses a random nonce for example
+		var oldValue interface{}
+		if err := wc.GetDataConverter().FromData(encodedOldValue, &oldValue); err != nil {
+			return false
+		}
+		return oldValue == nil
 	}
 
 	oldValue := decodeValue(newEncodedValue(encodedOldValue, wc.GetDataConverter()), newValue)
@@ -742,6 +801,13 @@ func (weh *workflowExecutionEventHandlerImpl) ProcessEvent(
 	if event == nil {
 		return errors.New("nil event provided")
 	}
+	weh.historySizeBytes += estimateHistoryEventSize(event)
+	if event.GetEventType() == m.EventTypeDecisionTaskStarted {
+		// only updated at the start of a decision so that the workflow code sees the same values when replayed
+		weh.workflowInfo.HistoryLength = event.GetEventId()
+		weh.workflowInfo.HistorySizeBytes = weh.historySizeBytes
+	}
+
 	defer func() {
 		if p := recover(); p != nil {
 			weh.metricsScope.Counter(metrics.DecisionTaskPanicCounter).Inc(1)
@@ -932,8 +998,133 @@ func (weh *workflowExecutionEventHandlerImpl) ProcessQuery(queryType string, que
 	}
 }
 
+// estimatedMemoryBytes approximates the memory held by this workflow execution from the size of the history
+// it has processed and the number of coroutines it keeps alive.
+func (weh *workflowExecutionEventHandlerImpl) estimatedMemoryBytes() int64 {
+	bytes := weh.historySizeBytes
+	if weh.workflowDefinition != nil {
+		bytes += int64(weh.workflowDefinition.CoroutineCount()) * estimatedCoroutineMemoryBytes
+	}
+	return bytes
+}
+
+// estimateHistoryEventSize approximates the serialized size of an event from its payloads, which dominate the
+// size of most events, plus a fixed overhead for the remaining fields. It avoids serializing every event.
+func estimateHistoryEventSize(event *m.HistoryEvent) int64 {
+	size := estimatedHistoryEventOverheadBytes
+	switch event.GetEventType() {
+	case m.EventTypeWorkflowExecutionStarted:
+		attr := event.WorkflowExecutionStartedEventAttributes
+		size += len(attr.Input) + len(attr.ContinuedFailureDetails) + len(attr.LastCompletionResult)
+		size += memoSize(attr.Memo) + searchAttributesSize(attr.SearchAttributes) + headerSize(attr.Header)
+	case m.EventTypeWorkflowExecutionCompleted:
+		size += len(event.WorkflowExecutionCompletedEventAttributes.Result)
+	case m.EventTypeWorkflowExecutionFailed:
+		size += len(event.WorkflowExecutionFailedEventAttributes.Details)
+	case m.EventTypeWorkflowExecutionCanceled:
+		size += len(event.WorkflowExecutionCanceledEventAttributes.Details)
+	case m.EventTypeWorkflowExecutionContinuedAsNew:
+		attr := event.WorkflowExecutionContinuedAsNewEventAttributes
+		size += len(attr.Input) + len(attr.FailureDetails) + len(attr.LastCompletionResult)
+		size += memoSize(attr.Memo) + searchAttributesSize(attr.SearchAttributes) + headerSize(attr.Header)
+	case m.EventTypeWorkflowExecutionSignaled:
+		size += len(event.WorkflowExecutionSignaledEventAttributes.Input)
+	case m.EventTypeDecisionTaskCompleted:
+		size += len(event.DecisionTaskCompletedEventAttributes.ExecutionContext)
+	case m.EventTypeActivityTaskScheduled:
+		attr := event.ActivityTaskScheduledEventAttributes
+		size += len(attr.Input) + headerSize(attr.Header)
+	case m.EventTypeActivityTaskStarted:
+		size += len(event.ActivityTaskStartedEventAttributes.LastFailureDetails)
+	case m.EventTypeActivityTaskCompleted:
+		size += len(event.ActivityTaskCompletedEventAttributes.Result)
+	case m.EventTypeActivityTaskFailed:
+		size += len(event.ActivityTaskFailedEventAttributes.Details)
+	case m.EventTypeActivityTaskTimedOut:
+		attr := event.ActivityTaskTimedOutEventAttributes
+		size += len(attr.Details) + len(attr.LastFailureDetails)
+	case m.EventTypeActivityTaskCanceled:
+		size += len(event.ActivityTaskCanceledEventAttributes.Details)
+	case m.EventTypeMarkerRecorded:
+		attr := event.MarkerRecordedEventAttributes
+		size += len(attr.Details) + headerSize(attr.Header)
+	case m.EventTypeStartChildWorkflowExecutionInitiated:
+		attr := event.StartChildWorkflowExecutionInitiatedEventAttributes
+		size += len(attr.Input) + len(attr.Control)
+		size += memoSize(attr.Memo) + searchAttributesSize(attr.SearchAttributes) + headerSize(attr.Header)
+	case m.EventTypeChildWorkflowExecutionCompleted:
+		size += len(event.ChildWorkflowExecutionCompletedEventAttributes.Result)
+	case m.EventTypeChildWorkflowExecutionFailed:
+		size += len(event.ChildWorkflowExecutionFailedEventAttributes.Details)
+	case m.EventTypeSignalExternalWorkflowExecutionInitiated:
+		attr := event.SignalExternalWorkflowExecutionInitiatedEventAttributes
+		size += len(attr.Input) + len(attr.Control)
+	case m.EventTypeUpsertWorkflowSearchAttributes:
+		size += searchAttributesSize(event.UpsertWorkflowSearchAttributesEventAttributes.SearchAttributes)
+	}
+	return int64(size)
+}
+
+func memoSize(memo *m.Memo) int {
+	if memo == nil {
+		return 0
+	}
+	return fieldsSize(memo.Fields)
+}
+
+func searchAttributesSize(attributes *m.SearchAttributes) int {
+	if attributes == nil {
+		return 0
+	}
+	return fieldsSize(attributes.IndexedFields)
+}
+
+func headerSize(header *m.Header) int {
+	if header == nil {
+		return 0
+	}
+	return fieldsSize(header.Fields)
+}
+
+func fieldsSize(fields map[string][]byte) int {
+	size := 0
+	for k, v := range fields {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
 func (weh *workflowExecutionEventHandlerImpl) StackTrace() string {
-	return weh.workflowDefinition.StackTrace()
+	stackTrace := weh.workflowDefinition.StackTrace()
+	if laProgress := weh.localActivitiesProgress(); laProgress != "" {
+		stackTrace += "\n" + laProgress
+	}
+	return stackTrace
+}
+
+// localActivitiesProgress describes the pending local activities along with the time and size of the last heartbeat
+// details they recorded, so a long running local activity can be inspected through the __stack_trace query. The
+// details are not decoded as they can be of any type.
+func (weh *workflowExecutionEventHandlerImpl) localActivitiesProgress() string {
+	if len(weh.pendingLaTasks) == 0 {
+		return ""
+	}
+	var activityIDs []string
+	for activityID := range weh.pendingLaTasks {
+		activityIDs = append(activityIDs, activityID)
+	}
+	sort.Strings(activityIDs)
+
+	var buf bytes.Buffer
+	for _, activityID := range activityIDs {
+		task := weh.pendingLaTasks[activityID]
+		fmt.Fprintf(&buf, "local activity %v [%v] attempt %v", activityID, getFunctionName(task.params.ActivityFn), task.attempt)
+		if details, heartbeatTime := task.getHeartbeat(); !heartbeatTime.IsZero() {
+			fmt.Fprintf(&buf, ", last heartbeat at %v with %d bytes of details", heartbeatTime.UTC().Format(time.RFC3339), len(details))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
 }
 
 func (weh *workflowExecutionEventHandlerImpl) Close() {
@@ -1089,6 +1280,7 @@ func (weh *workflowExecutionEventHandlerImpl) handleLocalActivityMarker(markerDa
 		if len(lamd.ErrReason) > 0 {
 			lar.attempt = lamd.Attempt
 			lar.backoff = lamd.Backoff
+			lar.heartbeatDetails = lamd.HeartbeatDetails
 			lar.err = constructError(lamd.ErrReason, []byte(lamd.ErrJSON), weh.GetDataConverter())
 		} else {
 			lar.result = []byte(lamd.ResultJSON)
@@ -1118,6 +1310,10 @@ func (weh *workflowExecutionEventHandlerImpl) ProcessLocalActivityResult(lar *lo
 		lamd.ErrReason = errReason
 		lamd.ErrJSON = string(errDetails)
 		lamd.Backoff = lar.backoff
+		if lar.backoff > 0 {
+			// the details are only needed by the attempt which is retried after the backoff
+			lamd.HeartbeatDetails, _ = lar.task.getHeartbeat()
+		}
 	} else {
 		lamd.ResultJSON = string(lar.result)
 	}
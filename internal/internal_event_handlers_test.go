@@ -21,8 +21,11 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -94,6 +97,21 @@ func TestDecodedValue_WithDataConverter(t *testing.T) {
 	testDecodeValueHelper(t, env)
 }
 
+func TestDecodedValue_WithEncryptingDataConverter(t *testing.T) {
+	t.Parallel()
+	env := &workflowEnvironmentImpl{
+		dataConverter: NewEncryptingDataConverter(getDefaultDataConverter(), EncryptionOptions{
+			KeyProvider: NewStaticKeyProvider("key", map[string][]byte{"key": make([]byte, 32)}),
+		}),
+	}
+	testDecodeValueHelper(t, env)
+
+	// the same value is encrypted differently every time, so MutableSideEffect must compare the decoded values
+	require.NotEqual(t, env.encodeValue(nil), env.encodeValue(nil))
+	require.True(t, env.isEqualValue(nil, env.encodeValue(nil), nil))
+	require.False(t, env.isEqualValue(nil, env.encodeValue("any-non-nil-value"), nil))
+}
+
 func Test_DecodedValuePtr(t *testing.T) {
 	t.Parallel()
 	env := &workflowEnvironmentImpl{
@@ -183,6 +201,78 @@ func Test_UpsertSearchAttributes(t *testing.T) {
 	require.Equal(t, int32(1), env.counterID)
 }
 
+func Test_UpsertSearchAttributesWithSchema(t *testing.T) {
+	t.Parallel()
+	env := &workflowEnvironmentImpl{
+		decisionsHelper: newDecisionsHelper(),
+		workflowInfo:    GetWorkflowInfo(createRootTestContext()),
+		searchAttributesSchema: &searchAttributesSchema{keys: map[string]s.IndexedValueType{
+			"CustomerId":   s.IndexedValueTypeKeyword,
+			"OrderCount":   s.IndexedValueTypeInt,
+			"Amount":       s.IndexedValueTypeDouble,
+			"Shipped":      s.IndexedValueTypeBool,
+			"DeliveryTime": s.IndexedValueTypeDatetime,
+		}},
+	}
+	require.NoError(t, env.UpsertSearchAttributes(map[string]interface{}{
+		CadenceChangeVersion: []string{"change1-1"},
+	}))
+	require.NoError(t, env.UpsertSearchAttributes(map[string]interface{}{
+		"CustomerId":   []string{"c1", "c2"},
+		"OrderCount":   3,
+		"Amount":       12.5,
+		"Shipped":      true,
+		"DeliveryTime": "2020-01-02T03:04:05Z",
+	}))
+
+	// a failed validation fails the decision task instead of returning an error to the workflow
+	invalidUpsert := func(attributes map[string]interface{}) error {
+		env.searchAttributesErr = nil
+		require.NoError(t, env.UpsertSearchAttributes(attributes))
+		return env.searchAttributesErr
+	}
+	err := invalidUpsert(map[string]interface{}{"Unknown": 1})
+	require.EqualError(t, err, "search attribute Unknown is not registered on the server")
+	err = invalidUpsert(map[string]interface{}{"OrderCount": "3"})
+	require.EqualError(t, err, "search attribute OrderCount of type INT does not accept value 3 of type string")
+	err = invalidUpsert(map[string]interface{}{"DeliveryTime": "tomorrow"})
+	require.Error(t, err)
+	require.Equal(t, 2, len(env.decisionsHelper.decisions))
+	env.searchAttributesErr = nil
+
+	// the upserts replayed from the history were accepted already and are not validated again
+	env.isReplay = true
+	require.NoError(t, env.UpsertSearchAttributes(map[string]interface{}{"Unknown": 1}))
+	require.Equal(t, 3, len(env.decisionsHelper.decisions))
+
+	// nothing is validated when the schema could not be loaded
+	env.isReplay = false
+	env.searchAttributesSchema = &searchAttributesSchema{}
+	require.NoError(t, env.UpsertSearchAttributes(map[string]interface{}{"Unknown": 1}))
+}
+
+func Test_LocalActivitiesProgress(t *testing.T) {
+	t.Parallel()
+	laFn := func(ctx context.Context) error { return nil }
+	weh := &workflowExecutionEventHandlerImpl{workflowEnvironmentImpl: &workflowEnvironmentImpl{
+		pendingLaTasks: map[string]*localActivityTask{
+			"2": newLocalActivityTask(executeLocalActivityParams{ActivityFn: laFn, Attempt: 1}, nil, "2"),
+			"1": newLocalActivityTask(executeLocalActivityParams{ActivityFn: laFn}, nil, "1"),
+		},
+	}}
+	require.Equal(t, "", (&workflowExecutionEventHandlerImpl{workflowEnvironmentImpl: &workflowEnvironmentImpl{}}).localActivitiesProgress())
+
+	data, err := encodeArgs(getDefaultDataConverter(), []interface{}{"rows", 42})
+	require.NoError(t, err)
+	weh.pendingLaTasks["2"].recordHeartbeat(data)
+	_, heartbeatTime := weh.pendingLaTasks["2"].getHeartbeat()
+
+	laType := getFunctionName(laFn)
+	require.Equal(t, fmt.Sprintf("local activity 1 [%v] attempt 0\n"+
+		"local activity 2 [%v] attempt 1, last heartbeat at %v with %d bytes of details\n",
+		laType, laType, heartbeatTime.UTC().Format(time.RFC3339), len(data)), weh.localActivitiesProgress())
+}
+
 func Test_MergeSearchAttributes(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
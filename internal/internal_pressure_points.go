@@ -22,11 +22,14 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"strconv"
 	"time"
 
+	"github.com/uber-go/tally"
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/internal/common/metrics"
 	"go.uber.org/zap"
 )
 
@@ -53,8 +56,26 @@ type (
 		config map[string]map[string]string
 		logger *zap.Logger
 	}
+
+	// faultScope describes the task a fault is about to be injected into.
+	faultScope struct {
+		workflowType string
+		activityType string
+		taskList     string
+	}
+
+	// faultInjector injects the faults configured by WorkerOptions.FaultInjectionRules. A nil faultInjector never
+	// injects any fault.
+	faultInjector struct {
+		rules        []FaultInjectionRule
+		logger       *zap.Logger
+		metricsScope tally.Scope
+	}
 )
 
+// errFaultInjected is returned in place of the real outcome of an operation failed by a fault injection rule.
+var errFaultInjected = errors.New("fault injected by worker fault injection rule")
+
 // newWorkflowWorkerWithPressurePoints returns an instance of a workflow worker.
 func newWorkflowWorkerWithPressurePoints(
 	service workflowserviceclient.Interface,
@@ -100,3 +121,78 @@ func (p *pressurePointMgrImpl) Execute(pressurePointName string) error {
 	}
 	return nil
 }
+
+// newFaultInjector returns a fault injector for the fault injection rules of the worker, nil if there are none.
+func newFaultInjector(params workerExecutionParameters) *faultInjector {
+	if len(params.FaultInjectionRules) == 0 {
+		return nil
+	}
+	return &faultInjector{
+		rules:        params.FaultInjectionRules,
+		logger:       params.Logger,
+		metricsScope: params.MetricsScope,
+	}
+}
+
+// inject returns true if a fault of the given type has to be injected into the task.
+func (f *faultInjector) inject(faultType FaultType, scope faultScope) bool {
+	_, ok := f.match(faultType, scope)
+	return ok
+}
+
+// delay sleeps for the delay of the first delay rule which fires for the task, or until the worker is stopped.
+func (f *faultInjector) delay(scope faultScope, stopC <-chan struct{}) {
+	rule, ok := f.match(FaultTypeDelay, scope)
+	if !ok || rule.Delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(rule.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stopC:
+	}
+}
+
+func (f *faultInjector) match(faultType FaultType, scope faultScope) (*FaultInjectionRule, bool) {
+	if f == nil {
+		return nil, false
+	}
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if rule.Type != faultType || !rule.matches(scope) {
+			continue
+		}
+		if rule.Probability < 1 && rand.Float64() >= rule.Probability {
+			continue
+		}
+		f.logger.Info("Fault injected.",
+			zap.String("FaultType", faultType.String()),
+			zap.String(tagWorkflowType, scope.workflowType),
+			zap.String(tagActivityType, scope.activityType),
+			zap.String(tagTaskList, scope.taskList))
+		f.metricsScope.Counter(metrics.FaultInjectedCounter).Inc(1)
+		return rule, true
+	}
+	return nil, false
+}
+
+// validateFaultInjectionRules rejects rules without a valid type or probability, so that a zero value rule never
+// injects faults by accident.
+func validateFaultInjectionRules(rules []FaultInjectionRule) error {
+	for i, rule := range rules {
+		if rule.Type <= FaultTypeInvalid || rule.Type > FaultTypeHeartbeatFailure {
+			return fmt.Errorf("fault injection rule %d has invalid type %v", i, rule.Type)
+		}
+		if rule.Probability <= 0 || rule.Probability > 1 {
+			return fmt.Errorf("fault injection rule %d has probability %v, it must be greater than 0 and at most 1", i, rule.Probability)
+		}
+	}
+	return nil
+}
+
+func (r *FaultInjectionRule) matches(scope faultScope) bool {
+	return (r.WorkflowType == "" || r.WorkflowType == scope.workflowType) &&
+		(r.ActivityType == "" || r.ActivityType == scope.activityType) &&
+		(r.TaskList == "" || r.TaskList == scope.taskList)
+}
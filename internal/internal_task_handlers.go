@@ -50,6 +50,15 @@ const (
 
 	defaultStickyCacheSize = 10000
 
+	// autoHeartbeatIntervalRatio is the fraction of the heartbeat timeout after which the worker heartbeats on
+	// behalf of an activity which has auto heartbeat enabled.
+	autoHeartbeatIntervalRatio = 0.5
+
+	// autoHeartbeatHeaderKey is the header a workflow sets when it schedules an activity with auto heartbeat. The
+	// activity task has no other field to carry it, so the key is reserved and the activity worker removes it
+	// before the header reaches any ContextPropagator.
+	autoHeartbeatHeaderKey = "cadence-auto-heartbeat"
+
 	noRetryBackoff = time.Duration(-1)
 )
 
@@ -124,6 +133,9 @@ type (
 		dataConverter                  DataConverter
 		contextPropagators             []ContextPropagator
 		tracer                         opentracing.Tracer
+		workflowCache                  cache.Cache // nil means the process wide sticky cache is used
+		deadlockDetectionTimeout       time.Duration
+		searchAttributesSchema         *searchAttributesSchema
 	}
 
 	activityProvider func(name string) activity
@@ -142,6 +154,7 @@ type (
 		workerStopCh       <-chan struct{}
 		contextPropagators []ContextPropagator
 		tracer             opentracing.Tracer
+		faultInjector      *faultInjector
 	}
 
 	// history wrapper method to help information about events.
@@ -372,6 +385,10 @@ func newWorkflowTaskHandler(
 	hostEnv *hostEnvImpl,
 ) WorkflowTaskHandler {
 	ensureRequiredParams(&params)
+	var workflowCache cache.Cache
+	if params.StickyWorkflowCache != nil {
+		workflowCache = params.StickyWorkflowCache.cache
+	}
 	return &workflowTaskHandlerImpl{
 		domain:                         domain,
 		logger:                         params.Logger,
@@ -385,10 +402,12 @@ func newWorkflowTaskHandler(
 		dataConverter:                  params.DataConverter,
 		contextPropagators:             params.ContextPropagators,
 		tracer:                         params.Tracer,
+		workflowCache:                  workflowCache,
+		deadlockDetectionTimeout:       params.DeadlockDetectionTimeout,
+		searchAttributesSchema:         params.SearchAttributesSchema,
 	}
 }
 
-// TODO: need a better eviction policy based on memory usage
 var workflowCache cache.Cache
 var stickyCacheSize = defaultStickyCacheSize
 var initCacheOnce sync.Once
@@ -414,18 +433,74 @@ func getWorkflowCache() cache.Cache {
 	initCacheOnce.Do(func() {
 		stickyCacheLock.Lock()
 		defer stickyCacheLock.Unlock()
-		workflowCache = cache.New(stickyCacheSize, &cache.Options{
-			RemovedFunc: func(cachedEntity interface{}) {
-				wc := cachedEntity.(*workflowExecutionContextImpl)
-				wc.onEviction()
-			},
-		})
+		workflowCache = newWorkflowContextCache(stickyCacheSize, 0, 0)
 	})
 	return workflowCache
 }
 
-func getWorkflowContext(runID string) *workflowExecutionContextImpl {
-	o := getWorkflowCache().Get(runID)
+func newWorkflowContextCache(maxSize int, maxBytes int64, idleTTL time.Duration) cache.Cache {
+	return cache.New(maxSize, &cache.Options{
+		RemovedFunc: func(cachedEntity interface{}) {
+			wc := cachedEntity.(*workflowExecutionContextImpl)
+			wc.onEviction()
+		},
+		SizeFunc: func(cachedEntity interface{}) int64 {
+			wc := cachedEntity.(*workflowExecutionContextImpl)
+			return wc.estimatedMemoryBytes()
+		},
+		MaxBytes: maxBytes,
+		IdleTTL:  idleTTL,
+	})
+}
+
+// NewStickyWorkflowCache creates a sticky workflow cache which can be assigned to WorkerOptions.StickyWorkflowCache.
+// Unlike the process wide cache sized by SetStickyWorkflowCacheSize, it is only used by the workers it is assigned to
+// and it can bound the estimated memory of the cached workflow executions and evict executions which stay idle.
+func NewStickyWorkflowCache(options StickyWorkflowCacheOptions) *StickyWorkflowCache {
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultStickyCacheSize
+	}
+	return &StickyWorkflowCache{
+		cache: newWorkflowContextCache(maxSize, options.MaxMemoryBytes, options.IdleTimeout),
+	}
+}
+
+// Size returns the number of workflow executions currently cached.
+func (c *StickyWorkflowCache) Size() int {
+	return c.cache.Size()
+}
+
+// EstimatedMemoryBytes returns the estimated memory held by all cached workflow executions.
+func (c *StickyWorkflowCache) EstimatedMemoryBytes() int64 {
+	return c.cache.Bytes()
+}
+
+// CachedRunIDs returns the run IDs of the cached workflow executions, most recently used first.
+func (c *StickyWorkflowCache) CachedRunIDs() []string {
+	return c.cache.Keys()
+}
+
+// Evict removes the given workflow execution from the cache. If the execution is still open, the worker which cached
+// it resets its stickiness on the server, so the next decision task can be dispatched to any worker and replays the
+// full history. It returns false if the execution was not cached.
+func (c *StickyWorkflowCache) Evict(runID string) bool {
+	if !c.cache.Exist(runID) {
+		return false
+	}
+	c.cache.Delete(runID)
+	return true
+}
+
+func (wth *workflowTaskHandlerImpl) getWorkflowCache() cache.Cache {
+	if wth.workflowCache != nil {
+		return wth.workflowCache
+	}
+	return getWorkflowCache()
+}
+
+func (wth *workflowTaskHandlerImpl) getWorkflowContext(runID string) *workflowExecutionContextImpl {
+	o := wth.getWorkflowCache().Get(runID)
 	if o == nil {
 		return nil
 	}
@@ -433,18 +508,14 @@ func getWorkflowContext(runID string) *workflowExecutionContextImpl {
 	return wc
 }
 
-func putWorkflowContext(runID string, wc *workflowExecutionContextImpl) (*workflowExecutionContextImpl, error) {
-	existing, err := getWorkflowCache().PutIfNotExist(runID, wc)
+func (wth *workflowTaskHandlerImpl) putWorkflowContext(runID string, wc *workflowExecutionContextImpl) (*workflowExecutionContextImpl, error) {
+	existing, err := wth.getWorkflowCache().PutIfNotExist(runID, wc)
 	if err != nil {
 		return nil, err
 	}
 	return existing.(*workflowExecutionContextImpl), nil
 }
 
-func removeWorkflowContext(runID string) {
-	getWorkflowCache().Delete(runID)
-}
-
 func newWorkflowExecutionContext(
 	startTime time.Time,
 	workflowInfo *WorkflowInfo,
@@ -468,12 +539,15 @@ func (w *workflowExecutionContextImpl) Unlock(err error) {
 		// TODO: in case of closed, it asumes the close decision always succeed. need server side change to return
 		// error to indicate the close failure case. This should be rear case. For now, always remove the cache, and
 		// if the close decision failed, the next decision will have to rebuild the state.
-		if getWorkflowCache().Exist(w.workflowInfo.WorkflowExecution.RunID) {
-			removeWorkflowContext(w.workflowInfo.WorkflowExecution.RunID)
+		if w.wth.getWorkflowCache().Exist(w.workflowInfo.WorkflowExecution.RunID) {
+			w.wth.getWorkflowCache().Delete(w.workflowInfo.WorkflowExecution.RunID)
 		} else {
 			// sticky is disabled, manually clear the workflow state.
 			w.clearState()
 		}
+	} else if w.wth.getWorkflowContext(w.workflowInfo.WorkflowExecution.RunID) == w {
+		// the workflow state may have grown while processing the task, re-evaluate it against the memory budget.
+		w.wth.getWorkflowCache().UpdateSize(w.workflowInfo.WorkflowExecution.RunID)
 	}
 
 	w.mutex.Unlock()
@@ -515,6 +589,10 @@ func (w *workflowExecutionContextImpl) onEviction() {
 	// may be ascertained about the execution context's state,
 	// nor should any of its methods be invoked.
 	if w.shouldResetStickyOnEviction() {
+		// counted here rather than when the reset is sent, so that evictions by the size, memory and idle limits
+		// are reported even when there is no worker to send the reset, e.g. in the replayer.
+		w.wth.metricsScope.GetTaggedScope(tagWorkflowType, w.workflowInfo.WorkflowType.Name).
+			Counter(metrics.StickyCacheEvict).Inc(1)
 		w.queueResetStickinessTask()
 	}
 
@@ -522,6 +600,14 @@ func (w *workflowExecutionContextImpl) onEviction() {
 	w.mutex.Unlock()
 }
 
+func (w *workflowExecutionContextImpl) estimatedMemoryBytes() int64 {
+	eventHandler := w.getEventHandler()
+	if eventHandler == nil {
+		return 0
+	}
+	return eventHandler.estimatedMemoryBytes()
+}
+
 func (w *workflowExecutionContextImpl) IsDestroyed() bool {
 	return w.getEventHandler() == nil
 }
@@ -571,6 +657,8 @@ func (w *workflowExecutionContextImpl) createEventHandler() {
 		w.wth.dataConverter,
 		w.wth.contextPropagators,
 		w.wth.tracer,
+		w.wth.deadlockDetectionTimeout,
+		w.wth.searchAttributesSchema,
 	)
 	w.eventHandler.Store(eventHandler)
 }
@@ -625,6 +713,12 @@ func (wth *workflowTaskHandlerImpl) createWorkflowContext(task *s.PollForDecisio
 		ParentWorkflowExecution:             parentWorkflowExecution,
 		Memo:                                attributes.Memo,
 		SearchAttributes:                    attributes.SearchAttributes,
+		originalRunID:                       attributes.GetOriginalExecutionRunId(),
+		FirstRunID:                          attributes.FirstExecutionRunId,
+		Initiator:                           attributes.Initiator,
+	}
+	if attributes.ExpirationTimestamp != nil {
+		workflowInfo.ExpirationTime = time.Unix(0, attributes.GetExpirationTimestamp())
 	}
 
 	wfStartTime := time.Unix(0, h.Events[0].GetTimestamp())
@@ -640,7 +734,9 @@ func (wth *workflowTaskHandlerImpl) getOrCreateWorkflowContext(
 		if err == nil && workflowContext != nil && workflowContext.laTunnel == nil {
 			workflowContext.laTunnel = wth.laTunnel
 		}
-		metricsScope.Gauge(metrics.StickyCacheSize).Update(float64(getWorkflowCache().Size()))
+		workflowCache := wth.getWorkflowCache()
+		metricsScope.Gauge(metrics.StickyCacheSize).Update(float64(workflowCache.Size()))
+		metricsScope.Gauge(metrics.StickyCacheMemoryBytes).Update(float64(workflowCache.Bytes()))
 	}()
 
 	runID := task.WorkflowExecution.GetRunId()
@@ -650,7 +746,7 @@ func (wth *workflowTaskHandlerImpl) getOrCreateWorkflowContext(
 
 	workflowContext = nil
 	if task.Query == nil || (task.Query != nil && !isFullHistory) {
-		workflowContext = getWorkflowContext(runID)
+		workflowContext = wth.getWorkflowContext(runID)
 	}
 
 	if workflowContext != nil {
@@ -680,7 +776,7 @@ func (wth *workflowTaskHandlerImpl) getOrCreateWorkflowContext(
 		}
 
 		if !wth.disableStickyExecution && task.Query == nil {
-			workflowContext, _ = putWorkflowContext(runID, workflowContext)
+			workflowContext, _ = wth.putWorkflowContext(runID, workflowContext)
 		}
 		workflowContext.Lock()
 	}
@@ -936,6 +1032,9 @@ ProcessEvents:
 		}
 	}
 
+	if err := w.checkSearchAttributes(task); err != nil {
+		return nil, err
+	}
 	return w.CompleteDecisionTask(workflowTask, true), nil
 }
 
@@ -949,9 +1048,29 @@ func (w *workflowExecutionContextImpl) ProcessLocalActivityResult(workflowTask *
 		return nil, err
 	}
 
+	if err := w.checkSearchAttributes(workflowTask.task); err != nil {
+		return nil, err
+	}
 	return w.CompleteDecisionTask(workflowTask, true), nil
 }
 
+// checkSearchAttributes returns the error of a failed search attributes validation. Like a non-deterministic error
+// under NonDeterministicWorkflowPolicyBlockWorkflow it fails the decision task, which is retried, instead of failing
+// the workflow.
+func (w *workflowExecutionContextImpl) checkSearchAttributes(task *s.PollForDecisionTaskResponse) error {
+	err := w.getEventHandler().searchAttributesErr
+	if err == nil {
+		return nil
+	}
+	w.wth.metricsScope.GetTaggedScope(tagWorkflowType, task.WorkflowType.GetName()).Counter(metrics.InvalidSearchAttributesCounter).Inc(1)
+	w.wth.logger.Error("Invalid search attributes upserted.",
+		zap.String(tagWorkflowType, task.WorkflowType.GetName()),
+		zap.String(tagWorkflowID, task.WorkflowExecution.GetWorkflowId()),
+		zap.String(tagRunID, task.WorkflowExecution.GetRunId()),
+		zap.Error(err))
+	return err
+}
+
 func (w *workflowExecutionContextImpl) retryLocalActivity(lar *localActivityResult) bool {
 	if lar.task.retryPolicy == nil || lar.err == nil || IsCanceledError(lar.err) {
 		return false
@@ -1005,7 +1124,12 @@ func getRetryBackoff(lar *localActivityResult, now time.Time) time.Duration {
 			errReason, _ = getErrorDetails(lar.err, nil)
 		}
 	}
-	return getRetryBackoffWithNowTime(p, lar.task.attempt, errReason, now, lar.task.expireTime)
+	backoff := getRetryBackoffWithNowTime(p, lar.task.attempt, errReason, now, lar.task.expireTime)
+	if backoff > 0 && lar.task.params != nil && lar.task.params.ShouldRetry != nil &&
+		!lar.task.params.ShouldRetry(lar.err, lar.task.attempt, backoff) {
+		return noRetryBackoff
+	}
+	return backoff
 }
 
 func getRetryBackoffWithNowTime(p *RetryPolicy, attempt int32, errReason string, now, expireTime time.Time) time.Duration {
@@ -1448,7 +1572,7 @@ func (wth *workflowTaskHandlerImpl) completeWorkflow(
 		result, err := eventHandler.ProcessQuery(task.Query.GetQueryType(), task.Query.QueryArgs)
 		if err != nil {
 			queryCompletedRequest.CompletedType = common.QueryTaskCompletedTypePtr(s.QueryTaskCompletedTypeFailed)
-			queryCompletedRequest.ErrorMessage = common.StringPtr(err.Error())
+			queryCompletedRequest.ErrorMessage = common.StringPtr(getQueryErrorMessage(err, wth.dataConverter))
 		} else {
 			queryCompletedRequest.CompletedType = common.QueryTaskCompletedTypePtr(s.QueryTaskCompletedTypeCompleted)
 			queryCompletedRequest.QueryResult = result
@@ -1472,11 +1596,12 @@ func (wth *workflowTaskHandlerImpl) completeWorkflow(
 
 	// complete decision task
 	var closeDecision *s.Decision
+	dataConverter := dataConverterForWorkflow(wth.dataConverter, task.WorkflowExecution.GetWorkflowId())
 	if canceledErr, ok := workflowContext.err.(*CanceledError); ok {
 		// Workflow cancelled
 		metricsScope.Counter(metrics.WorkflowCanceledCounter).Inc(1)
 		closeDecision = createNewDecision(s.DecisionTypeCancelWorkflowExecution)
-		_, details := getErrorDetails(canceledErr, wth.dataConverter)
+		_, details := getErrorDetails(canceledErr, dataConverter)
 		closeDecision.CancelWorkflowExecutionDecisionAttributes = &s.CancelWorkflowExecutionDecisionAttributes{
 			Details: details,
 		}
@@ -1498,7 +1623,7 @@ func (wth *workflowTaskHandlerImpl) completeWorkflow(
 		// Workflow failures
 		metricsScope.Counter(metrics.WorkflowFailedCounter).Inc(1)
 		closeDecision = createNewDecision(s.DecisionTypeFailWorkflowExecution)
-		reason, details := getErrorDetails(workflowContext.err, wth.dataConverter)
+		reason, details := getErrorDetails(workflowContext.err, dataConverter)
 		closeDecision.FailWorkflowExecutionDecisionAttributes = &s.FailWorkflowExecutionDecisionAttributes{
 			Reason:  common.StringPtr(reason),
 			Details: details,
@@ -1527,7 +1652,7 @@ func (wth *workflowTaskHandlerImpl) completeWorkflow(
 			if err != nil {
 				queryResults[queryID] = &s.WorkflowQueryResult{
 					ResultType:   common.QueryResultTypePtr(s.QueryResultTypeFailed),
-					ErrorMessage: common.StringPtr(err.Error()),
+					ErrorMessage: common.StringPtr(getQueryErrorMessage(err, wth.dataConverter)),
 				}
 			} else {
 				queryResults[queryID] = &s.WorkflowQueryResult{
@@ -1604,6 +1729,7 @@ func newActivityTaskHandlerWithCustomProvider(
 		workerStopCh:       params.WorkerStopChannel,
 		contextPropagators: params.ContextPropagators,
 		tracer:             params.Tracer,
+		faultInjector:      newFaultInjector(params),
 	}
 }
 
@@ -1618,9 +1744,18 @@ type cadenceInvoker struct {
 	lastDetailsToReport   *[]byte
 	closeCh               chan struct{}
 	workerStopChannel     <-chan struct{}
+	autoHeartbeatStopCh   chan struct{} // Closed when the activity starts to report heartbeats by itself.
+	faultInjector         *faultInjector
+	faultScope            faultScope
 }
 
 func (i *cadenceInvoker) Heartbeat(details []byte) error {
+	// The activity reports its own progress from now on, so it is on its own to keep heartbeating.
+	i.stopAutoHeartbeat()
+	return i.heartbeat(details)
+}
+
+func (i *cadenceInvoker) heartbeat(details []byte) error {
 	i.Lock()
 	defer i.Unlock()
 
@@ -1670,7 +1805,7 @@ func (i *cadenceInvoker) Heartbeat(details []byte) error {
 			i.Unlock()
 
 			if detailsToReport != nil {
-				i.Heartbeat(*detailsToReport)
+				i.heartbeat(*detailsToReport)
 			}
 		}()
 	}
@@ -1678,6 +1813,45 @@ func (i *cadenceInvoker) Heartbeat(details []byte) error {
 	return err
 }
 
+// startAutoHeartbeat heartbeats with the given details on behalf of the activity until the activity calls
+// RecordActivityHeartbeat or the invoker is closed. Heartbeats go through the same batching as the ones reported by
+// the activity, so the server sees them at most every 80% of the heartbeat timeout.
+func (i *cadenceInvoker) startAutoHeartbeat(details []byte) {
+	if i.heartBeatTimeoutInSec <= 0 {
+		return
+	}
+	interval := time.Duration(autoHeartbeatIntervalRatio * float64(time.Duration(i.heartBeatTimeoutInSec)*time.Second))
+	stopCh := make(chan struct{})
+
+	i.Lock()
+	i.autoHeartbeatStopCh = stopCh
+	i.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				i.heartbeat(details)
+			case <-stopCh:
+				return
+			case <-i.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+func (i *cadenceInvoker) stopAutoHeartbeat() {
+	i.Lock()
+	defer i.Unlock()
+	if i.autoHeartbeatStopCh != nil {
+		close(i.autoHeartbeatStopCh)
+		i.autoHeartbeatStopCh = nil
+	}
+}
+
 func (i *cadenceInvoker) internalHeartBeat(details []byte) (bool, error) {
 	isActivityCancelled := false
 	timeout := time.Duration(i.heartBeatTimeoutInSec) * time.Second
@@ -1687,6 +1861,9 @@ func (i *cadenceInvoker) internalHeartBeat(details []byte) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	if i.faultInjector.inject(FaultTypeHeartbeatFailure, i.faultScope) {
+		return false, errFaultInjected
+	}
 	err := recordActivityHeartbeat(ctx, i.service, i.identity, i.taskToken, details)
 
 	switch err.(type) {
@@ -1758,16 +1935,22 @@ func (ath *activityTaskHandlerImpl) Execute(taskList string, t *s.PollForActivit
 	}
 	canCtx, cancel := context.WithCancel(rootCtx)
 
+	workflowType := t.WorkflowType.GetName()
+	activityType := t.ActivityType.GetName()
+	dataConverter := dataConverterForWorkflow(ath.dataConverter, t.WorkflowExecution.GetWorkflowId())
+	scope := faultScope{workflowType: workflowType, activityType: activityType, taskList: taskList}
+
 	invoker := newServiceInvoker(t.TaskToken, ath.identity, ath.service, cancel, t.GetHeartbeatTimeoutSeconds(), ath.workerStopCh)
+	if ci, ok := invoker.(*cadenceInvoker); ok {
+		ci.faultInjector, ci.faultScope = ath.faultInjector, scope
+	}
 	defer func() {
 		_, activityCompleted := result.(*s.RespondActivityTaskCompletedRequest)
 		invoker.Close(!activityCompleted) // flush buffered heartbeat if activity was not successfully completed.
 	}()
 
-	workflowType := t.WorkflowType.GetName()
-	activityType := t.ActivityType.GetName()
 	metricsScope := getMetricsScopeForActivity(ath.metricsScope, workflowType, activityType)
-	ctx := WithActivityTask(canCtx, t, taskList, invoker, ath.logger, metricsScope, ath.dataConverter, ath.workerStopCh, ath.contextPropagators, ath.tracer)
+	ctx := WithActivityTask(canCtx, t, taskList, invoker, ath.logger, metricsScope, dataConverter, ath.workerStopCh, ath.contextPropagators, ath.tracer)
 
 	activityImplementation := ath.getActivity(activityType)
 	if activityImplementation == nil {
@@ -1776,6 +1959,14 @@ func (ath *activityTaskHandlerImpl) Execute(taskList string, t *s.PollForActivit
 		return nil, fmt.Errorf("unable to find activityType=%v. Supported types: [%v]", activityType, supported)
 	}
 
+	header, autoHeartbeatRequested := splitAutoHeartbeatHeader(t.Header)
+	if isAutoHeartbeatEnabled(activityImplementation, autoHeartbeatRequested) {
+		if ci, ok := invoker.(*cadenceInvoker); ok {
+			// keep the progress reported by the previous attempt so a retry can still resume from it.
+			ci.startAutoHeartbeat(t.HeartbeatDetails)
+		}
+	}
+
 	// panic handler
 	defer func() {
 		if p := recover(); p != nil {
@@ -1789,14 +1980,14 @@ func (ath *activityTaskHandlerImpl) Execute(taskList string, t *s.PollForActivit
 				zap.String("PanicStack", st))
 			metricsScope.Counter(metrics.ActivityTaskPanicCounter).Inc(1)
 			panicErr := newPanicError(p, st)
-			result, err = convertActivityResultToRespondRequest(ath.identity, t.TaskToken, nil, panicErr, ath.dataConverter), nil
+			result, err = convertActivityResultToRespondRequest(ath.identity, t.TaskToken, nil, panicErr, dataConverter), nil
 		}
 	}()
 
 	// propagate context information into the activity context from the headers
 	for _, ctxProp := range ath.contextPropagators {
 		var err error
-		if ctx, err = ctxProp.Extract(ctx, NewHeaderReader(t.Header)); err != nil {
+		if ctx, err = ctxProp.Extract(ctx, NewHeaderReader(header)); err != nil {
 			return nil, fmt.Errorf("unable to propagate context %v", err)
 		}
 	}
@@ -1806,6 +1997,9 @@ func (ath *activityTaskHandlerImpl) Execute(taskList string, t *s.PollForActivit
 
 	ctx, span := createOpenTracingActivitySpan(ctx, ath.tracer, time.Now(), activityType, t.WorkflowExecution.GetWorkflowId(), t.WorkflowExecution.GetRunId())
 	defer span.Finish()
+	if ath.faultInjector.inject(FaultTypeActivityPanic, scope) {
+		panic(errFaultInjected)
+	}
 	output, err := activityImplementation.Execute(ctx, t.Input)
 
 	dlCancelFunc()
@@ -1820,7 +2014,33 @@ func (ath *activityTaskHandlerImpl) Execute(taskList string, t *s.PollForActivit
 			zap.Error(err),
 		)
 	}
-	return convertActivityResultToRespondRequest(ath.identity, t.TaskToken, output, err, ath.dataConverter), nil
+	return convertActivityResultToRespondRequest(ath.identity, t.TaskToken, output, err, dataConverter), nil
+}
+
+// isAutoHeartbeatEnabled checks if the activity was registered with auto heartbeat or the workflow scheduled it
+// with auto heartbeat.
+func isAutoHeartbeatEnabled(a activity, requested bool) bool {
+	if ae, ok := a.(*activityExecutor); ok && ae.options.EnableAutoHeartbeat {
+		return true
+	}
+	return requested
+}
+
+// splitAutoHeartbeatHeader returns the header without the reserved auto heartbeat key, and whether the key was set.
+func splitAutoHeartbeatHeader(header *s.Header) (*s.Header, bool) {
+	if header == nil {
+		return nil, false
+	}
+	if _, ok := header.Fields[autoHeartbeatHeaderKey]; !ok {
+		return header, false
+	}
+	fields := make(map[string][]byte, len(header.Fields)-1)
+	for k, v := range header.Fields {
+		if k != autoHeartbeatHeaderKey {
+			fields[k] = v
+		}
+	}
+	return &s.Header{Fields: fields}, true
 }
 
 func (ath *activityTaskHandlerImpl) getActivity(name string) activity {
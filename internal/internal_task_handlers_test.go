@@ -35,6 +35,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 	"go.uber.org/cadence/.gen/go/cadence/workflowservicetest"
 	s "go.uber.org/cadence/.gen/go/shared"
 	"go.uber.org/cadence/internal/common"
@@ -70,10 +71,18 @@ func init() {
 		panicWorkflowFunc,
 		RegisterWorkflowOptions{Name: "PanicWorkflow"},
 	)
+	RegisterWorkflowWithOptions(
+		deadlockWorkflowFunc,
+		RegisterWorkflowOptions{Name: "DeadlockWorkflow"},
+	)
 	RegisterWorkflowWithOptions(
 		getWorkflowInfoWorkflowFunc,
 		RegisterWorkflowOptions{Name: "GetWorkflowInfoWorkflow"},
 	)
+	RegisterWorkflowWithOptions(
+		shouldContinueAsNewWorkflowFunc,
+		RegisterWorkflowOptions{Name: "ShouldContinueAsNewWorkflow"},
+	)
 	RegisterWorkflowWithOptions(
 		querySignalWorkflowFunc,
 		RegisterWorkflowOptions{Name: "QuerySignalWorkflow"},
@@ -96,6 +105,11 @@ func panicWorkflowFunc(ctx Context, input []byte) error {
 	panic("panicError")
 }
 
+func deadlockWorkflowFunc(ctx Context, input []byte) error {
+	<-make(chan struct{}) // blocks on a native primitive instead of a workflow one
+	return nil
+}
+
 func getWorkflowInfoWorkflowFunc(ctx Context, expectedLastCompletionResult string) (info *WorkflowInfo, err error) {
 	result := GetWorkflowInfo(ctx)
 	var lastCompletionResult string
@@ -109,6 +123,12 @@ func getWorkflowInfoWorkflowFunc(ctx Context, expectedLastCompletionResult strin
 	return result, nil
 }
 
+func shouldContinueAsNewWorkflowFunc(ctx Context, historyLength int64) ([]bool, error) {
+	result := []bool{ShouldContinueAsNew(ctx)}
+	ctx = WithContinueAsNewThresholds(ctx, ContinueAsNewThresholds{HistoryLength: historyLength})
+	return append(result, ShouldContinueAsNew(ctx)), nil
+}
+
 // Test suite.
 func (t *TaskHandlersTestSuite) SetupTest() {
 }
@@ -406,6 +426,84 @@ func (t *TaskHandlersTestSuite) TestWorkflowTask_ActivityTaskScheduled() {
 	t.NotNil(response.Decisions[0].CompleteWorkflowExecutionDecisionAttributes)
 }
 
+func (t *TaskHandlersTestSuite) TestWorkflowTask_StickyWorkflowCache() {
+	taskList := "tl1"
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	stickyCache := NewStickyWorkflowCache(StickyWorkflowCacheOptions{MaxSize: 10})
+	scope := tally.NewTestScope("", nil)
+	params := workerExecutionParameters{
+		TaskList:            taskList,
+		Identity:            "test-id-1",
+		Logger:              t.logger,
+		MetricsScope:        scope,
+		StickyWorkflowCache: stickyCache,
+	}
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+	resultCh := make(chan interface{}, 1)
+	taskHandler.(*workflowTaskHandlerImpl).laTunnel = &localActivityTunnel{resultCh: resultCh}
+
+	var runIDs []string
+	for i := 0; i < 2; i++ {
+		task := createWorkflowTask(testEvents, 0, "HelloWorld_Workflow")
+		_, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task}, nil)
+		t.NoError(err)
+		runIDs = append(runIDs, task.WorkflowExecution.GetRunId())
+	}
+
+	t.Equal(2, stickyCache.Size())
+	t.True(stickyCache.EstimatedMemoryBytes() > 2*estimatedCoroutineMemoryBytes)
+	t.Equal([]string{runIDs[1], runIDs[0]}, stickyCache.CachedRunIDs())
+	t.False(getWorkflowCache().Exist(runIDs[0]))
+
+	t.True(stickyCache.Evict(runIDs[0]))
+	t.False(stickyCache.Evict(runIDs[0]))
+	t.Equal([]string{runIDs[1]}, stickyCache.CachedRunIDs())
+
+	// the evicted execution is still open, so its stickiness is reset on the server
+	select {
+	case result := <-resultCh:
+		rst, ok := result.(*resetStickinessTask)
+		t.True(ok)
+		t.Equal(runIDs[0], rst.task.Execution.GetRunId())
+	case <-time.After(time.Second):
+		t.Fail("no reset stickiness task was queued on eviction")
+	}
+	t.EqualValues(1, scope.Snapshot().Counters()["cadence-sticky-cache-evict+WorkflowType=HelloWorld_Workflow"].Value())
+}
+
+func (t *TaskHandlersTestSuite) TestWorkflowTask_StickyWorkflowCacheMemoryBudget() {
+	taskList := "tl1"
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	// the budget fits exactly one workflow execution
+	stickyCache := NewStickyWorkflowCache(StickyWorkflowCacheOptions{MaxMemoryBytes: estimatedCoroutineMemoryBytes + 1024})
+	params := workerExecutionParameters{
+		TaskList:            taskList,
+		Identity:            "test-id-1",
+		Logger:              t.logger,
+		StickyWorkflowCache: stickyCache,
+	}
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+
+	var runIDs []string
+	for i := 0; i < 3; i++ {
+		task := createWorkflowTask(testEvents, 0, "HelloWorld_Workflow")
+		_, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task}, nil)
+		t.NoError(err)
+		runIDs = append(runIDs, task.WorkflowExecution.GetRunId())
+	}
+
+	t.Equal([]string{runIDs[2]}, stickyCache.CachedRunIDs())
+	t.True(stickyCache.EstimatedMemoryBytes() <= estimatedCoroutineMemoryBytes+1024)
+}
+
 func (t *TaskHandlersTestSuite) TestWorkflowTask_QueryWorkflow_Sticky() {
 	// Schedule an activity and see if we complete workflow.
 	taskList := "sticky-tl"
@@ -836,12 +934,70 @@ func (t *TaskHandlersTestSuite) TestWorkflowTask_WorkflowPanics() {
 	t.EqualValues("panicError", string(r.Details))
 }
 
+func (t *TaskHandlersTestSuite) TestWorkflowTask_InvalidSearchAttributes() {
+	workflowFunc := func(ctx Context) error {
+		// the failed validation is not a panic the workflow code could recover from
+		defer func() { recover() }()
+		if err := UpsertSearchAttributes(ctx, map[string]interface{}{"Unknown": 1}); err != nil {
+			return err
+		}
+		return nil
+	}
+	RegisterWorkflowWithOptions(workflowFunc, RegisterWorkflowOptions{Name: "InvalidSearchAttributesWorkflow"})
+
+	taskList := "taskList"
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	task := createWorkflowTask(testEvents, 0, "InvalidSearchAttributesWorkflow")
+	params := workerExecutionParameters{
+		TaskList:               taskList,
+		Identity:               "test-id-1",
+		Logger:                 zap.NewNop(),
+		SearchAttributesSchema: &searchAttributesSchema{keys: map[string]s.IndexedValueType{}},
+	}
+
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+	request, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task}, nil)
+	t.Nil(request)
+	t.EqualError(err, "search attribute Unknown is not registered on the server")
+}
+
+func (t *TaskHandlersTestSuite) TestWorkflowTask_WorkflowDeadlock() {
+	taskList := "taskList"
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	task := createWorkflowTask(testEvents, 3, "DeadlockWorkflow")
+	params := workerExecutionParameters{
+		TaskList:                 taskList,
+		Identity:                 "test-id-1",
+		Logger:                   zap.NewNop(),
+		DeadlockDetectionTimeout: 50 * time.Millisecond,
+	}
+
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+	request, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task}, nil)
+	t.NoError(err)
+	r, ok := request.(*s.RespondDecisionTaskFailedRequest)
+	t.True(ok)
+	t.EqualValues("WORKFLOW_WORKER_UNHANDLED_FAILURE", r.Cause.String())
+	t.Contains(string(r.Details), "potential deadlock detected")
+}
+
 func (t *TaskHandlersTestSuite) TestGetWorkflowInfo() {
 	taskList := "taskList"
 	parentID := "parentID"
 	parentRunID := "parentRun"
 	cronSchedule := "5 4 * * *"
 	continuedRunID := uuid.New()
+	firstRunID := uuid.New()
+	initiator := s.ContinueAsNewInitiatorRetryPolicy
+	expiration := time.Unix(1500, 0)
 	parentExecution := &s.WorkflowExecution{
 		WorkflowId: &parentID,
 		RunId:      &parentRunID,
@@ -864,6 +1020,9 @@ func (t *TaskHandlersTestSuite) TestGetWorkflowInfo() {
 		ExecutionStartToCloseTimeoutSeconds: &executionTimeout,
 		TaskStartToCloseTimeoutSeconds:      &taskTimeout,
 		LastCompletionResult:                lastCompletionResult,
+		FirstExecutionRunId:                 &firstRunID,
+		Initiator:                           &initiator,
+		ExpirationTimestamp:                 common.Int64Ptr(expiration.UnixNano()),
 	}
 	testEvents := []*s.HistoryEvent{
 		createTestEventWorkflowExecutionStarted(1, startedEventAttributes),
@@ -899,6 +1058,42 @@ func (t *TaskHandlersTestSuite) TestGetWorkflowInfo() {
 	t.EqualValues(taskTimeout, result.TaskStartToCloseTimeoutSeconds)
 	t.EqualValues(workflowType, result.WorkflowType.Name)
 	t.EqualValues(testDomain, result.Domain)
+	t.EqualValues(firstRunID, *result.FirstRunID)
+	t.EqualValues(initiator, *result.Initiator)
+	t.True(expiration.Equal(result.ExpirationTime))
+	t.EqualValues(3, result.HistoryLength)
+	t.True(result.HistorySizeBytes > 0)
+}
+
+func (t *TaskHandlersTestSuite) TestShouldContinueAsNew() {
+	taskList := "taskList"
+	input, err := getDefaultDataConverter().ToData(int64(3))
+	t.NoError(err)
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{
+			TaskList: &s.TaskList{Name: &taskList},
+			Input:    input,
+		}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	task := createWorkflowTask(testEvents, 3, "ShouldContinueAsNewWorkflow")
+	params := workerExecutionParameters{
+		TaskList: taskList,
+		Identity: "test-id-1",
+		Logger:   t.logger,
+	}
+
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+	request, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task}, nil)
+	t.NoError(err)
+	r, ok := request.(*s.RespondDecisionTaskCompletedRequest)
+	t.True(ok)
+	t.EqualValues(s.DecisionTypeCompleteWorkflowExecution, r.Decisions[0].GetDecisionType())
+	var result []bool
+	t.NoError(getDefaultDataConverter().FromData(r.Decisions[0].CompleteWorkflowExecutionDecisionAttributes.Result, &result))
+	// the default thresholds are far away, the one set on the context is reached
+	t.Equal([]bool{false, true}, result)
 }
 
 func (t *TaskHandlersTestSuite) TestConsistentQuery_InvalidQueryTask() {
@@ -1055,7 +1250,7 @@ func (t *TaskHandlersTestSuite) TestWorkflowTask_PageToken() {
 
 	historyIterator := &historyIteratorImpl{
 		iteratorFunc: func(nextToken []byte) (*s.History, []byte, error) {
-			return &s.History{nextEvents}, nil, nil
+			return &s.History{Events: nextEvents}, nil, nil
 		},
 	}
 	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
@@ -1359,6 +1554,116 @@ func (t *TaskHandlersTestSuite) TestActivityExecutionWorkerStop() {
 	t.NotNil(r)
 }
 
+func (t *TaskHandlersTestSuite) TestActivityExecutionFaultInjected() {
+	hostEnv := getHostEnvironment()
+	hostEnv.addActivityFn("faultInjectedActivity", func(ctx context.Context) error { return nil })
+
+	mockCtrl := gomock.NewController(t.T())
+	mockService := workflowservicetest.NewMockClient(mockCtrl)
+	wep := workerExecutionParameters{
+		Logger:        t.logger,
+		MetricsScope:  tally.NoopScope,
+		DataConverter: getDefaultDataConverter(),
+		Tracer:        opentracing.NoopTracer{},
+		FaultInjectionRules: []FaultInjectionRule{
+			{Type: FaultTypeActivityPanic, WorkflowType: "wType", ActivityType: "faultInjectedActivity", Probability: 1},
+		},
+	}
+	activityHandler := newActivityTaskHandler(mockService, wep, hostEnv)
+	newTask := func(workflowType string) *s.PollForActivityTaskResponse {
+		return &s.PollForActivityTaskResponse{
+			TaskToken: []byte("token"),
+			WorkflowExecution: &s.WorkflowExecution{
+				WorkflowId: common.StringPtr("wID"),
+				RunId:      common.StringPtr("rID")},
+			ActivityType:                  &s.ActivityType{Name: common.StringPtr("faultInjectedActivity")},
+			ActivityId:                    common.StringPtr(uuid.New()),
+			ScheduledTimestamp:            common.Int64Ptr(time.Now().UnixNano()),
+			ScheduleToCloseTimeoutSeconds: common.Int32Ptr(1),
+			StartedTimestamp:              common.Int64Ptr(time.Now().UnixNano()),
+			StartToCloseTimeoutSeconds:    common.Int32Ptr(1),
+			WorkflowType: &s.WorkflowType{
+				Name: common.StringPtr(workflowType),
+			},
+			WorkflowDomain: common.StringPtr("domain"),
+		}
+	}
+
+	// the rule is scoped to another workflow type
+	r, err := activityHandler.Execute(tasklist, newTask("otherWorkflowType"))
+	t.NoError(err)
+	t.IsType(&s.RespondActivityTaskCompletedRequest{}, r)
+
+	r, err = activityHandler.Execute(tasklist, newTask("wType"))
+	t.NoError(err)
+	failed, ok := r.(*s.RespondActivityTaskFailedRequest)
+	t.True(ok)
+	t.Equal(errReasonPanic, failed.GetReason())
+}
+
+func Test_FaultInjectionRuleScope(t *testing.T) {
+	injector := newFaultInjector(workerExecutionParameters{
+		Logger:       zap.NewNop(),
+		MetricsScope: tally.NoopScope,
+		FaultInjectionRules: []FaultInjectionRule{
+			{Type: FaultTypeDropResponse, TaskList: "tl1", Probability: 1},
+			{Type: FaultTypeFailDecisionTask, WorkflowType: "wf1", Probability: 1},
+		},
+	})
+
+	require.True(t, injector.inject(FaultTypeDropResponse, faultScope{workflowType: "wf1", taskList: "tl1"}))
+	require.False(t, injector.inject(FaultTypeDropResponse, faultScope{workflowType: "wf1", taskList: "tl2"}))
+	require.True(t, injector.inject(FaultTypeFailDecisionTask, faultScope{workflowType: "wf1", taskList: "tl2"}))
+	require.False(t, injector.inject(FaultTypeFailDecisionTask, faultScope{workflowType: "wf2", taskList: "tl1"}))
+	require.False(t, injector.inject(FaultTypeActivityPanic, faultScope{workflowType: "wf1", activityType: "act1", taskList: "tl1"}))
+
+	// a fault injector without rules never injects
+	var noop *faultInjector
+	require.Nil(t, newFaultInjector(workerExecutionParameters{}))
+	require.False(t, noop.inject(FaultTypeDropResponse, faultScope{}))
+	noop.delay(faultScope{}, nil)
+}
+
+func Test_ValidateFaultInjectionRules(t *testing.T) {
+	require.NoError(t, validateFaultInjectionRules(nil))
+	require.NoError(t, validateFaultInjectionRules([]FaultInjectionRule{{Type: FaultTypeDelay, Probability: 0.5}}))
+	// the zero value rule is rejected instead of delaying every task
+	require.Error(t, validateFaultInjectionRules([]FaultInjectionRule{{}}))
+	require.Error(t, validateFaultInjectionRules([]FaultInjectionRule{{Type: FaultTypeDelay}}))
+	require.Error(t, validateFaultInjectionRules([]FaultInjectionRule{{Type: FaultTypeDelay, Probability: 1.5}}))
+	require.Error(t, validateFaultInjectionRules([]FaultInjectionRule{{Type: FaultType(42), Probability: 1}}))
+}
+
+func (t *TaskHandlersTestSuite) TestWorkflowTaskFaultInjected() {
+	taskList := "tl1"
+	testEvents := []*s.HistoryEvent{
+		createTestEventWorkflowExecutionStarted(1, &s.WorkflowExecutionStartedEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskScheduled(2, &s.DecisionTaskScheduledEventAttributes{TaskList: &s.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+	stickyCache := NewStickyWorkflowCache(StickyWorkflowCacheOptions{})
+	mockCtrl := gomock.NewController(t.T())
+	mockService := workflowservicetest.NewMockClient(mockCtrl)
+	params := workerExecutionParameters{
+		TaskList:            taskList,
+		Identity:            "test-id-1",
+		Logger:              t.logger,
+		MetricsScope:        tally.NoopScope,
+		StickyWorkflowCache: stickyCache,
+		FaultInjectionRules: []FaultInjectionRule{
+			{Type: FaultTypeFailDecisionTask, WorkflowType: "HelloWorld_Workflow", Probability: 1},
+		},
+	}
+	taskHandler := newWorkflowTaskHandler(testDomain, params, nil, getHostEnvironment())
+	poller := newWorkflowTaskPoller(taskHandler, mockService, testDomain, params)
+
+	mockService.EXPECT().RespondDecisionTaskFailed(gomock.Any(), gomock.Any(), callOptions...).Return(nil).Times(1)
+	task := createWorkflowTask(testEvents, 0, "HelloWorld_Workflow")
+	t.NoError(poller.ProcessTask(&workflowTask{task: task}))
+	// the decision was never processed, so nothing the server did not accept is cached
+	t.Equal(0, stickyCache.Size())
+}
+
 func Test_NonDeterministicCheck(t *testing.T) {
 	decisionTypes := s.DecisionType_Values()
 	require.Equal(t, 13, len(decisionTypes), "If you see this error, you are adding new decision type. "+
@@ -74,6 +74,8 @@ type (
 		metricsScope tally.Scope
 		logger       *zap.Logger
 
+		faultInjector *faultInjector
+
 		stickyUUID                   string
 		disableStickyExecution       bool
 		StickyScheduleToStartTimeout time.Duration
@@ -95,6 +97,7 @@ type (
 		metricsScope        *metrics.TaggedScope
 		logger              *zap.Logger
 		activitiesPerSecond float64
+		faultInjector       *faultInjector
 	}
 
 	historyIteratorImpl struct {
@@ -229,6 +232,7 @@ func newWorkflowTaskPoller(
 		stickyUUID:                   uuid.New(),
 		disableStickyExecution:       params.DisableStickyExecution,
 		StickyScheduleToStartTimeout: params.StickyScheduleToStartTimeout,
+		faultInjector:                newFaultInjector(params),
 	}
 }
 
@@ -275,7 +279,21 @@ func (wtp *workflowTaskPoller) processWorkflowTask(task *workflowTask) error {
 
 	for {
 		var response *s.RespondDecisionTaskCompletedResponse
+		isQuery := task.task.Query != nil
+		scope := faultScope{workflowType: task.task.WorkflowType.GetName(), taskList: wtp.taskListName}
 		startTime := time.Now()
+		if !isQuery {
+			wtp.faultInjector.delay(scope, wtp.shutdownC)
+			// faults which keep the decision from the server are injected before the task is processed, so that the
+			// cached workflow state never gets ahead of what the server accepted.
+			if wtp.faultInjector.inject(FaultTypeDropResponse, scope) {
+				return nil
+			}
+			if wtp.faultInjector.inject(FaultTypeFailDecisionTask, scope) {
+				_, err := wtp.RespondTaskCompletedWithMetrics(nil, errFaultInjected, task.task, startTime)
+				return err
+			}
+		}
 		task.doneCh = doneCh
 		task.laResultCh = laResultCh
 		completedRequest, err := wtp.taskHandler.ProcessWorkflowTask(
@@ -319,7 +337,6 @@ func (wtp *workflowTaskPoller) processWorkflowTask(task *workflowTask) error {
 func (wtp *workflowTaskPoller) processResetStickinessTask(rst *resetStickinessTask) error {
 	tchCtx, cancel, opt := newChannelContext(context.Background())
 	defer cancel()
-	wtp.metricsScope.Counter(metrics.StickyCacheEvict).Inc(1)
 	if _, err := wtp.service.ResetStickyTaskList(tchCtx, rst.task, opt...); err != nil {
 		wtp.logger.Warn("ResetStickyTaskList failed",
 			zap.String(tagWorkflowID, rst.task.Execution.GetWorkflowId()),
@@ -467,6 +484,8 @@ func (lath *localActivityTaskHandler) executeLocalActivityTask(task *localActivi
 	}
 
 	workflowTypeLocal := task.params.WorkflowInfo.WorkflowType
+	// details recorded by a previous attempt let a retried local activity resume from its progress
+	heartbeatDetails, _ := task.getHeartbeat()
 
 	ctx := context.WithValue(rootCtx, activityEnvContextKey, &activityEnvironment{
 		workflowType:      &workflowTypeLocal,
@@ -478,8 +497,10 @@ func (lath *localActivityTaskHandler) executeLocalActivityTask(task *localActivi
 		logger:            lath.logger,
 		metricsScope:      metricsScope,
 		isLocalActivity:   true,
-		dataConverter:     lath.dataConverter,
+		dataConverter:     dataConverterForWorkflow(lath.dataConverter, task.params.WorkflowInfo.WorkflowExecution.ID),
 		attempt:           task.attempt,
+		heartbeatDetails:  heartbeatDetails,
+		localActivityTask: task,
 	})
 
 	// panic handler
@@ -517,6 +538,7 @@ func (lath *localActivityTaskHandler) executeLocalActivityTask(task *localActivi
 	task.Lock()
 	if task.canceled {
 		task.Unlock()
+		cancel()
 		return &localActivityResult{err: ErrCanceled, task: task}
 	}
 	task.cancelFunc = cancel
@@ -797,6 +819,7 @@ func newActivityTaskPoller(taskHandler ActivityTaskHandler, service workflowserv
 		logger:              params.Logger,
 		metricsScope:        metrics.NewTaggedScope(params.MetricsScope),
 		activitiesPerSecond: params.TaskListActivitiesPerSecond,
+		faultInjector:       newFaultInjector(params),
 	}
 }
 
@@ -867,6 +890,8 @@ func (atp *activityTaskPoller) ProcessTask(task interface{}) error {
 	workflowType := activityTask.task.WorkflowType.GetName()
 	activityType := activityTask.task.ActivityType.GetName()
 	metricsScope := getMetricsScopeForActivity(atp.metricsScope, workflowType, activityType)
+	scope := faultScope{workflowType: workflowType, activityType: activityType, taskList: atp.taskListName}
+	atp.faultInjector.delay(scope, atp.shutdownC)
 
 	executionStartTime := time.Now()
 	// Process the activity task.
@@ -881,6 +906,10 @@ func (atp *activityTaskPoller) ProcessTask(task interface{}) error {
 		return nil
 	}
 
+	if atp.faultInjector.inject(FaultTypeDropResponse, scope) {
+		return nil
+	}
+
 	// if worker is shutting down, don't bother reporting activity completion
 	if atp.shuttingDown() {
 		return errShutdown
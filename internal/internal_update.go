@@ -0,0 +1,300 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+// All code in this file is private to the package.
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	// updateSignalName is the signal that delivers an update request to the workflow.
+	updateSignalName = "__update"
+	// updateValidateQueryType is the query that runs the update validator without writing any history.
+	updateValidateQueryType = "__update_validate"
+	// updateResultQueryType is the query that returns the outcome of an update.
+	updateResultQueryType = "__update_result"
+
+	updatePollInitialInterval = 100 * time.Millisecond
+	updatePollMaxInterval     = 2 * time.Second
+	// updateDefaultTimeout limits how long Client.UpdateWorkflow waits for the outcome when ctx has no deadline.
+	updateDefaultTimeout = 10 * time.Minute
+
+	// maxRetainedUpdateOutcomes is the number of outcomes of completed updates the workflow keeps for the result
+	// query, older ones are evicted in the order the updates completed.
+	maxRetainedUpdateOutcomes = 1000
+	// maxRetainedEvictedUpdateIDs is the number of IDs of evicted updates the workflow keeps, so the result query
+	// tells them apart from the updates which are not delivered yet.
+	maxRetainedEvictedUpdateIDs = 10 * maxRetainedUpdateOutcomes
+)
+
+type (
+	// updateRequest is sent by Client.UpdateWorkflow, the ID correlates the signal with the result query.
+	updateRequest struct {
+		ID   string
+		Name string
+		Args []byte
+	}
+
+	// updateOutcome is returned by the update queries. An update that is not delivered yet, or accepted but not
+	// completed yet, has all fields empty.
+	updateOutcome struct {
+		Completed  bool
+		Evicted    bool // the update completed but its outcome is not retained anymore
+		Rejection  string // validator error message if the update was rejected
+		Result     []byte
+		ErrReason  string
+		ErrDetails []byte
+	}
+
+	updateHandler struct {
+		fn            interface{}
+		validator     interface{}
+		name          string
+		dataConverter DataConverter
+	}
+
+	// updateState is the per workflow execution state of the update handlers.
+	updateState struct {
+		handlers  map[string]*updateHandler
+		outcomes  map[string]*updateOutcome // by update ID
+		completed []string                  // IDs of the completed updates in the order they completed
+		evicted   map[string]struct{}       // IDs of the updates whose outcome was evicted
+		evictedQ  []string                  // IDs of the evicted updates in the order they were evicted
+		inFlight  int                       // number of running update handlers
+	}
+)
+
+// setUpdateHandler sets update handler for given updateName. The signal and query handlers backing the updates are
+// registered with the first update handler.
+func setUpdateHandler(ctx Context, updateName string, handler interface{}, options UpdateHandlerOptions) error {
+	h := &updateHandler{
+		fn:            handler,
+		validator:     options.Validator,
+		name:          updateName,
+		dataConverter: getDataConverterFromWorkflowContext(ctx),
+	}
+	if err := h.validateHandlerFn(); err != nil {
+		return err
+	}
+
+	eo := getWorkflowEnvOptions(ctx)
+	if eo.updates == nil {
+		st := &updateState{
+			handlers: make(map[string]*updateHandler),
+			outcomes: make(map[string]*updateOutcome),
+			evicted:  make(map[string]struct{}),
+		}
+		if err := setQueryHandler(ctx, updateValidateQueryType, st.validate); err != nil {
+			return err
+		}
+		if err := setQueryHandler(ctx, updateResultQueryType, st.result); err != nil {
+			return err
+		}
+		if err := setSignalHandler(ctx, updateSignalName, st.accept); err != nil {
+			return err
+		}
+		eo.updates = st
+	}
+	eo.updates.handlers[updateName] = h
+	return nil
+}
+
+// validate is the handler of updateValidateQueryType.
+func (st *updateState) validate(request updateRequest) (*updateOutcome, error) {
+	if outcome, ok := st.lookup(request.ID); ok {
+		return outcome, nil
+	}
+	if rejection := st.rejection(request); rejection != "" {
+		return &updateOutcome{Completed: true, Rejection: rejection}, nil
+	}
+	return &updateOutcome{}, nil
+}
+
+// result is the handler of updateResultQueryType.
+func (st *updateState) result(updateID string) (*updateOutcome, error) {
+	if outcome, ok := st.lookup(updateID); ok {
+		return outcome, nil
+	}
+	return &updateOutcome{}, nil
+}
+
+// lookup returns the outcome of an update delivered to the workflow.
+func (st *updateState) lookup(updateID string) (*updateOutcome, bool) {
+	if outcome, ok := st.outcomes[updateID]; ok {
+		return outcome, true
+	}
+	if _, ok := st.evicted[updateID]; ok {
+		return &updateOutcome{Evicted: true}, true
+	}
+	return nil, false
+}
+
+// accept is the handler of updateSignalName, it validates the update again as the workflow state could have changed
+// since the validation query and runs the update handler in its own coroutine.
+func (st *updateState) accept(ctx Context, request updateRequest) {
+	if _, ok := st.lookup(request.ID); ok {
+		// duplicated request
+		return
+	}
+	outcome := &updateOutcome{}
+	st.outcomes[request.ID] = outcome
+	if outcome.Rejection = st.rejection(request); outcome.Rejection != "" {
+		st.complete(request.ID, outcome)
+		return
+	}
+
+	h := st.handlers[request.Name]
+	st.inFlight++
+	GoNamed(ctx, "update-"+request.Name, func(ctx Context) {
+		defer func() { st.inFlight-- }()
+		result, err := h.execute(ctx, request.Args)
+		if err != nil {
+			outcome.ErrReason, outcome.ErrDetails = getErrorDetails(err, h.dataConverter)
+		} else {
+			outcome.Result = result
+		}
+		st.complete(request.ID, outcome)
+	})
+}
+
+// complete marks the outcome as completed and evicts the oldest completed outcomes beyond
+// maxRetainedUpdateOutcomes. Eviction only depends on the order the updates complete in, so it is deterministic.
+// The IDs of the evicted updates are kept longer, duplicates of an update are not detected anymore once its ID is
+// evicted too.
+func (st *updateState) complete(updateID string, outcome *updateOutcome) {
+	outcome.Completed = true
+	st.completed = append(st.completed, updateID)
+	if len(st.completed) > maxRetainedUpdateOutcomes {
+		evictedID := st.completed[0]
+		delete(st.outcomes, evictedID)
+		st.completed = st.completed[1:]
+		st.evicted[evictedID] = struct{}{}
+		st.evictedQ = append(st.evictedQ, evictedID)
+	}
+	if len(st.evictedQ) > maxRetainedEvictedUpdateIDs {
+		delete(st.evicted, st.evictedQ[0])
+		st.evictedQ = st.evictedQ[1:]
+	}
+}
+
+// rejection returns the reason the update is rejected for, or empty string if it is accepted.
+func (st *updateState) rejection(request updateRequest) string {
+	h, ok := st.handlers[request.Name]
+	if !ok {
+		return fmt.Sprintf("unknown update %v", request.Name)
+	}
+	if err := h.validate(request.Args); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func (h *updateHandler) validateHandlerFn() error {
+	fnType := reflect.TypeOf(h.fn)
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return fmt.Errorf("update handler must be function but was %v", fnType)
+	}
+	if fnType.NumIn() < 1 || !isWorkflowContext(fnType.In(0)) {
+		return errors.New("first input parameter of update handler must be workflow.Context")
+	}
+	if fnType.NumOut() < 1 || fnType.NumOut() > 2 {
+		return fmt.Errorf(
+			"update handler must return either error or serializable result and error, but found %d return values", fnType.NumOut(),
+		)
+	}
+	if fnType.NumOut() == 2 && !isValidResultType(fnType.Out(0)) {
+		return fmt.Errorf(
+			"first return value of update handler must be serializable but found: %v", fnType.Out(0).Kind(),
+		)
+	}
+	if !isError(fnType.Out(fnType.NumOut() - 1)) {
+		return fmt.Errorf(
+			"last return value of update handler must be error but found %v", fnType.Out(fnType.NumOut()-1).Kind(),
+		)
+	}
+
+	if h.validator == nil {
+		return nil
+	}
+	validatorType := reflect.TypeOf(h.validator)
+	if validatorType.Kind() != reflect.Func {
+		return fmt.Errorf("update validator must be function but was %s", validatorType.Kind())
+	}
+	if validatorType.NumOut() != 1 || !isError(validatorType.Out(0)) {
+		return errors.New("update validator must return exactly one value of type error")
+	}
+	if validatorType.NumIn() != fnType.NumIn()-1 {
+		return fmt.Errorf("update validator must take the same %d parameters as the update handler without workflow.Context, but found %d",
+			fnType.NumIn()-1, validatorType.NumIn())
+	}
+	for i := 0; i < validatorType.NumIn(); i++ {
+		if validatorType.In(i) != fnType.In(i+1) {
+			return fmt.Errorf("parameter %d of update validator is %v, update handler expects %v", i, validatorType.In(i), fnType.In(i+1))
+		}
+	}
+	return nil
+}
+
+func (h *updateHandler) validate(input []byte) (err error) {
+	if h.validator == nil {
+		return nil
+	}
+	// a panicking validator rejects the update instead of failing the query or the decision task
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("update validator panic: %v", p)
+		}
+	}()
+	args, err := decodeArgs(h.dataConverter, reflect.TypeOf(h.validator), input)
+	if err != nil {
+		return fmt.Errorf("unable to decode the input for update: %v, with error: %v", h.name, err)
+	}
+	retValues := reflect.ValueOf(h.validator).Call(args)
+	if errValue := retValues[0].Interface(); errValue != nil {
+		return errValue.(error)
+	}
+	return nil
+}
+
+func (h *updateHandler) execute(ctx Context, input []byte) ([]byte, error) {
+	fnType := reflect.TypeOf(h.fn)
+	args, err := decodeArgs(h.dataConverter, fnType, input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the input for update: %v, with error: %v", h.name, err)
+	}
+	retValues := reflect.ValueOf(h.fn).Call(append([]reflect.Value{reflect.ValueOf(ctx)}, args...))
+	if errValue := retValues[len(retValues)-1].Interface(); errValue != nil {
+		return nil, errValue.(error)
+	}
+	if len(retValues) == 1 {
+		return nil, nil
+	}
+	retValue := retValues[0]
+	if retValue.Kind() == reflect.Ptr && retValue.IsNil() {
+		return nil, nil
+	}
+	return encodeArg(h.dataConverter, retValue.Interface())
+}
@@ -24,6 +24,7 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
@@ -254,6 +255,47 @@ func constructError(reason string, details []byte, dataConverter DataConverter)
 	}
 }
 
+// queryCustomErrorSeparator separates the human readable error message of a failed query that carries a CustomError
+// from the encoded reason and details of the error. Only the message of a query failure reaches the client, so they
+// are appended to it, after the message people read in the UI and the logs.
+const queryCustomErrorSeparator = "\ncadenceInternal:CustomError "
+
+type queryCustomError struct {
+	Reason  string
+	Details []byte
+}
+
+// getQueryErrorMessage gets the message sent to the server for an error returned by a query handler.
+func getQueryErrorMessage(err error, dataConverter DataConverter) string {
+	if _, ok := err.(*CustomError); !ok {
+		return err.Error()
+	}
+	reason, details := getErrorDetails(err, dataConverter)
+	data, err0 := json.Marshal(queryCustomError{Reason: reason, Details: details})
+	if err0 != nil {
+		return err.Error()
+	}
+	return err.Error() + queryCustomErrorSeparator + string(data)
+}
+
+// constructQueryError turns the QueryFailedError of a query that failed with a CustomError back into a CustomError,
+// other errors are returned as they are.
+func constructQueryError(err error, dataConverter DataConverter) error {
+	queryErr, ok := err.(*s.QueryFailedError)
+	if !ok {
+		return err
+	}
+	i := strings.LastIndex(queryErr.Message, queryCustomErrorSeparator)
+	if i < 0 {
+		return err
+	}
+	var customErr queryCustomError
+	if json.Unmarshal([]byte(queryErr.Message[i+len(queryCustomErrorSeparator):]), &customErr) != nil {
+		return err
+	}
+	return NewCustomError(customErr.Reason, newEncodedValues(customErr.Details, dataConverter))
+}
+
 // AwaitWaitGroup calls Wait on the given wait
 // Returns true if the Wait() call succeeded before the timeout
 // Returns false if the Wait() did not return before the timeout
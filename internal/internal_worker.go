@@ -94,7 +94,6 @@ type (
 	}
 
 	// ActivityWorker wraps the code for hosting activity types.
-	// TODO: Worker doing heartbeating automatically while activity task is running
 	activityWorker struct {
 		executionParameters workerExecutionParameters
 		workflowService     workflowserviceclient.Interface
@@ -173,6 +172,12 @@ type (
 
 		StickyScheduleToStartTimeout time.Duration
 
+		// StickyWorkflowCache keeps sticky workflow executions, nil means the process wide cache.
+		StickyWorkflowCache *StickyWorkflowCache
+
+		// DeadlockDetectionTimeout is the max time a workflow coroutine can run without yielding.
+		DeadlockDetectionTimeout time.Duration
+
 		// NonDeterministicWorkflowPolicy is used for configuring how client's decision task handler deals with
 		// mismatched history events (presumably arising from non-deterministic workflow definitions).
 		NonDeterministicWorkflowPolicy NonDeterministicWorkflowPolicy
@@ -191,6 +196,17 @@ type (
 		ContextPropagators []ContextPropagator
 
 		Tracer opentracing.Tracer
+
+		// FaultInjectionRules are the faults injected into the tasks processed by the worker.
+		FaultInjectionRules []FaultInjectionRule
+
+		// PollBackoffPolicy, PollErrorClassifier and PollBackoffJitter configure the backoff of the pollers after failed polls.
+		PollBackoffPolicy   PollBackoffPolicy
+		PollErrorClassifier PollErrorClassifier
+		PollBackoffJitter   float64
+
+		// SearchAttributesSchema validates search attribute upserts, nil means no validation.
+		SearchAttributesSchema *searchAttributesSchema
 	}
 )
 
@@ -297,14 +313,17 @@ func newWorkflowTaskWorkerInternal(
 		params,
 	)
 	worker := newBaseWorker(baseWorkerOptions{
-		pollerCount:       params.MaxConcurrentDecisionPollers,
-		pollerRate:        defaultPollerRate,
-		maxConcurrentTask: params.ConcurrentDecisionTaskExecutionSize,
-		maxTaskPerSecond:  params.WorkerDecisionTasksPerSecond,
-		taskWorker:        poller,
-		identity:          params.Identity,
-		workerType:        "DecisionWorker",
-		shutdownTimeout:   params.WorkerStopTimeout},
+		pollerCount:         params.MaxConcurrentDecisionPollers,
+		pollerRate:          defaultPollerRate,
+		maxConcurrentTask:   params.ConcurrentDecisionTaskExecutionSize,
+		maxTaskPerSecond:    params.WorkerDecisionTasksPerSecond,
+		taskWorker:          poller,
+		identity:            params.Identity,
+		workerType:          "DecisionWorker",
+		shutdownTimeout:     params.WorkerStopTimeout,
+		pollBackoffPolicy:   params.PollBackoffPolicy,
+		pollErrorClassifier: params.PollErrorClassifier,
+		pollBackoffJitter:   params.PollBackoffJitter},
 		params.Logger,
 		params.MetricsScope,
 		nil,
@@ -476,15 +495,18 @@ func newActivityTaskWorker(
 
 	base := newBaseWorker(
 		baseWorkerOptions{
-			pollerCount:       workerParams.MaxConcurrentActivityPollers,
-			pollerRate:        defaultPollerRate,
-			maxConcurrentTask: workerParams.ConcurrentActivityExecutionSize,
-			maxTaskPerSecond:  workerParams.WorkerActivitiesPerSecond,
-			taskWorker:        poller,
-			identity:          workerParams.Identity,
-			workerType:        "ActivityWorker",
-			shutdownTimeout:   workerParams.WorkerStopTimeout,
-			userContextCancel: workerParams.UserContextCancel},
+			pollerCount:         workerParams.MaxConcurrentActivityPollers,
+			pollerRate:          defaultPollerRate,
+			maxConcurrentTask:   workerParams.ConcurrentActivityExecutionSize,
+			maxTaskPerSecond:    workerParams.WorkerActivitiesPerSecond,
+			taskWorker:          poller,
+			identity:            workerParams.Identity,
+			workerType:          "ActivityWorker",
+			shutdownTimeout:     workerParams.WorkerStopTimeout,
+			userContextCancel:   workerParams.UserContextCancel,
+			pollBackoffPolicy:   workerParams.PollBackoffPolicy,
+			pollErrorClassifier: workerParams.PollErrorClassifier,
+			pollBackoffJitter:   workerParams.PollBackoffJitter},
 		workerParams.Logger,
 		workerParams.MetricsScope,
 		sessionTokenBucket,
@@ -589,7 +611,7 @@ func (th *hostEnvImpl) RegisterActivityWithOptions(
 	if _, ok := th.getActivityFn(registerName); ok {
 		return fmt.Errorf("activity type \"%v\" is already registered", registerName)
 	}
-	th.addActivityFn(registerName, af)
+	th.addActivity(registerName, &activityExecutor{name: registerName, fn: af, options: options})
 	if len(alias) > 0 {
 		th.addActivityAlias(fnName, alias)
 	}
@@ -652,7 +674,7 @@ func (th *hostEnvImpl) addActivity(fnName string, a activity) {
 }
 
 func (th *hostEnvImpl) addActivityFn(fnName string, af interface{}) {
-	th.addActivity(fnName, &activityExecutor{fnName, af})
+	th.addActivity(fnName, &activityExecutor{name: fnName, fn: af})
 }
 
 func (th *hostEnvImpl) getActivity(fnName string) (activity, bool) {
@@ -898,8 +920,9 @@ func (we *workflowExecutor) Execute(ctx Context, input []byte) ([]byte, error) {
 
 // Wrapper to execute activity functions.
 type activityExecutor struct {
-	name string
-	fn   interface{}
+	name    string
+	fn      interface{}
+	options RegisterActivityOptions
 }
 
 func (ae *activityExecutor) ActivityType() ActivityType {
@@ -986,6 +1009,10 @@ type aggregatedWorker struct {
 	sessionWorker  Worker
 	logger         *zap.Logger
 	hostEnv        *hostEnvImpl
+	faultRules     []FaultInjectionRule
+
+	service                workflowserviceclient.Interface
+	searchAttributesSchema *searchAttributesSchema // nil when the upserts are not validated
 }
 
 func (aw *aggregatedWorker) Start() error {
@@ -993,12 +1020,21 @@ func (aw *aggregatedWorker) Start() error {
 		return fmt.Errorf("failed to get executable checksum: %v", err)
 	}
 
+	if err := validateFaultInjectionRules(aw.faultRules); err != nil {
+		return err
+	}
+
 	if !isInterfaceNil(aw.workflowWorker) {
 		if len(aw.hostEnv.getRegisteredWorkflowTypes()) == 0 {
 			aw.logger.Warn(
 				"Starting worker without any workflows. Workflows must be registered before start.",
 			)
 		}
+		if aw.searchAttributesSchema != nil {
+			if err := aw.searchAttributesSchema.load(aw.service); err != nil {
+				return fmt.Errorf("failed to load search attributes to validate upserts: %v", err)
+			}
+		}
 		if err := aw.workflowWorker.Start(); err != nil {
 			return err
 		}
@@ -1152,12 +1188,21 @@ func newAggregatedWorker(
 		UserContextCancel:                    backgroundActivityContextCancel,
 		DisableStickyExecution:               wOptions.DisableStickyExecution,
 		StickyScheduleToStartTimeout:         wOptions.StickyScheduleToStartTimeout,
+		StickyWorkflowCache:                  wOptions.StickyWorkflowCache,
+		DeadlockDetectionTimeout:             wOptions.DeadlockDetectionTimeout,
 		TaskListActivitiesPerSecond:          wOptions.TaskListActivitiesPerSecond,
 		NonDeterministicWorkflowPolicy:       wOptions.NonDeterministicWorkflowPolicy,
 		DataConverter:                        wOptions.DataConverter,
 		WorkerStopTimeout:                    wOptions.WorkerStopTimeout,
 		ContextPropagators:                   wOptions.ContextPropagators,
 		Tracer:                               wOptions.Tracer,
+		FaultInjectionRules:                  wOptions.FaultInjectionRules,
+		PollBackoffPolicy:                    wOptions.PollBackoffPolicy,
+		PollErrorClassifier:                  wOptions.PollErrorClassifier,
+		PollBackoffJitter:                    wOptions.PollBackoffJitter,
+	}
+	if wOptions.ValidateSearchAttributes {
+		workerParams.SearchAttributesSchema = &searchAttributesSchema{}
 	}
 
 	ensureRequiredParams(&workerParams)
@@ -1228,6 +1273,10 @@ func newAggregatedWorker(
 		sessionWorker:  sessionWorker,
 		logger:         logger,
 		hostEnv:        hostEnv,
+		faultRules:     wOptions.FaultInjectionRules,
+
+		service:                service,
+		searchAttributesSchema: workerParams.SearchAttributesSchema,
 	}
 }
 
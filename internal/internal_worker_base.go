@@ -41,10 +41,7 @@ import (
 const (
 	retryPollOperationInitialInterval = 20 * time.Millisecond
 	retryPollOperationMaxInterval     = 10 * time.Second
-)
-
-var (
-	pollOperationRetryPolicy = createPollRetryPolicy()
+	defaultPollBackoffJitter          = 0.2
 )
 
 var errShutdown = errors.New("worker shutting down")
@@ -59,6 +56,8 @@ type (
 		result  []byte
 		attempt int32
 		backoff time.Duration
+		// heartbeatDetails are the last details recorded by the local activity, handed to the next attempt
+		heartbeatDetails []byte
 	}
 
 	// workflowEnvironment Represents the environment for workflow/decider.
@@ -87,6 +86,7 @@ type (
 		RemoveSession(sessionID string)
 		GetContextPropagators() []ContextPropagator
 		UpsertSearchAttributes(attributes map[string]interface{}) error
+		GetDeadlockDetectionTimeout() time.Duration
 	}
 
 	// WorkflowDefinition wraps the code that can execute a workflow.
@@ -95,7 +95,8 @@ type (
 		// Called for each non timed out startDecision event.
 		// Executed after all history events since the previous decision are applied to workflowDefinition
 		OnDecisionTaskStarted()
-		StackTrace() string // Stack trace of all coroutines owned by the Dispatcher instance
+		StackTrace() string  // Stack trace of all coroutines owned by the Dispatcher instance
+		CoroutineCount() int // Number of coroutines which are still alive
 		Close()
 	}
 
@@ -110,6 +111,10 @@ type (
 		workerType        string
 		shutdownTimeout   time.Duration
 		userContextCancel context.CancelFunc
+
+		pollBackoffPolicy   PollBackoffPolicy
+		pollErrorClassifier PollErrorClassifier
+		pollBackoffJitter   float64
 	}
 
 	// baseWorker that wraps worker activities.
@@ -136,16 +141,26 @@ type (
 	}
 )
 
-func createPollRetryPolicy() backoff.RetryPolicy {
-	policy := backoff.NewExponentialRetryPolicy(retryPollOperationInitialInterval)
-	policy.SetMaximumInterval(retryPollOperationMaxInterval)
+// newPollRetrier creates the retrier shared by all pollers of a worker, so the backoff grows with every failed poll
+// until a poll succeeds again.
+func newPollRetrier(options baseWorkerOptions) *backoff.ConcurrentRetrier {
+	policy := options.pollBackoffPolicy
+	if policy == nil {
+		policy = NewExponentialPollBackoffPolicy(retryPollOperationInitialInterval, retryPollOperationMaxInterval)
+	}
+	isFailure := options.pollErrorClassifier
+	if isFailure == nil {
+		isFailure = isServiceTransientError
+	}
+	jitter := options.pollBackoffJitter
+	if jitter == 0 {
+		jitter = defaultPollBackoffJitter
+	}
 
-	// NOTE: We don't use expiration interval since we don't use retries from retrier class.
-	// We use it to calculate next backoff. We have additional layer that is built on poller
-	// in the worker layer for to add some middleware for any poll retry that includes
-	// (a) rate limiting across pollers (b) back-off across pollers when server is busy
-	policy.SetExpirationInterval(backoff.NoInterval) // We don't ever expire
-	return policy
+	retrier := backoff.NewConcurrentRetrierWithErrorPolicy(policy)
+	retrier.SetIsRetryable(backoff.IsRetryable(isFailure))
+	retrier.SetJitter(jitter)
+	return retrier
 }
 
 func newBaseWorker(options baseWorkerOptions, logger *zap.Logger, metricsScope tally.Scope, sessionTokenBucket *sessionTokenBucket) *baseWorker {
@@ -154,7 +169,7 @@ func newBaseWorker(options baseWorkerOptions, logger *zap.Logger, metricsScope t
 		options:         options,
 		shutdownCh:      make(chan struct{}),
 		taskLimiter:     rate.NewLimiter(rate.Limit(options.maxTaskPerSecond), 1),
-		retrier:         backoff.NewConcurrentRetrier(pollOperationRetryPolicy),
+		retrier:         newPollRetrier(options),
 		logger:          logger.With(zapcore.Field{Key: tagWorkerType, Type: zapcore.StringType, String: options.workerType}),
 		metricsScope:    tagScope(metricsScope, tagWorkerType, options.workerType),
 		pollerRequestCh: make(chan struct{}, options.maxConcurrentTask),
@@ -252,17 +267,13 @@ func (bw *baseWorker) runTaskDispatcher() {
 func (bw *baseWorker) pollTask() {
 	var err error
 	var task interface{}
-	bw.retrier.Throttle()
+	bw.retrier.ThrottleWithStop(bw.shutdownCh)
 	if bw.pollLimiter == nil || bw.pollLimiter.Wait(bw.limiterContext) == nil {
 		task, err = bw.options.taskWorker.PollTask()
 		if err != nil && enableVerboseLogging {
 			bw.logger.Debug("Failed to poll for task.", zap.Error(err))
 		}
-		if err != nil && isServiceTransientError(err) {
-			bw.retrier.Failed()
-		} else {
-			bw.retrier.Succeeded()
-		}
+		bw.retrier.Done(err)
 	}
 
 	if task != nil {
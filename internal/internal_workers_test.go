@@ -22,6 +22,8 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -110,6 +112,52 @@ func (s *WorkersTestSuite) TestWorkflowWorker() {
 	s.Nil(ctx.Err())
 }
 
+func (s *WorkersTestSuite) TestWorkerLoadsSearchAttributes() {
+	domain := "testDomain"
+	logger, _ := zap.NewDevelopment()
+
+	keys := map[string]m.IndexedValueType{"CustomerId": m.IndexedValueTypeKeyword}
+	s.service.EXPECT().DescribeDomain(gomock.Any(), gomock.Any(), callOptions...).Return(nil, nil).Times(1)
+	gomock.InOrder(
+		s.service.EXPECT().GetSearchAttributes(gomock.Any(), callOptions...).Return(&m.GetSearchAttributesResponse{Keys: keys}, nil),
+		s.service.EXPECT().GetSearchAttributes(gomock.Any(), callOptions...).Return(nil, &m.BadRequestError{}),
+	)
+	s.service.EXPECT().PollForDecisionTask(gomock.Any(), gomock.Any(), callOptions...).Return(&m.PollForDecisionTaskResponse{}, nil).AnyTimes()
+	s.service.EXPECT().RespondDecisionTaskCompleted(gomock.Any(), gomock.Any(), callOptions...).Return(nil, nil).AnyTimes()
+
+	newWorker := func() *aggregatedWorker {
+		ctx, cancel := context.WithCancel(context.Background())
+		executionParameters := workerExecutionParameters{
+			TaskList:                     "testTaskList",
+			MaxConcurrentDecisionPollers: 5,
+			Logger:                       logger,
+			UserContext:                  ctx,
+			UserContextCancel:            cancel,
+			SearchAttributesSchema:       &searchAttributesSchema{},
+		}
+		overrides := &workerOverrides{workflowTaskHandler: newSampleWorkflowTaskHandler()}
+		return &aggregatedWorker{
+			workflowWorker: newWorkflowWorkerInternal(
+				s.service, domain, executionParameters, nil, overrides, getHostEnvironment(),
+			),
+			logger:                 logger,
+			hostEnv:                getHostEnvironment(),
+			service:                s.service,
+			searchAttributesSchema: executionParameters.SearchAttributesSchema,
+		}
+	}
+
+	worker := newWorker()
+	s.NoError(worker.Start())
+	worker.Stop()
+	s.Equal(keys, worker.searchAttributesSchema.keys)
+
+	// the validation was requested, so the worker does not start when the search attributes cannot be loaded
+	worker = newWorker()
+	s.Error(worker.Start())
+	s.Nil(worker.searchAttributesSchema.keys)
+}
+
 func (s *WorkersTestSuite) TestActivityWorker() {
 	domain := "testDomain"
 	logger, _ := zap.NewDevelopment()
@@ -214,6 +262,77 @@ func (s *WorkersTestSuite) TestPollForDecisionTask_InternalServiceError() {
 	workflowWorker.Stop()
 }
 
+type recordingPollBackoffPolicy struct {
+	sync.Mutex
+	delay       time.Duration
+	numAttempts []int
+	errs        []error
+}
+
+func (p *recordingPollBackoffPolicy) ComputeNextDelay(err error, elapsedTime time.Duration, numAttempts int) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	p.numAttempts = append(p.numAttempts, numAttempts)
+	p.errs = append(p.errs, err)
+	if _, ok := err.(*m.ServiceBusyError); !ok {
+		// connection errors are retried right away
+		return -1
+	}
+	return p.delay
+}
+
+func (s *WorkersTestSuite) TestPollRetrier() {
+	policy := &recordingPollBackoffPolicy{delay: 10 * time.Millisecond}
+	retrier := newPollRetrier(baseWorkerOptions{
+		pollBackoffPolicy: policy,
+		pollErrorClassifier: func(err error) bool {
+			_, ok := err.(*m.BadRequestError)
+			return !ok
+		},
+		pollBackoffJitter: -1,
+	})
+
+	// errors which are not classified as failures are retried right away
+	retrier.Done(&m.BadRequestError{})
+	retrier.Throttle()
+	s.Empty(policy.numAttempts)
+
+	// the policy decides how long to back off on each error
+	connectionErr := errors.New("connection reset by peer")
+	retrier.Done(connectionErr)
+	policy.delay = time.Minute
+	startTime := time.Now()
+	retrier.Throttle()
+	s.True(time.Since(startTime) < time.Minute)
+
+	policy.delay = 10 * time.Millisecond
+	retrier.Done(&m.ServiceBusyError{})
+	startTime = time.Now()
+	retrier.Throttle()
+	s.True(time.Since(startTime) >= policy.delay)
+
+	// the backoff is interrupted when the worker stops
+	stopCh := make(chan struct{})
+	close(stopCh)
+	policy.delay = time.Minute
+	retrier.ThrottleWithStop(stopCh)
+	s.Equal([]int{0, 1, 2}, policy.numAttempts)
+	s.Equal([]error{connectionErr, &m.ServiceBusyError{}, &m.ServiceBusyError{}}, policy.errs)
+
+	retrier.Done(nil)
+	retrier.Throttle()
+	s.Equal([]int{0, 1, 2}, policy.numAttempts)
+}
+
+func (s *WorkersTestSuite) TestPollRetrierDefaults() {
+	policy := NewExponentialPollBackoffPolicy(retryPollOperationInitialInterval, retryPollOperationMaxInterval)
+	s.Equal(20*time.Millisecond, policy.ComputeNextDelay(&m.ServiceBusyError{}, 0, 0))
+	s.Equal(40*time.Millisecond, policy.ComputeNextDelay(&m.ServiceBusyError{}, time.Hour, 1))
+	s.Equal(10*time.Second, policy.ComputeNextDelay(&m.InternalServiceError{}, time.Hour, 100))
+	s.True(DefaultPollErrorClassifier(&m.InternalServiceError{}))
+	s.False(DefaultPollErrorClassifier(&m.BadRequestError{}))
+}
+
 func (s *WorkersTestSuite) TestLongRunningDecisionTask() {
 	localActivityCalledCount := 0
 	localActivitySleep := func(duration time.Duration) error {
@@ -350,6 +469,176 @@ func (s *WorkersTestSuite) TestLongRunningDecisionTask() {
 	s.Equal(2, localActivityCalledCount)
 }
 
+func (s *WorkersTestSuite) TestLocalActivityOutlivesDecisionTimeout() {
+	localActivityCalled := false
+	localActivitySleep := func(duration time.Duration) error {
+		time.Sleep(duration)
+		localActivityCalled = true
+		return nil
+	}
+
+	isWorkflowCompleted := false
+	workflowFn := func(ctx Context, input []byte) error {
+		ctx = WithLocalActivityOptions(ctx, LocalActivityOptions{ScheduleToCloseTimeout: 5 * time.Second})
+		// the local activity runs longer than the decision task timeout of 1 second
+		err := ExecuteLocalActivity(ctx, localActivitySleep, 1200*time.Millisecond).Get(ctx, nil)
+		isWorkflowCompleted = true
+		return err
+	}
+	RegisterWorkflowWithOptions(workflowFn, RegisterWorkflowOptions{Name: "long-local-activity-workflow-type"})
+
+	domain := "testDomain"
+	taskList := "long-local-activity-tl"
+	events := []*m.HistoryEvent{
+		{
+			EventId:   common.Int64Ptr(1),
+			EventType: common.EventTypePtr(m.EventTypeWorkflowExecutionStarted),
+			WorkflowExecutionStartedEventAttributes: &m.WorkflowExecutionStartedEventAttributes{
+				TaskList:                            &m.TaskList{Name: &taskList},
+				ExecutionStartToCloseTimeoutSeconds: common.Int32Ptr(10),
+				TaskStartToCloseTimeoutSeconds:      common.Int32Ptr(1),
+				WorkflowType:                        &m.WorkflowType{Name: common.StringPtr("long-local-activity-workflow-type")},
+			},
+		},
+		createTestEventDecisionTaskScheduled(2, &m.DecisionTaskScheduledEventAttributes{TaskList: &m.TaskList{Name: &taskList}}),
+		createTestEventDecisionTaskStarted(3),
+	}
+
+	s.service.EXPECT().DescribeDomain(gomock.Any(), gomock.Any(), callOptions...).Return(nil, nil).AnyTimes()
+	task := &m.PollForDecisionTaskResponse{
+		TaskToken: []byte("test-token"),
+		WorkflowExecution: &m.WorkflowExecution{
+			WorkflowId: common.StringPtr("long-local-activity-workflow-id"),
+			RunId:      common.StringPtr("long-local-activity-workflow-run-id"),
+		},
+		WorkflowType:           &m.WorkflowType{Name: common.StringPtr("long-local-activity-workflow-type")},
+		PreviousStartedEventId: common.Int64Ptr(0),
+		StartedEventId:         common.Int64Ptr(3),
+		History:                &m.History{Events: events},
+	}
+	s.service.EXPECT().PollForDecisionTask(gomock.Any(), gomock.Any(), callOptions...).Return(task, nil).Times(1)
+	s.service.EXPECT().PollForDecisionTask(gomock.Any(), gomock.Any(), callOptions...).Return(&m.PollForDecisionTaskResponse{}, &m.InternalServiceError{}).AnyTimes()
+
+	doneCh := make(chan struct{})
+	heartbeats := 0
+	s.service.EXPECT().RespondDecisionTaskCompleted(gomock.Any(), gomock.Any(), callOptions...).DoAndReturn(func(ctx context.Context, request *m.RespondDecisionTaskCompletedRequest, opts ...yarpc.CallOption,
+	) (*m.RespondDecisionTaskCompletedResponse, error) {
+		if len(request.Decisions) == 0 {
+			// the worker heartbeats the decision task while the local activity is running
+			s.True(request.GetForceCreateNewDecisionTask())
+			heartbeats++
+			startedEventID := task.GetStartedEventId()
+			task.PreviousStartedEventId = common.Int64Ptr(startedEventID)
+			task.StartedEventId = common.Int64Ptr(startedEventID + 3)
+			task.History = &m.History{Events: []*m.HistoryEvent{
+				createTestEventDecisionTaskCompleted(startedEventID+1, &m.DecisionTaskCompletedEventAttributes{}),
+				createTestEventDecisionTaskScheduled(startedEventID+2, &m.DecisionTaskScheduledEventAttributes{TaskList: &m.TaskList{Name: &taskList}}),
+				createTestEventDecisionTaskStarted(startedEventID + 3),
+			}}
+			return &m.RespondDecisionTaskCompletedResponse{DecisionTask: task}, nil
+		}
+		s.Equal(2, len(request.Decisions))
+		s.Equal(m.DecisionTypeRecordMarker, request.Decisions[0].GetDecisionType())
+		s.Equal(m.DecisionTypeCompleteWorkflowExecution, request.Decisions[1].GetDecisionType())
+		close(doneCh)
+		return nil, nil
+	}).MinTimes(2)
+
+	options := WorkerOptions{
+		Logger:                zap.NewNop(),
+		DisableActivityWorker: true,
+		Identity:              "test-worker-identity",
+	}
+	worker := newAggregatedWorker(s.service, domain, taskList, options)
+	worker.Start()
+	select {
+	case <-doneCh:
+	case <-time.After(4 * time.Second):
+	}
+	worker.Stop()
+
+	s.True(localActivityCalled)
+	s.True(isWorkflowCompleted)
+	s.True(heartbeats > 0)
+}
+
+func (s *WorkersTestSuite) TestLocalActivityHeartbeatDetailsRecordedInMarker() {
+	localActivityFn := func(ctx context.Context) error {
+		RecordActivityHeartbeat(ctx, "progress")
+		return errors.New("connection lost")
+	}
+
+	workflowFn := func(ctx Context, input []byte) error {
+		ctx = WithLocalActivityOptions(ctx, LocalActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Second,
+			// the backoff is longer than the decision task timeout, so the retry waits for a timer
+			RetryPolicy: &RetryPolicy{InitialInterval: 2 * time.Second, BackoffCoefficient: 2, MaximumAttempts: 2},
+		})
+		return ExecuteLocalActivity(ctx, localActivityFn).Get(ctx, nil)
+	}
+	RegisterWorkflowWithOptions(workflowFn, RegisterWorkflowOptions{Name: "local-activity-heartbeat-workflow-type"})
+
+	domain := "testDomain"
+	taskList := "local-activity-heartbeat-tl"
+	task := &m.PollForDecisionTaskResponse{
+		TaskToken: []byte("test-token"),
+		WorkflowExecution: &m.WorkflowExecution{
+			WorkflowId: common.StringPtr("local-activity-heartbeat-workflow-id"),
+			RunId:      common.StringPtr("local-activity-heartbeat-workflow-run-id"),
+		},
+		WorkflowType:           &m.WorkflowType{Name: common.StringPtr("local-activity-heartbeat-workflow-type")},
+		PreviousStartedEventId: common.Int64Ptr(0),
+		StartedEventId:         common.Int64Ptr(3),
+		History: &m.History{Events: []*m.HistoryEvent{
+			{
+				EventId:   common.Int64Ptr(1),
+				EventType: common.EventTypePtr(m.EventTypeWorkflowExecutionStarted),
+				WorkflowExecutionStartedEventAttributes: &m.WorkflowExecutionStartedEventAttributes{
+					TaskList:                            &m.TaskList{Name: &taskList},
+					ExecutionStartToCloseTimeoutSeconds: common.Int32Ptr(10),
+					TaskStartToCloseTimeoutSeconds:      common.Int32Ptr(1),
+					WorkflowType:                        &m.WorkflowType{Name: common.StringPtr("local-activity-heartbeat-workflow-type")},
+				},
+			},
+			createTestEventDecisionTaskScheduled(2, &m.DecisionTaskScheduledEventAttributes{TaskList: &m.TaskList{Name: &taskList}}),
+			createTestEventDecisionTaskStarted(3),
+		}},
+	}
+	s.service.EXPECT().DescribeDomain(gomock.Any(), gomock.Any(), callOptions...).Return(nil, nil).AnyTimes()
+	s.service.EXPECT().PollForDecisionTask(gomock.Any(), gomock.Any(), callOptions...).Return(task, nil).Times(1)
+	s.service.EXPECT().PollForDecisionTask(gomock.Any(), gomock.Any(), callOptions...).Return(&m.PollForDecisionTaskResponse{}, &m.InternalServiceError{}).AnyTimes()
+
+	doneCh := make(chan struct{})
+	var lamd localActivityMarkerData
+	s.service.EXPECT().RespondDecisionTaskCompleted(gomock.Any(), gomock.Any(), callOptions...).DoAndReturn(func(ctx context.Context, request *m.RespondDecisionTaskCompletedRequest, opts ...yarpc.CallOption,
+	) (*m.RespondDecisionTaskCompletedResponse, error) {
+		s.Equal(2, len(request.Decisions))
+		s.Equal(m.DecisionTypeRecordMarker, request.Decisions[0].GetDecisionType())
+		s.Equal(m.DecisionTypeStartTimer, request.Decisions[1].GetDecisionType())
+		s.NoError(decodeArg(nil, request.Decisions[0].RecordMarkerDecisionAttributes.Details, &lamd))
+		close(doneCh)
+		return nil, nil
+	}).Times(1)
+
+	options := WorkerOptions{
+		Logger:                zap.NewNop(),
+		DisableActivityWorker: true,
+		Identity:              "test-worker-identity",
+	}
+	worker := newAggregatedWorker(s.service, domain, taskList, options)
+	worker.Start()
+	select {
+	case <-doneCh:
+	case <-time.After(2 * time.Second):
+	}
+	worker.Stop()
+
+	// the details survive the eviction of the workflow, the next attempt gets them from the marker
+	var progress string
+	s.NoError(decodeArg(nil, lamd.HeartbeatDetails, &progress))
+	s.Equal("progress", progress)
+}
+
 func (s *WorkersTestSuite) TestMultipleLocalActivities() {
 	localActivityCalledCount := 0
 	localActivitySleep := func(duration time.Duration) error {
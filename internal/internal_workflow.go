@@ -25,8 +25,10 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -77,6 +79,23 @@ type (
 		settable Settable // used to unblock the future when all coroutines have completed
 	}
 
+	// Implements Semaphore interface
+	semaphoreImpl struct {
+		name    string
+		size    int                // the number of permits
+		used    int                // the number of acquired permits
+		waiters []*semaphoreWaiter // coroutines blocked on Acquire in the order they called it
+	}
+
+	semaphoreWaiter struct {
+		n int // the number of permits requested
+	}
+
+	// Implements Mutex interface as a Semaphore with a single permit
+	mutexImpl struct {
+		semaphore *semaphoreImpl
+	}
+
 	// Dispatcher is a container of a set of coroutines.
 	dispatcher interface {
 		// ExecuteUntilAllBlocked executes coroutines one by one in deterministic order
@@ -84,8 +103,9 @@ type (
 		ExecuteUntilAllBlocked() (err error)
 		// IsDone returns true when all of coroutines are completed
 		IsDone() bool
-		Close()             // Destroys all coroutines without waiting for their completion
-		StackTrace() string // Stack trace of all coroutines owned by the Dispatcher instance
+		Close()              // Destroys all coroutines without waiting for their completion
+		StackTrace() string  // Stack trace of all coroutines owned by the Dispatcher instance
+		CoroutineCount() int // Number of coroutines which are not closed yet
 	}
 
 	// Workflow is an interface that any workflow should implement.
@@ -152,16 +172,30 @@ type (
 		closed       bool             // indicates that owning coroutine has finished execution
 		blocked      atomic.Bool
 		panicError   *workflowPanicError // non nil if coroutine had unhandled panic
+		goroutineID  string              // id of the goroutine running the coroutine, used to dump its stack on deadlock
+		deadlocked   bool                // true indicates that coroutine didn't yield within the deadlock detection timeout
+	}
+
+	// potentialDeadlockError is the value of the workflowPanicError returned when a coroutine doesn't yield back to
+	// the dispatcher within the deadlock detection timeout.
+	potentialDeadlockError struct {
+		coroutineName string
+		timeout       time.Duration
 	}
 
 	dispatcherImpl struct {
-		sequence         int
-		channelSequence  int // used to name channels
-		selectorSequence int // used to name channels
-		coroutines       []*coroutineState
-		executing        bool       // currently running ExecuteUntilAllBlocked. Used to avoid recursive calls to it.
-		mutex            sync.Mutex // used to synchronize executing
-		closed           bool
+		sequence          int
+		channelSequence   int // used to name channels
+		selectorSequence  int // used to name channels
+		mutexSequence     int // used to name mutexes
+		semaphoreSequence int // used to name semaphores
+		randomSequence    int // used to seed random generators
+		coroutines        []*coroutineState
+		executing         bool       // currently running ExecuteUntilAllBlocked. Used to avoid recursive calls to it.
+		mutex             sync.Mutex // used to synchronize executing
+		closed            bool
+
+		deadlockDetectionTimeout time.Duration // max time a coroutine can run without yielding, zero means no limit
 	}
 
 	// The current timeout resolution implementation is in seconds and uses math.Ceil() as the duration. But is
@@ -174,7 +208,9 @@ type (
 		workflowID                          string
 		waitForCancellation                 bool
 		signalChannels                      map[string]Channel
-		queryHandlers                       map[string]func([]byte) ([]byte, error)
+		signalHandlers                      map[string]*signalHandler
+		updates                             *updateState
+		queryHandlers                       map[string]*queryHandler
 		workflowIDReusePolicy               WorkflowIDReusePolicy
 		dataConverter                       DataConverter
 		retryPolicy                         *shared.RetryPolicy
@@ -183,6 +219,7 @@ type (
 		memo                                map[string]interface{}
 		searchAttributes                    map[string]interface{}
 		parentClosePolicy                   ParentClosePolicy
+		continueAsNewThresholds             *ContinueAsNewThresholds
 	}
 
 	executeWorkflowParams struct {
@@ -201,6 +238,12 @@ type (
 		fn interface{}
 	}
 
+	// allOfFutureImpl is returned by AllOf, it decodes the results of all futures into a slice
+	allOfFutureImpl struct {
+		*futureImpl
+		futures []Future
+	}
+
 	childWorkflowFutureImpl struct {
 		*decodeFutureImpl             // for child workflow result
 		executionFuture   *futureImpl // for child workflow execution future
@@ -226,6 +269,14 @@ type (
 		Set(value interface{}, err error)
 	}
 
+	signalHandler struct {
+		fn            interface{}
+		signalName    string
+		channel       *channelImpl
+		dataConverter DataConverter
+		inFlight      int // number of handler calls running
+	}
+
 	queryHandler struct {
 		fn            interface{}
 		queryType     string
@@ -244,6 +295,8 @@ const (
 var _ Channel = (*channelImpl)(nil)
 var _ Selector = (*selectorImpl)(nil)
 var _ WaitGroup = (*waitGroupImpl)(nil)
+var _ Mutex = (*mutexImpl)(nil)
+var _ Semaphore = (*semaphoreImpl)(nil)
 var _ dispatcher = (*dispatcherImpl)(nil)
 
 var stackBuf [100000]byte
@@ -361,11 +414,8 @@ func (f *futureImpl) Chain(future Future) {
 		ch.ChainFuture(f)
 		return
 	}
-	val, err := ch.GetValueAndError()
-	f.value = val
-	f.err = err
-	f.ready = true
-	return
+	// Set closes the channel, otherwise Get on a future chained to a ready one blocks forever.
+	f.Set(ch.GetValueAndError())
 }
 
 func (f *futureImpl) ChainFuture(future Future) {
@@ -435,6 +485,7 @@ func (d *syncWorkflowDefinition) Execute(env workflowEnvironment, header *shared
 	}
 
 	d.rootCtx, d.cancel = WithCancel(rootCtx)
+	dispatcher.deadlockDetectionTimeout = env.GetDeadlockDetectionTimeout()
 	d.dispatcher = dispatcher
 
 	getWorkflowEnvironment(d.rootCtx).RegisterCancelHandler(func() {
@@ -455,15 +506,18 @@ func (d *syncWorkflowDefinition) Execute(env workflowEnvironment, header *shared
 
 	getWorkflowEnvironment(d.rootCtx).RegisterQueryHandler(func(queryType string, queryArgs []byte) ([]byte, error) {
 		eo := getWorkflowEnvOptions(d.rootCtx)
+		if queryType == QueryTypeQueryTypes {
+			return encodeArg(eo.dataConverter, eo.queryTypes())
+		}
 		handler, ok := eo.queryHandlers[queryType]
 		if !ok {
-			keys := []string{QueryTypeStackTrace, QueryTypeOpenSessions}
+			keys := []string{QueryTypeStackTrace, QueryTypeOpenSessions, QueryTypeQueryTypes}
 			for k := range eo.queryHandlers {
 				keys = append(keys, k)
 			}
 			return nil, fmt.Errorf("unknown queryType %v. KnownQueryTypes=%v", queryType, keys)
 		}
-		return handler(queryArgs)
+		return handler.execute(queryArgs)
 	})
 }
 
@@ -475,6 +529,13 @@ func (d *syncWorkflowDefinition) StackTrace() string {
 	return d.dispatcher.StackTrace()
 }
 
+func (d *syncWorkflowDefinition) CoroutineCount() int {
+	if d.dispatcher == nil {
+		return 0
+	}
+	return d.dispatcher.CoroutineCount()
+}
+
 func (d *syncWorkflowDefinition) Close() {
 	if d.dispatcher != nil {
 		d.dispatcher.Close()
@@ -496,6 +557,15 @@ func executeDispatcher(ctx Context, dispatcher dispatcher) {
 	env := getWorkflowEnvironment(ctx)
 	panicErr := dispatcher.ExecuteUntilAllBlocked()
 	if panicErr != nil {
+		if wpe, ok := panicErr.(*workflowPanicError); ok {
+			if _, ok := wpe.value.(*potentialDeadlockError); ok {
+				env.GetLogger().Error("Workflow deadlock detected.",
+					zap.String(tagWorkflowType, env.WorkflowInfo().WorkflowType.Name),
+					zap.String("PanicError", wpe.Error()),
+					zap.String("PanicStack", wpe.StackTrace()))
+				env.GetMetricsScope().Counter(metrics.WorkflowDeadlockCounter).Inc(1)
+			}
+		}
 		env.Complete(nil, panicErr)
 		return
 	}
@@ -713,6 +783,16 @@ func (c *channelImpl) sendAsyncImpl(v interface{}, pair *sendCallback) (ok bool)
 	return false
 }
 
+// canReceiveWithoutBlocking checks whether Receive would return without blocking.
+func (c *channelImpl) canReceiveWithoutBlocking() bool {
+	return c.recValue != nil || len(c.buffer) > 0 || c.closed || len(c.blockedSends) > 0
+}
+
+// canSendWithoutBlocking checks whether Send would return without blocking.
+func (c *channelImpl) canSendWithoutBlocking() bool {
+	return !c.closed && (len(c.buffer) < c.size || len(c.blockedReceives) > 0)
+}
+
 func (c *channelImpl) Close() {
 	c.closed = true
 	// Use a copy of blockedReceives for iteration as invoking callback could result in modification
@@ -767,27 +847,87 @@ func getStackTrace(coroutineName, status string, stackDepth int) string {
 
 func getStackTraceRaw(top string, omitTop, omitBottom int) string {
 	stack := stackBuf[:runtime.Stack(stackBuf[:], false)]
-	rawStack := fmt.Sprintf("%s", strings.TrimRightFunc(string(stack), unicode.IsSpace))
+	return cleanStackTrace(top, string(stack), omitTop, omitBottom)
+}
+
+// getGoroutineStackTrace returns the stack trace of another goroutine, even when it is busy and can't be asked to
+// dump its own stack. It returns an empty string if the goroutine is gone.
+func getGoroutineStackTrace(top, goroutineID string, omitBottom int) string {
+	buf := make([]byte, len(stackBuf))
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := "goroutine " + goroutineID + " "
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.HasPrefix(stack, prefix) {
+			return cleanStackTrace(top, stack, 1, omitBottom)
+		}
+	}
+	return ""
+}
+
+func cleanStackTrace(top, stack string, omitTop, omitBottom int) string {
+	rawStack := fmt.Sprintf("%s", strings.TrimRightFunc(stack, unicode.IsSpace))
 	if disableCleanStackTraces {
 		return rawStack
 	}
 	lines := strings.Split(rawStack, "\n")
+	if omitTop+omitBottom > len(lines) {
+		return rawStack
+	}
 	lines = lines[omitTop : len(lines)-omitBottom]
 	lines = append([]string{top}, lines...)
 	return strings.Join(lines, "\n")
 }
 
+// getGoroutineID returns the id of the calling goroutine as printed in its stack trace.
+func getGoroutineID() string {
+	var buf [64]byte
+	// first line of the stack looks like "goroutine 18 [running]:"
+	stack := strings.TrimPrefix(string(buf[:runtime.Stack(buf[:], false)]), "goroutine ")
+	if i := strings.IndexByte(stack, ' '); i > 0 {
+		return stack[:i]
+	}
+	return ""
+}
+
+func (e *potentialDeadlockError) Error() string {
+	return fmt.Sprintf("potential deadlock detected: coroutine %v didn't yield for over %v", e.coroutineName, e.timeout)
+}
+
 // unblocked is called by coroutine to indicate that since the last time yield was unblocked channel or select
 // where unblocked versus calling yield again after checking their condition
 func (s *coroutineState) unblocked() {
 	s.keptBlocked = false
 }
 
-func (s *coroutineState) call() {
+// call unblocks the coroutine and waits until it yields back. If it doesn't yield within the timeout, the coroutine is
+// considered deadlocked and an error carrying its stack trace is returned. Zero timeout waits forever.
+func (s *coroutineState) call(timeout time.Duration) *workflowPanicError {
 	s.unblock <- func(status string, stackDepth int) bool {
 		return false // unblock
 	}
-	<-s.aboutToBlock
+	if timeout <= 0 {
+		<-s.aboutToBlock
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.aboutToBlock:
+		return nil
+	case <-timer.C:
+		s.deadlocked = true
+		top := fmt.Sprintf("coroutine %s [potential deadlock]:", s.name)
+		st := getGoroutineStackTrace(top, s.goroutineID, 4)
+		return newWorkflowPanicError(&potentialDeadlockError{coroutineName: s.name, timeout: timeout}, st)
+	}
 }
 
 func (s *coroutineState) close() {
@@ -796,7 +936,8 @@ func (s *coroutineState) close() {
 }
 
 func (s *coroutineState) exit() {
-	if !s.closed {
+	// a deadlocked coroutine is not listening, there is no way to stop it.
+	if !s.closed && !s.deadlocked {
 		s.unblock <- func(status string, stackDepth int) bool {
 			runtime.Goexit()
 			return true
@@ -808,6 +949,10 @@ func (s *coroutineState) stackTrace() string {
 	if s.closed {
 		return ""
 	}
+	if s.deadlocked {
+		top := fmt.Sprintf("coroutine %s [potential deadlock]:", s.name)
+		return getGoroutineStackTrace(top, s.goroutineID, 4)
+	}
 	stackCh := make(chan string, 1)
 	s.unblock <- func(status string, stackDepth int) bool {
 		stackCh <- getStackTrace(s.name, status, stackDepth+2)
@@ -831,6 +976,7 @@ func (d *dispatcherImpl) newNamedCoroutine(ctx Context, name string, f func(ctx
 				crt.panicError = newWorkflowPanicError(r, st)
 			}
 		}()
+		crt.goroutineID = getGoroutineID()
 		crt.initialYield(1, "")
 		f(spawned)
 	}(state)
@@ -871,7 +1017,9 @@ func (d *dispatcherImpl) ExecuteUntilAllBlocked() (err error) {
 			if !c.closed {
 				// TODO: Support handling of panic in a coroutine by dispatcher.
 				// TODO: Dump all outstanding coroutines if one of them panics
-				c.call()
+				if deadlockErr := c.call(d.deadlockDetectionTimeout); deadlockErr != nil {
+					return deadlockErr
+				}
 			}
 			// c.call() can close the context so check again
 			if c.closed {
@@ -917,6 +1065,10 @@ func (d *dispatcherImpl) Close() {
 	}
 }
 
+func (d *dispatcherImpl) CoroutineCount() int {
+	return len(d.coroutines)
+}
+
 func (d *dispatcherImpl) StackTrace() string {
 	var result string
 	for i := 0; i < len(d.coroutines); i++ {
@@ -954,6 +1106,83 @@ func (s *selectorImpl) AddDefault(f func()) {
 	s.defaultFunc = &f
 }
 
+func (s *selectorImpl) removeReceive(c Channel) {
+	s.removeCases(func(sc *selectCase) bool {
+		return sc.receiveFunc != nil && sc.channel == c.(*channelImpl)
+	})
+}
+
+func (s *selectorImpl) removeSend(c Channel) {
+	s.removeCases(func(sc *selectCase) bool {
+		return sc.sendFunc != nil && sc.channel == c.(*channelImpl)
+	})
+}
+
+func (s *selectorImpl) removeFuture(future Future) {
+	s.removeCases(func(sc *selectCase) bool {
+		return sc.future != nil && sc.future == future
+	})
+}
+
+func (s *selectorImpl) removeDefault() {
+	s.defaultFunc = nil
+}
+
+// removeCases removes the cases matching the predicate. A new slice is built so that a Select iterating over the
+// old one, when called from a case function, is not affected.
+func (s *selectorImpl) removeCases(match func(sc *selectCase) bool) {
+	cases := make([]*selectCase, 0, len(s.cases))
+	for _, sc := range s.cases {
+		if !match(sc) {
+			cases = append(cases, sc)
+		}
+	}
+	s.cases = cases
+}
+
+func (s *selectorImpl) hasPending() bool {
+	for _, sc := range s.cases {
+		switch {
+		case sc.receiveFunc != nil:
+			if sc.channel.canReceiveWithoutBlocking() {
+				return true
+			}
+		case sc.sendFunc != nil:
+			if sc.channel.canSendWithoutBlocking() {
+				return true
+			}
+		case sc.futureFunc != nil:
+			if sc.future.IsReady() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *selectorImpl) selectWithTimeout(ctx Context, timeout time.Duration) bool {
+	if s.defaultFunc != nil || s.hasPending() {
+		// no need to start a timer that would be canceled right away
+		s.Select(ctx)
+		return true
+	}
+	if timeout <= 0 {
+		return false
+	}
+
+	timerCtx, cancel := WithCancel(ctx)
+	// cancels the timer if one of the cases was selected before it fired
+	defer cancel()
+	timer := NewTimer(timerCtx, timeout)
+	timedOut := false
+	s.AddFuture(timer, func(f Future) {
+		timedOut = true
+	})
+	s.Select(ctx)
+	s.removeFuture(timer)
+	return !timedOut
+}
+
 func (s *selectorImpl) Select(ctx Context) {
 	state := getState(ctx)
 	var readyBranch func()
@@ -1171,7 +1400,8 @@ func setWorkflowEnvOptionsIfNotExist(ctx Context) Context {
 		newOptions = *options
 	} else {
 		newOptions.signalChannels = make(map[string]Channel)
-		newOptions.queryHandlers = make(map[string]func([]byte) ([]byte, error))
+		newOptions.signalHandlers = make(map[string]*signalHandler)
+		newOptions.queryHandlers = make(map[string]*queryHandler)
 	}
 	if newOptions.dataConverter == nil {
 		newOptions.dataConverter = getDefaultDataConverter()
@@ -1213,6 +1443,17 @@ func (w *workflowOptions) getSignalChannel(ctx Context, signalName string) Chann
 	return ch
 }
 
+// signalHandlersIdle checks that none of the signal and update handlers is running and there are no signals waiting
+// for them.
+func (w *workflowOptions) signalHandlersIdle() bool {
+	for _, h := range w.signalHandlers {
+		if h.inFlight > 0 || len(h.channel.buffer) > 0 || h.channel.recValue != nil {
+			return false
+		}
+	}
+	return w.updates == nil || w.updates.inFlight == 0
+}
+
 // getUnhandledSignals checks if there are any signal channels that have data to be consumed.
 func (w *workflowOptions) getUnhandledSignals() []string {
 	unhandledSignals := []string{}
@@ -1250,6 +1491,29 @@ func (d *decodeFutureImpl) Get(ctx Context, value interface{}) error {
 	return d.futureImpl.err
 }
 
+func (f *allOfFutureImpl) Get(ctx Context, value interface{}) error {
+	err := f.futureImpl.Get(ctx, nil)
+	if value == nil {
+		return err
+	}
+	rf := reflect.ValueOf(value)
+	if rf.Type().Kind() != reflect.Ptr || rf.Elem().Kind() != reflect.Slice {
+		return errors.New("value parameter is not a pointer to a slice")
+	}
+	results := reflect.MakeSlice(rf.Elem().Type(), len(f.futures), len(f.futures))
+	for i, future := range f.futures {
+		if future.Get(ctx, nil) != nil {
+			// failed future, its error is already part of the AggregateError
+			continue
+		}
+		if decodeErr := future.Get(ctx, results.Index(i).Addr().Interface()); decodeErr != nil {
+			return decodeErr
+		}
+	}
+	rf.Elem().Set(results)
+	return err
+}
+
 // newDecodeFuture creates a new future as well as associated Settable that is used to set its value.
 // fn - the decoded value needs to be validated against a function.
 func newDecodeFuture(ctx Context, fn interface{}) (Future, Settable) {
@@ -1266,10 +1530,77 @@ func setQueryHandler(ctx Context, queryType string, handler interface{}) error {
 		return err
 	}
 
-	getWorkflowEnvOptions(ctx).queryHandlers[queryType] = qh.execute
+	getWorkflowEnvOptions(ctx).queryHandlers[queryType] = qh
+	return nil
+}
+
+// setSignalHandler sets signal handler for given signalName.
+func setSignalHandler(ctx Context, signalName string, handler interface{}) error {
+	eo := getWorkflowEnvOptions(ctx)
+	if h, ok := eo.signalHandlers[signalName]; ok {
+		if err := validateSignalHandlerFn(handler); err != nil {
+			return err
+		}
+		h.fn = handler
+		return nil
+	}
+	h := &signalHandler{
+		fn:            handler,
+		signalName:    signalName,
+		channel:       eo.getSignalChannel(ctx, signalName).(*channelImpl),
+		dataConverter: getDataConverterFromWorkflowContext(ctx),
+	}
+	if err := validateSignalHandlerFn(handler); err != nil {
+		return err
+	}
+	eo.signalHandlers[signalName] = h
+	GoNamed(ctx, "signal-handler-"+signalName, h.dispatch)
+	return nil
+}
+
+func validateSignalHandlerFn(fn interface{}) error {
+	fnType := reflect.TypeOf(fn)
+	if fnType == nil || fnType.Kind() != reflect.Func {
+		return fmt.Errorf("signal handler must be function but was %v", fnType)
+	}
+	if fnType.NumIn() < 1 || !isWorkflowContext(fnType.In(0)) {
+		return errors.New("first input parameter of signal handler must be workflow.Context")
+	}
+	if fnType.NumIn() > 2 {
+		return fmt.Errorf("signal handler must take at most one signal input parameter, but found %d", fnType.NumIn()-1)
+	}
+	if fnType.NumOut() != 0 {
+		return fmt.Errorf("signal handler must not return any value, but found %d return values", fnType.NumOut())
+	}
 	return nil
 }
 
+// dispatch delivers each signal from the signal channel to the handler in its own coroutine.
+func (h *signalHandler) dispatch(ctx Context) {
+	state := getState(ctx)
+	for {
+		v, ok, _ := h.channel.receiveAsyncImpl(nil)
+		if !ok {
+			state.yield(fmt.Sprintf("blocked on %s.SignalHandler", h.signalName))
+			continue
+		}
+		state.unblocked()
+		input, _ := v.([]byte)
+		args, err := decodeArgs(h.dataConverter, reflect.TypeOf(h.fn), input)
+		if err != nil {
+			h.channel.logger.Error(fmt.Sprintf("Corrupt signal received for signal handler %s. Error deserializing", h.signalName), zap.Error(err))
+			h.channel.scope.Counter(metrics.CorruptedSignalsCounter).Inc(1)
+			continue
+		}
+		fn := h.fn
+		h.inFlight++
+		GoNamed(ctx, "signal-"+h.signalName, func(ctx Context) {
+			defer func() { h.inFlight-- }()
+			reflect.ValueOf(fn).Call(append([]reflect.Value{reflect.ValueOf(ctx)}, args...))
+		})
+	}
+}
+
 func (h *queryHandler) validateHandlerFn() error {
 	fnType := reflect.TypeOf(h.fn)
 	if fnType.Kind() != reflect.Func {
@@ -1295,6 +1626,35 @@ func (h *queryHandler) validateHandlerFn() error {
 	return nil
 }
 
+// typeInfo describes the arguments and the result of the handler.
+func (h *queryHandler) typeInfo() QueryTypeInfo {
+	fnType := reflect.TypeOf(h.fn)
+	info := QueryTypeInfo{Name: h.queryType, ArgTypes: []string{}, ResultType: fnType.Out(0).String()}
+	for i := 0; i < fnType.NumIn(); i++ {
+		info.ArgTypes = append(info.ArgTypes, fnType.In(i).String())
+	}
+	return info
+}
+
+// queryTypes lists the built-in query types and the ones with a handler set through SetQueryHandler, sorted by name.
+// Query types reserved for internal use by the framework are left out.
+func (w *workflowOptions) queryTypes() []QueryTypeInfo {
+	types := []QueryTypeInfo{
+		{Name: QueryTypeStackTrace, ArgTypes: []string{}, ResultType: "string"},
+		{Name: QueryTypeOpenSessions, ArgTypes: []string{}, ResultType: "[]*workflow.SessionInfo"},
+		{Name: QueryTypeQueryTypes, ArgTypes: []string{}, ResultType: "[]client.QueryTypeInfo"},
+	}
+	for queryType, h := range w.queryHandlers {
+		if !strings.HasPrefix(queryType, "__") {
+			types = append(types, h.typeInfo())
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Name < types[j].Name
+	})
+	return types
+}
+
 func (h *queryHandler) execute(input []byte) (result []byte, err error) {
 	// if query handler panic, convert it to error
 	defer func() {
@@ -1399,3 +1759,111 @@ func (wg *waitGroupImpl) Wait(ctx Context) {
 	}
 	wg.future, wg.settable = NewFuture(ctx)
 }
+
+func newSemaphore(name string, size int) *semaphoreImpl {
+	if size <= 0 {
+		panic(fmt.Sprintf("%s: semaphore size must be positive, got %v", name, size))
+	}
+	return &semaphoreImpl{name: name, size: size}
+}
+
+// Acquire blocks until n permits are acquired or the ctx is canceled.
+func (s *semaphoreImpl) Acquire(ctx Context, n int) error {
+	return s.acquire(ctx, n, "Acquire")
+}
+
+// acquire blocks until n permits are acquired or the ctx is canceled, op is the operation reported in stack traces.
+func (s *semaphoreImpl) acquire(ctx Context, n int, op string) error {
+	s.validate(n)
+	if s.TryAcquire(n) {
+		return nil
+	}
+
+	state := getState(ctx)
+	defer state.unblocked()
+	waiter := &semaphoreWaiter{n: n}
+	s.waiters = append(s.waiters, waiter)
+	for {
+		if s.waiters[0] == waiter && s.used+n <= s.size {
+			s.waiters = s.waiters[1:]
+			s.used += n
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			s.removeWaiter(waiter)
+			return err
+		}
+		state.yield(fmt.Sprintf("blocked on %s.%s", s.name, op))
+	}
+}
+
+// TryAcquire acquires n permits if they are available and nobody is waiting for permits.
+func (s *semaphoreImpl) TryAcquire(n int) bool {
+	s.validate(n)
+	if len(s.waiters) > 0 || s.used+n > s.size {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+// Release releases n permits, the waiting coroutines pick them up the next time they are executed.
+func (s *semaphoreImpl) Release(n int) {
+	if n <= 0 || n > s.used {
+		panic(fmt.Sprintf("%s: releasing %v permits while %v are acquired", s.name, n, s.used))
+	}
+	s.used -= n
+}
+
+func (s *semaphoreImpl) validate(n int) {
+	if n <= 0 || n > s.size {
+		panic(fmt.Sprintf("%s: acquiring %v permits of a semaphore of size %v", s.name, n, s.size))
+	}
+}
+
+func (s *semaphoreImpl) removeWaiter(waiter *semaphoreWaiter) {
+	for i, w := range s.waiters {
+		if w == waiter {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Lock blocks until the mutex is acquired or the ctx is canceled.
+func (m *mutexImpl) Lock(ctx Context) error {
+	return m.semaphore.acquire(ctx, 1, "Lock")
+}
+
+// TryLock acquires the mutex if it is not locked and nobody is waiting for it.
+func (m *mutexImpl) TryLock() bool {
+	return m.semaphore.TryAcquire(1)
+}
+
+// Unlock releases the mutex.
+func (m *mutexImpl) Unlock() {
+	if m.semaphore.used == 0 {
+		panic(fmt.Sprintf("%s: unlock of unlocked mutex", m.semaphore.name))
+	}
+	m.semaphore.Release(1)
+}
+
+// IsLocked returns true if the mutex is locked.
+func (m *mutexImpl) IsLocked() bool {
+	return m.semaphore.used > 0
+}
+
+// newRandomSeed returns the seed of the next random generator of the workflow. It is derived from the run ID the
+// workflow execution was originally started with, which survives resets, and the number of generators created so far.
+func newRandomSeed(ctx Context) int64 {
+	state := getState(ctx)
+	state.dispatcher.randomSequence++
+	info := GetWorkflowInfo(ctx)
+	runID := info.originalRunID
+	if runID == "" {
+		runID = info.WorkflowExecution.RunID
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("%s-%d", runID, state.dispatcher.randomSequence)))
+	return int64(h.Sum64())
+}
@@ -170,12 +170,13 @@ func (wc *workflowClient) StartWorkflow(
 	}
 
 	// Validate type and its arguments.
-	workflowType, input, err := getValidatedWorkflowFunction(workflowFunc, args, wc.dataConverter)
+	dataConverter := dataConverterForWorkflow(wc.dataConverter, workflowID)
+	workflowType, input, err := getValidatedWorkflowFunction(workflowFunc, args, dataConverter)
 	if err != nil {
 		return nil, err
 	}
 
-	memo, err := getWorkflowMemo(options.Memo, wc.dataConverter)
+	memo, err := getWorkflowMemo(options.Memo, dataConverter)
 	if err != nil {
 		return nil, err
 	}
@@ -310,7 +311,7 @@ func (wc *workflowClient) GetWorkflow(ctx context.Context, workflowID string, ru
 
 // SignalWorkflow signals a workflow in execution.
 func (wc *workflowClient) SignalWorkflow(ctx context.Context, workflowID string, runID string, signalName string, arg interface{}) error {
-	input, err := encodeArg(wc.dataConverter, arg)
+	input, err := encodeArg(dataConverterForWorkflow(wc.dataConverter, workflowID), arg)
 	if err != nil {
 		return err
 	}
@@ -339,15 +340,16 @@ func (wc *workflowClient) SignalWorkflow(ctx context.Context, workflowID string,
 func (wc *workflowClient) SignalWithStartWorkflow(ctx context.Context, workflowID string, signalName string, signalArg interface{},
 	options StartWorkflowOptions, workflowFunc interface{}, workflowArgs ...interface{}) (*WorkflowExecution, error) {
 
-	signalInput, err := encodeArg(wc.dataConverter, signalArg)
-	if err != nil {
-		return nil, err
-	}
-
 	if workflowID == "" {
 		workflowID = uuid.NewRandom().String()
 	}
 
+	dataConverter := dataConverterForWorkflow(wc.dataConverter, workflowID)
+	signalInput, err := encodeArg(dataConverter, signalArg)
+	if err != nil {
+		return nil, err
+	}
+
 	if options.TaskList == "" {
 		return nil, errors.New("missing TaskList")
 	}
@@ -366,12 +368,12 @@ func (wc *workflowClient) SignalWithStartWorkflow(ctx context.Context, workflowI
 	}
 
 	// Validate type and its arguments.
-	workflowType, input, err := getValidatedWorkflowFunction(workflowFunc, workflowArgs, wc.dataConverter)
+	workflowType, input, err := getValidatedWorkflowFunction(workflowFunc, workflowArgs, dataConverter)
 	if err != nil {
 		return nil, err
 	}
 
-	memo, err := getWorkflowMemo(options.Memo, wc.dataConverter)
+	memo, err := getWorkflowMemo(options.Memo, dataConverter)
 	if err != nil {
 		return nil, err
 	}
@@ -575,16 +577,17 @@ func (wc *workflowClient) CompleteActivityByID(ctx context.Context, domain, work
 		return errors.New("empty activity or workflow id or domainName")
 	}
 
+	dataConverter := dataConverterForWorkflow(wc.dataConverter, workflowID)
 	var data []byte
 	if result != nil {
 		var err0 error
-		data, err0 = encodeArg(wc.dataConverter, result)
+		data, err0 = encodeArg(dataConverter, result)
 		if err0 != nil {
 			return err0
 		}
 	}
 
-	request := convertActivityResultToRespondRequestByID(wc.identity, domain, workflowID, runID, activityID, data, err, wc.dataConverter)
+	request := convertActivityResultToRespondRequestByID(wc.identity, domain, workflowID, runID, activityID, data, err, dataConverter)
 	return reportActivityCompleteByID(ctx, wc.workflowService, request, wc.metricsScope)
 }
 
@@ -600,7 +603,7 @@ func (wc *workflowClient) RecordActivityHeartbeat(ctx context.Context, taskToken
 // RecordActivityHeartbeatByID records heartbeat for an activity.
 func (wc *workflowClient) RecordActivityHeartbeatByID(ctx context.Context,
 	domain, workflowID, runID, activityID string, details ...interface{}) error {
-	data, err := encodeArgs(wc.dataConverter, details)
+	data, err := encodeArgs(dataConverterForWorkflow(wc.dataConverter, workflowID), details)
 	if err != nil {
 		return err
 	}
@@ -804,6 +807,7 @@ func (wc *workflowClient) DescribeWorkflowExecution(ctx context.Context, workflo
 //  - InternalServiceError
 //  - EntityNotExistError
 //  - QueryFailError
+//  - CustomError when the query handler returned one
 func (wc *workflowClient) QueryWorkflow(ctx context.Context, workflowID string, runID string, queryType string, args ...interface{}) (Value, error) {
 	queryWorkflowWithOptionsRequest := &QueryWorkflowWithOptionsRequest{
 		WorkflowID: workflowID,
@@ -818,6 +822,82 @@ func (wc *workflowClient) QueryWorkflow(ctx context.Context, workflowID string,
 	return result.QueryResult, nil
 }
 
+// UpdateWorkflow sends an update to a workflow and waits for its outcome.
+func (wc *workflowClient) UpdateWorkflow(ctx context.Context, workflowID string, runID string, updateName string,
+	args ...interface{}) (Value, error) {
+	dataConverter := dataConverterForWorkflow(wc.dataConverter, workflowID)
+	input, err := encodeArgs(dataConverter, args)
+	if err != nil {
+		return nil, err
+	}
+	request := updateRequest{ID: uuid.New(), Name: updateName, Args: input}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, updateDefaultTimeout)
+		defer cancel()
+	}
+	if runID == "" {
+		// all the calls below have to reach the same run, the latest one changes with continue as new
+		describe, err := wc.DescribeWorkflowExecution(ctx, workflowID, "")
+		if err != nil {
+			return nil, err
+		}
+		runID = describe.WorkflowExecutionInfo.Execution.GetRunId()
+	}
+
+	// the validation query lets the workflow reject the update before the signal is written to the history
+	outcome, err := wc.queryUpdate(ctx, workflowID, runID, updateValidateQueryType, request)
+	if err != nil {
+		return nil, err
+	}
+	if !outcome.Completed {
+		if err := wc.SignalWorkflow(ctx, workflowID, runID, updateSignalName, request); err != nil {
+			return nil, err
+		}
+		interval := updatePollInitialInterval
+		for {
+			if outcome, err = wc.queryUpdate(ctx, workflowID, runID, updateResultQueryType, request.ID); err != nil {
+				return nil, err
+			}
+			if outcome.Completed || outcome.Evicted {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(interval):
+			}
+			if interval *= 2; interval > updatePollMaxInterval {
+				interval = updatePollMaxInterval
+			}
+		}
+	}
+
+	if outcome.Evicted {
+		return nil, fmt.Errorf("outcome of update %v is not retained by the workflow anymore", request.ID)
+	}
+	if outcome.Rejection != "" {
+		return nil, &UpdateRejectedError{message: outcome.Rejection}
+	}
+	if outcome.ErrReason != "" {
+		return nil, constructError(outcome.ErrReason, outcome.ErrDetails, dataConverter)
+	}
+	return newEncodedValue(outcome.Result, dataConverter), nil
+}
+
+func (wc *workflowClient) queryUpdate(ctx context.Context, workflowID string, runID string, queryType string,
+	arg interface{}) (*updateOutcome, error) {
+	value, err := wc.QueryWorkflow(ctx, workflowID, runID, queryType, arg)
+	if err != nil {
+		return nil, err
+	}
+	var outcome updateOutcome
+	if err := value.Get(&outcome); err != nil {
+		return nil, err
+	}
+	return &outcome, nil
+}
+
 // QueryWorkflowWithOptionsRequest is the request to QueryWorkflowWithOptions
 type QueryWorkflowWithOptionsRequest struct {
 	// WorkflowID is a required field indicating the workflow which should be queried.
@@ -864,11 +944,12 @@ type QueryWorkflowWithOptionsResponse struct {
 //  - InternalServiceError
 //  - EntityNotExistError
 //  - QueryFailError
+//  - CustomError when the query handler returned one
 func (wc *workflowClient) QueryWorkflowWithOptions(ctx context.Context, request *QueryWorkflowWithOptionsRequest) (*QueryWorkflowWithOptionsResponse, error) {
 	var input []byte
 	if len(request.Args) > 0 {
 		var err error
-		if input, err = encodeArgs(wc.dataConverter, request.Args); err != nil {
+		if input, err = encodeArgs(dataConverterForWorkflow(wc.dataConverter, request.WorkflowID), request.Args); err != nil {
 			return nil, err
 		}
 	}
@@ -896,7 +977,7 @@ func (wc *workflowClient) QueryWorkflowWithOptions(ctx context.Context, request
 			return err
 		}, createDynamicServiceRetryPolicy(ctx), isServiceTransientError)
 	if err != nil {
-		return nil, err
+		return nil, constructQueryError(err, wc.dataConverter)
 	}
 
 	if resp.QueryRejected != nil {
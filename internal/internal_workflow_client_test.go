@@ -27,6 +27,7 @@ import (
 	"go.uber.org/cadence/internal/common/serializer"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -1330,6 +1331,130 @@ func (s *workflowClientTestSuite) TestGetSearchAttributes() {
 	s.Equal(responseErr, err)
 }
 
+func (s *workflowClientTestSuite) TestUpdateWorkflow() {
+	queryResponse := func(outcome *updateOutcome) *shared.QueryWorkflowResponse {
+		data, err := encodeArg(nil, outcome)
+		s.NoError(err)
+		return &shared.QueryWorkflowResponse{QueryResult: data}
+	}
+	result, err := encodeArg(nil, 3)
+	s.NoError(err)
+
+	var updateID string
+	gomock.InOrder(
+		s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.QueryWorkflowRequest, _ ...interface{}) {
+				s.Equal(updateValidateQueryType, request.Query.GetQueryType())
+			}).Return(queryResponse(&updateOutcome{}), nil),
+		s.service.EXPECT().SignalWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.SignalWorkflowExecutionRequest, _ ...interface{}) {
+				s.Equal(updateSignalName, request.GetSignalName())
+				var update updateRequest
+				s.NoError(decodeArg(nil, request.Input, &update))
+				s.Equal("add", update.Name)
+				updateID = update.ID
+			}).Return(nil),
+		s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(queryResponse(&updateOutcome{}), nil),
+		s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.QueryWorkflowRequest, _ ...interface{}) {
+				s.Equal(updateResultQueryType, request.Query.GetQueryType())
+				var id string
+				s.NoError(decodeArg(nil, request.Query.QueryArgs, &id))
+				s.Equal(updateID, id)
+			}).Return(queryResponse(&updateOutcome{Completed: true, Result: result}), nil),
+	)
+
+	value, err := s.client.UpdateWorkflow(context.Background(), workflowID, runID, "add", 1, 2)
+	s.NoError(err)
+	var total int
+	s.NoError(value.Get(&total))
+	s.Equal(3, total)
+}
+
+func (s *workflowClientTestSuite) TestUpdateWorkflow_PinsRunAndFailsOnEvictedOutcome() {
+	queryResponse := func(outcome *updateOutcome) *shared.QueryWorkflowResponse {
+		data, err := encodeArg(nil, outcome)
+		s.NoError(err)
+		return &shared.QueryWorkflowResponse{QueryResult: data}
+	}
+	describeResponse := &shared.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &shared.WorkflowExecutionInfo{
+			Execution: &shared.WorkflowExecution{WorkflowId: common.StringPtr(workflowID), RunId: common.StringPtr(runID)},
+		},
+	}
+	gomock.InOrder(
+		s.service.EXPECT().DescribeWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(describeResponse, nil),
+		s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.QueryWorkflowRequest, _ ...interface{}) {
+				s.Equal(runID, request.Execution.GetRunId())
+			}).Return(queryResponse(&updateOutcome{}), nil),
+		s.service.EXPECT().SignalWorkflowExecution(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.SignalWorkflowExecutionRequest, _ ...interface{}) {
+				s.Equal(runID, request.WorkflowExecution.GetRunId())
+			}).Return(nil),
+		s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Do(func(_ interface{}, request *shared.QueryWorkflowRequest, _ ...interface{}) {
+				s.Equal(runID, request.Execution.GetRunId())
+			}).Return(queryResponse(&updateOutcome{Evicted: true}), nil),
+	)
+
+	_, err := s.client.UpdateWorkflow(context.Background(), workflowID, "", "add", 1, 2)
+	s.Error(err)
+	s.Contains(err.Error(), "not retained by the workflow anymore")
+}
+
+func (s *workflowClientTestSuite) TestUpdateWorkflow_Rejected() {
+	data, err := encodeArg(nil, &updateOutcome{Completed: true, Rejection: "cart is full"})
+	s.NoError(err)
+	s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&shared.QueryWorkflowResponse{QueryResult: data}, nil)
+
+	_, err = s.client.UpdateWorkflow(context.Background(), workflowID, runID, "add", 1, 2)
+	s.Error(err)
+	s.IsType(&UpdateRejectedError{}, err)
+	s.Equal("update rejected: cart is full", err.Error())
+}
+
+func (s *workflowClientTestSuite) TestUpdateWorkflow_Failed() {
+	reason, details := getErrorDetails(NewCustomError("out-of-stock", "item-1"), getDefaultDataConverter())
+	data, err := encodeArg(nil, &updateOutcome{Completed: true, ErrReason: reason, ErrDetails: details})
+	s.NoError(err)
+	s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&shared.QueryWorkflowResponse{QueryResult: data}, nil)
+
+	_, err = s.client.UpdateWorkflow(context.Background(), workflowID, runID, "add", 1, 2)
+	customErr, ok := err.(*CustomError)
+	s.True(ok)
+	s.Equal("out-of-stock", customErr.Reason())
+	var detail string
+	s.NoError(customErr.Details(&detail))
+	s.Equal("item-1", detail)
+}
+
+func (s *workflowClientTestSuite) TestQueryWorkflow_CustomError() {
+	message := getQueryErrorMessage(NewCustomError("not-ready", "item-1"), getDefaultDataConverter())
+	// the human readable reason comes first
+	s.True(strings.HasPrefix(message, "not-ready\n"))
+	s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, &shared.QueryFailedError{Message: message})
+
+	_, err := s.client.QueryWorkflow(context.Background(), workflowID, runID, "state")
+	customErr, ok := err.(*CustomError)
+	s.True(ok)
+	s.Equal("not-ready", customErr.Reason())
+	var detail string
+	s.NoError(customErr.Details(&detail))
+	s.Equal("item-1", detail)
+
+	// other query failures are returned as they are
+	queryErr := &shared.QueryFailedError{Message: getQueryErrorMessage(errors.New("boom"), getDefaultDataConverter())}
+	s.service.EXPECT().QueryWorkflow(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, queryErr)
+	_, err = s.client.QueryWorkflow(context.Background(), workflowID, runID, "state")
+	s.Equal(queryErr, err)
+}
+
 func serializeEvents(events []*shared.HistoryEvent) *shared.DataBlob {
 
 	blob, _ := serializer.SerializeBatchEvents(events, shared.EncodingTypeThriftRW)
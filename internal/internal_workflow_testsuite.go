@@ -892,6 +892,10 @@ func (env *testWorkflowEnvironmentImpl) GetDataConverter() DataConverter {
 	return env.workerOptions.DataConverter
 }
 
+func (env *testWorkflowEnvironmentImpl) GetDeadlockDetectionTimeout() time.Duration {
+	return env.workerOptions.DeadlockDetectionTimeout
+}
+
 func (env *testWorkflowEnvironmentImpl) GetContextPropagators() []ContextPropagator {
 	return env.workerOptions.ContextPropagators
 }
@@ -1175,6 +1179,7 @@ func (env *testWorkflowEnvironmentImpl) handleLocalActivityResult(result *localA
 	if result.task.retryPolicy != nil && result.err != nil {
 		lar.backoff = getRetryBackoff(result, env.Now())
 		lar.attempt = task.attempt
+		lar.heartbeatDetails, _ = task.getHeartbeat()
 	}
 	task.callback(lar)
 	if env.onLocalActivityCompletedListener != nil {
@@ -30,6 +30,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/cadence/.gen/go/shared"
@@ -459,6 +460,568 @@ func (s *WorkflowTestSuiteUnitTest) Test_WorkflowCancellation() {
 	s.True(ok)
 }
 
+func (s *WorkflowTestSuiteUnitTest) Test_Await() {
+	workflowFn := func(ctx Context) (string, error) {
+		signalCount := 0
+		Go(ctx, func(ctx Context) {
+			ch := GetSignalChannel(ctx, "signal")
+			for {
+				ch.Receive(ctx, nil)
+				signalCount++
+			}
+		})
+
+		if err := Await(ctx, func() bool { return signalCount == 2 }); err != nil {
+			return "", err
+		}
+		ok, err := AwaitWithTimeout(ctx, time.Hour, func() bool { return signalCount == 3 })
+		if err != nil {
+			return "", err
+		}
+		timedOutAt := Now(ctx)
+		ok2, err := AwaitWithTimeout(ctx, time.Hour, func() bool { return signalCount == 2 })
+		return fmt.Sprintf("%v %v %v", ok, ok2, Now(ctx) == timedOutAt), err
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("signal", nil)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("signal", nil)
+	}, 2*time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("false true true", result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_AwaitCancellation() {
+	workflowFn := func(ctx Context) error {
+		ok, err := AwaitWithTimeout(ctx, time.Hour, func() bool { return false })
+		if ok || err == nil {
+			return errors.New("AwaitWithTimeout should fail with the canceled context")
+		}
+		return Await(ctx, func() bool { return false })
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterDelayedCallback(func() {
+		env.CancelWorkflow()
+	}, time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	_, ok := env.GetWorkflowError().(*CanceledError)
+	s.True(ok)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_SignalHandler() {
+	workflowFn := func(ctx Context) (string, error) {
+		var items []string
+		if err := Sleep(ctx, 2*time.Minute); err != nil {
+			return "", err
+		}
+		// the signals received before the handler is set are delivered as well
+		err := SetSignalHandler(ctx, "add", func(ctx Context, item string) {
+			_ = Sleep(ctx, time.Second)
+			items = append(items, item)
+		})
+		if err != nil {
+			return "", err
+		}
+		var other string
+		GetSignalChannel(ctx, "other").Receive(ctx, &other)
+		if err := DrainSignals(ctx); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v %s", items, other), nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("add", "a")
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("add", "b")
+		env.SignalWorkflow("other", "c")
+	}, 3*time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("[a b] c", result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_SignalHandlerDoesNotBlockNextSignal() {
+	workflowFn := func(ctx Context) ([]string, error) {
+		var handled []string
+		err := SetSignalHandler(ctx, "add", func(ctx Context, item string) {
+			if item == "slow" {
+				_ = Sleep(ctx, time.Hour)
+			}
+			handled = append(handled, item)
+		})
+		if err != nil {
+			return nil, err
+		}
+		GetSignalChannel(ctx, "done").Receive(ctx, nil)
+		if err := DrainSignals(ctx); err != nil {
+			return nil, err
+		}
+		return handled, nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("add", "slow")
+		env.SignalWorkflow("add", "fast")
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow("done", nil)
+	}, 2*time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var handled []string
+	s.NoError(env.GetWorkflowResult(&handled))
+	s.Equal([]string{"fast", "slow"}, handled)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_SignalHandlerValidation() {
+	workflowFn := func(ctx Context) error {
+		if err := SetSignalHandler(ctx, "s", "not a function"); err == nil {
+			return errors.New("expected error for non function handler")
+		}
+		if err := SetSignalHandler(ctx, "s", func(item string) {}); err == nil {
+			return errors.New("expected error for handler without context")
+		}
+		if err := SetSignalHandler(ctx, "s", func(ctx Context) error { return nil }); err == nil {
+			return errors.New("expected error for handler with return value")
+		}
+		if err := SetSignalHandler(ctx, "s", func(ctx Context, a, b string) {}); err == nil {
+			return errors.New("expected error for handler with two input parameters")
+		}
+		return SetSignalHandler(ctx, "s", func(ctx Context) {})
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_UpdateHandler() {
+	workflowFn := func(ctx Context) (int, error) {
+		total := 0
+		err := SetUpdateHandlerWithOptions(ctx, "add", func(ctx Context, n int) (int, error) {
+			if err := Sleep(ctx, time.Minute); err != nil {
+				return 0, err
+			}
+			if n == 13 {
+				return 0, NewCustomError("unlucky")
+			}
+			total += n
+			return total, nil
+		}, UpdateHandlerOptions{
+			Validator: func(n int) error {
+				if n < 0 {
+					return errors.New("negative")
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+		GetSignalChannel(ctx, "done").Receive(ctx, nil)
+		if err := DrainSignals(ctx); err != nil {
+			return 0, err
+		}
+		return total, nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	newRequest := func(id string, n int) updateRequest {
+		args, err := encodeArgs(nil, []interface{}{n})
+		s.NoError(err)
+		return updateRequest{ID: id, Name: "add", Args: args}
+	}
+	query := func(queryType string, arg interface{}) *updateOutcome {
+		value, err := env.QueryWorkflow(queryType, arg)
+		s.NoError(err)
+		var outcome updateOutcome
+		s.NoError(value.Get(&outcome))
+		return &outcome
+	}
+	env.RegisterDelayedCallback(func() {
+		s.Equal(&updateOutcome{Completed: true, Rejection: "negative"}, query(updateValidateQueryType, newRequest("id0", -1)))
+		unknown := newRequest("id0", 1)
+		unknown.Name = "remove"
+		s.Equal(&updateOutcome{Completed: true, Rejection: "unknown update remove"}, query(updateValidateQueryType, unknown))
+		s.Equal(&updateOutcome{}, query(updateValidateQueryType, newRequest("id1", 5)))
+
+		env.SignalWorkflow(updateSignalName, newRequest("id1", 5))
+		env.SignalWorkflow(updateSignalName, newRequest("id1", 5)) // duplicate is ignored
+		env.SignalWorkflow(updateSignalName, newRequest("id2", 13))
+		env.SignalWorkflow(updateSignalName, newRequest("id3", -1))
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		s.Equal(&updateOutcome{}, query(updateResultQueryType, "id1"))
+		s.Equal(&updateOutcome{Completed: true, Rejection: "negative"}, query(updateResultQueryType, "id3"))
+	}, time.Minute+time.Second)
+	env.RegisterDelayedCallback(func() {
+		result, err := encodeArg(nil, 5)
+		s.NoError(err)
+		s.Equal(&updateOutcome{Completed: true, Result: result}, query(updateResultQueryType, "id1"))
+		s.Equal("unlucky", query(updateResultQueryType, "id2").ErrReason)
+		env.SignalWorkflow("done", nil)
+	}, 3*time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result int
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal(5, result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_UpdateHandlerEvictsOldOutcomes() {
+	workflowFn := func(ctx Context) error {
+		err := SetUpdateHandler(ctx, "add", func(ctx Context, n int) error { return nil })
+		if err != nil {
+			return err
+		}
+		GetSignalChannel(ctx, "done").Receive(ctx, nil)
+		return nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	query := func(updateID string) *updateOutcome {
+		value, err := env.QueryWorkflow(updateResultQueryType, updateID)
+		s.NoError(err)
+		var outcome updateOutcome
+		s.NoError(value.Get(&outcome))
+		return &outcome
+	}
+	// unknown updates are rejected, which completes them right away
+	sendUpdates := func(from, to int) {
+		for i := from; i < to; i++ {
+			env.SignalWorkflow(updateSignalName, updateRequest{ID: fmt.Sprintf("id%d", i), Name: "remove"})
+		}
+	}
+	// the signals are sent by two callbacks as the test environment buffers at most 1000 callbacks at a time
+	env.RegisterDelayedCallback(func() {
+		sendUpdates(0, maxRetainedUpdateOutcomes/2)
+	}, time.Minute)
+	env.RegisterDelayedCallback(func() {
+		sendUpdates(maxRetainedUpdateOutcomes/2, maxRetainedUpdateOutcomes+1)
+	}, time.Minute+time.Second)
+	env.RegisterDelayedCallback(func() {
+		s.Equal(&updateOutcome{Evicted: true}, query("id0"))
+		s.Equal(&updateOutcome{}, query("unknown"))
+		s.True(query("id1").Completed)
+		s.True(query(fmt.Sprintf("id%d", maxRetainedUpdateOutcomes)).Completed)
+		env.SignalWorkflow("done", nil)
+	}, 2*time.Minute)
+
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_UpdateHandlerValidation() {
+	workflowFn := func(ctx Context) error {
+		if err := SetUpdateHandler(ctx, "u", func(n int) error { return nil }); err == nil {
+			return errors.New("expected error for handler without context")
+		}
+		if err := SetUpdateHandler(ctx, "u", func(ctx Context) {}); err == nil {
+			return errors.New("expected error for handler without error result")
+		}
+		validator := UpdateHandlerOptions{Validator: func(n string) error { return nil }}
+		if err := SetUpdateHandlerWithOptions(ctx, "u", func(ctx Context, n int) error { return nil }, validator); err == nil {
+			return errors.New("expected error for validator with different parameters")
+		}
+		validator = UpdateHandlerOptions{Validator: func(n int) error { return nil }}
+		return SetUpdateHandlerWithOptions(ctx, "u", func(ctx Context, n int) (string, error) { return "", nil }, validator)
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_WithTimeout() {
+	workflowFn := func(ctx Context) (string, error) {
+		start := Now(ctx)
+		timeoutCtx, cancel := WithTimeout(ctx, time.Minute)
+		defer cancel()
+		deadline, ok := timeoutCtx.Deadline()
+		if !ok || !deadline.Equal(start.Add(time.Minute)) {
+			return "", fmt.Errorf("unexpected deadline %v", deadline)
+		}
+		// a later deadline does not extend the parent one
+		laterCtx, cancelLater := WithDeadline(timeoutCtx, start.Add(time.Hour))
+		defer cancelLater()
+		if d, _ := laterCtx.Deadline(); !d.Equal(deadline) {
+			return "", fmt.Errorf("unexpected deadline %v", d)
+		}
+
+		// the sleep is canceled when the deadline expires, the context tells why
+		if _, ok := Sleep(laterCtx, time.Hour).(*CanceledError); !ok {
+			return "", errors.New("sleep is not canceled")
+		}
+		if timeoutCtx.Err() != ErrDeadlineExceeded || laterCtx.Err() != ErrDeadlineExceeded {
+			return "", fmt.Errorf("unexpected error %v", laterCtx.Err())
+		}
+		elapsed := Now(ctx).Sub(start)
+
+		// canceling the context before the deadline cancels the timer
+		canceledCtx, cancelEarly := WithTimeout(ctx, time.Minute)
+		cancelEarly()
+		if canceledCtx.Err() != ErrCanceled {
+			return "", fmt.Errorf("unexpected error %v", canceledCtx.Err())
+		}
+		if err := Sleep(ctx, 2*time.Minute); err != nil {
+			return "", err
+		}
+		return elapsed.String(), nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("1m0s", result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_WithTimeoutCapsTimeouts() {
+	childFn := func(ctx Context) (int32, error) {
+		return GetWorkflowInfo(ctx).ExecutionStartToCloseTimeoutSeconds, nil
+	}
+	activityFn := func(ctx context.Context) (int, error) {
+		info := GetActivityInfo(ctx)
+		return int(info.Deadline.Sub(info.StartedTimestamp).Round(time.Second).Seconds()), nil
+	}
+	workflowFn := func(ctx Context) (string, error) {
+		ctx = WithActivityOptions(ctx, ActivityOptions{
+			ScheduleToStartTimeout: time.Hour,
+			StartToCloseTimeout:    time.Hour,
+		})
+		ctx = WithChildWorkflowOptions(ctx, ChildWorkflowOptions{
+			ExecutionStartToCloseTimeout: time.Hour,
+		})
+		ctx, cancel := WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		var activityTimeout int
+		if err := ExecuteActivity(ctx, activityFn).Get(ctx, &activityTimeout); err != nil {
+			return "", err
+		}
+		var childTimeout int32
+		err := ExecuteChildWorkflow(ctx, childFn).Get(ctx, &childTimeout)
+		return fmt.Sprintf("%d %d", activityTimeout, childTimeout), err
+	}
+	RegisterWorkflow(workflowFn)
+	RegisterWorkflow(childFn)
+	RegisterActivity(activityFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("30 30", result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_WorkflowInfoOnStartAndMemo() {
+	workflowFn := func(ctx Context) (string, error) {
+		info := GetWorkflowInfo(ctx)
+		var owner string
+		if err := GetMemo(ctx, "owner", &owner); err != nil {
+			return "", err
+		}
+		var missing string
+		if err := GetMemo(ctx, "missing", &missing); err == nil {
+			return "", errors.New("missing memo key is found")
+		}
+		return fmt.Sprintf("%v %v %v %v %v %v %v", info.Attempt, *info.CronSchedule, *info.FirstRunID,
+			*info.Initiator, info.ExpirationTime.Unix(), info.ParentWorkflowExecution.ID, owner), nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	initiator := shared.ContinueAsNewInitiatorCronSchedule
+	env.SetWorkflowInfoOnStart(WorkflowInfo{
+		Attempt:                 2,
+		CronSchedule:            common.StringPtr("@every 1h"),
+		FirstRunID:              common.StringPtr("first-run"),
+		Initiator:               &initiator,
+		ExpirationTime:          time.Unix(1000, 0),
+		ParentWorkflowExecution: &WorkflowExecution{ID: "parent"},
+	})
+	s.NoError(env.SetMemoOnStart(map[string]interface{}{"owner": "ops"}))
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal("2 @every 1h first-run CronSchedule 1000 parent ops", result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_TypedSearchAttributes() {
+	customerID := NewKeywordAttr("CustomerId")
+	orderCount := NewIntAttr("OrderCount")
+	deliveryTime := NewDatetimeAttr("DeliveryTime")
+	workflowFn := func(ctx Context) (string, error) {
+		var count int64
+		if err := GetSearchAttribute(ctx, orderCount, &count); err != nil {
+			return "", err
+		}
+		delivery := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+		err := UpsertTypedSearchAttributes(ctx,
+			customerID.ValueSet("customer-1"),
+			orderCount.ValueSet(count+1),
+			deliveryTime.ValueSet(delivery.In(time.FixedZone("UTC+1", 3600))),
+		)
+		if err != nil {
+			return "", err
+		}
+
+		var customer string
+		var deliveryValue time.Time
+		if err := GetSearchAttribute(ctx, customerID, &customer); err != nil {
+			return "", err
+		}
+		if err := GetSearchAttribute(ctx, orderCount, &count); err != nil {
+			return "", err
+		}
+		if err := GetSearchAttribute(ctx, deliveryTime, &deliveryValue); err != nil {
+			return "", err
+		}
+		if !deliveryValue.Equal(delivery) {
+			return "", fmt.Errorf("unexpected delivery time %v", deliveryValue)
+		}
+		if err := GetSearchAttribute(ctx, NewKeywordAttr("Missing"), &customer); err == nil {
+			return "", errors.New("missing search attribute is found")
+		}
+		return fmt.Sprintf("%v %v %v", customer, count, string(GetWorkflowInfo(ctx).SearchAttributes.IndexedFields["DeliveryTime"])), nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	s.NoError(env.SetSearchAttributesOnStart(map[string]interface{}{"OrderCount": 1}))
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var result string
+	s.NoError(env.GetWorkflowResult(&result))
+	s.Equal(`customer-1 2 "2020-01-02T03:04:05.000000006Z"`, result)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_SelectorSelectWithTimeout() {
+	workflowFn := func(ctx Context) ([]string, error) {
+		var history []string
+		ch := NewChannel(ctx)
+		selector := NewSelector(ctx)
+		selector.AddReceive(ch, func(c Channel, more bool) {
+			var v string
+			c.Receive(ctx, &v)
+			history = append(history, v)
+		})
+
+		start := Now(ctx)
+		if selected, err := SelectWithTimeout(ctx, selector, time.Minute); err != nil || selected {
+			return nil, fmt.Errorf("select did not time out: %v", err)
+		}
+		history = append(history, Now(ctx).Sub(start).String())
+
+		Go(ctx, func(ctx Context) {
+			_ = Sleep(ctx, time.Second)
+			ch.Send(ctx, "signal")
+		})
+		if selected, err := SelectWithTimeout(ctx, selector, time.Minute); err != nil || !selected {
+			return nil, fmt.Errorf("select timed out: %v", err)
+		}
+		if selected, err := SelectWithTimeout(ctx, selector, 0); err != nil || selected {
+			return nil, fmt.Errorf("select did not time out: %v", err)
+		}
+		// the timer of the completed select must not fire later on
+		if err := Sleep(ctx, 2*time.Minute); err != nil {
+			return nil, err
+		}
+		return history, nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var history []string
+	s.NoError(env.GetWorkflowResult(&history))
+	s.Equal([]string{"1m0s", "signal"}, history)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_NewRandomAndNewUUID() {
+	workflowFn := func(ctx Context) ([]string, error) {
+		var values []string
+		for i := 0; i < 2; i++ {
+			values = append(values, fmt.Sprintf("%v", NewRandom(ctx).Int63()))
+		}
+		Go(ctx, func(ctx Context) {
+			values = append(values, NewUUID(ctx))
+		})
+		if err := Sleep(ctx, time.Minute); err != nil {
+			return nil, err
+		}
+		values = append(values, NewUUID(ctx))
+		return values, nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	execute := func() []string {
+		env := s.NewTestWorkflowEnvironment()
+		env.ExecuteWorkflow(workflowFn)
+		s.True(env.IsWorkflowCompleted())
+		s.NoError(env.GetWorkflowError())
+		var values []string
+		s.NoError(env.GetWorkflowResult(&values))
+		return values
+	}
+
+	values := execute()
+	s.Equal(4, len(values))
+	s.NotEqual(values[0], values[1])
+	s.NotEqual(values[2], values[3])
+	for _, id := range values[2:] {
+		parsed := uuid.Parse(id)
+		s.NotNil(parsed)
+		v, _ := parsed.Version()
+		s.Equal(uuid.Version(4), v)
+	}
+	// same run, same values
+	s.Equal(values, execute())
+}
+
 func testWorkflowHello(ctx Context) (string, error) {
 	ao := ActivityOptions{
 		ScheduleToStartTimeout: time.Minute,
@@ -1586,6 +2149,53 @@ func (s *WorkflowTestSuiteUnitTest) Test_QueryWorkflow() {
 	verifyStateWithQuery(stateDone)
 }
 
+func (s *WorkflowTestSuiteUnitTest) Test_QueryTypes() {
+	workflowFn := func(ctx Context) error {
+		err := SetQueryHandler(ctx, "state", func(prefix string, verbose bool) (string, error) {
+			return prefix, nil
+		})
+		if err != nil {
+			return err
+		}
+		err = SetQueryHandler(ctx, "not_ready", func() ([]int, error) {
+			return nil, NewCustomError("not-ready", "retry later")
+		})
+		if err != nil {
+			return err
+		}
+		return SetUpdateHandler(ctx, "add", func(ctx Context, n int) (int, error) {
+			return n, nil
+		})
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+
+	value, err := env.QueryWorkflow(QueryTypeQueryTypes)
+	s.NoError(err)
+	var types []QueryTypeInfo
+	s.NoError(value.Get(&types))
+	// the queries the framework uses for updates are not listed
+	s.Equal([]QueryTypeInfo{
+		{Name: QueryTypeOpenSessions, ArgTypes: []string{}, ResultType: "[]*workflow.SessionInfo"},
+		{Name: QueryTypeQueryTypes, ArgTypes: []string{}, ResultType: "[]client.QueryTypeInfo"},
+		{Name: QueryTypeStackTrace, ArgTypes: []string{}, ResultType: "string"},
+		{Name: "not_ready", ArgTypes: []string{}, ResultType: "[]int"},
+		{Name: "state", ArgTypes: []string{"string", "bool"}, ResultType: "string"},
+	}, types)
+
+	_, err = env.QueryWorkflow("not_ready")
+	customErr, ok := err.(*CustomError)
+	s.True(ok)
+	s.Equal("not-ready", customErr.Reason())
+	var detail string
+	s.NoError(customErr.Details(&detail))
+	s.Equal("retry later", detail)
+}
+
 func (s *WorkflowTestSuiteUnitTest) Test_WorkflowWithLocalActivity() {
 	localActivityFn := func(ctx context.Context, name string) (string, error) {
 		return "hello " + name, nil
@@ -2027,8 +2637,6 @@ func (s *WorkflowTestSuiteUnitTest) Test_Channel() {
 				})
 			}
 		}
-
-		return nil
 	}
 
 	RegisterWorkflow(workflowFn)
@@ -2268,6 +2876,143 @@ func (s *WorkflowTestSuiteUnitTest) Test_LocalActivityRetry() {
 	s.Equal(int32(2), result)
 }
 
+func (s *WorkflowTestSuiteUnitTest) Test_LocalActivityRetryPredicate() {
+	localActivityFn := func(ctx context.Context) (int32, error) {
+		info := GetActivityInfo(ctx)
+		if info.Attempt < 1 {
+			return int32(-1), NewCustomError("bad-luck", 1)
+		}
+		return int32(-1), NewCustomError("bad-luck", 2)
+	}
+
+	var attempts []int32
+	workflowFn := func(ctx Context) (int32, error) {
+		lao := LocalActivityOptions{
+			ScheduleToCloseTimeout: time.Minute,
+			RetryPolicy: &RetryPolicy{
+				MaximumAttempts:    5,
+				InitialInterval:    time.Second,
+				BackoffCoefficient: 2,
+			},
+			// only the failures with the severity 1 are retried
+			ShouldRetry: func(err error, attempt int32, backoff time.Duration) bool {
+				attempts = append(attempts, attempt)
+				var severity int
+				return err.(*CustomError).Details(&severity) == nil && severity == 1
+			},
+		}
+		ctx = WithLocalActivityOptions(ctx, lao)
+		return int32(-1), ExecuteLocalActivity(ctx, localActivityFn).Get(ctx, nil)
+	}
+
+	env := s.NewTestWorkflowEnvironment()
+	RegisterWorkflow(workflowFn)
+	env.ExecuteWorkflow(workflowFn)
+
+	s.True(env.IsWorkflowCompleted())
+	s.Error(env.GetWorkflowError())
+	s.Equal([]int32{0, 1}, attempts)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_LocalActivityHeartbeatDetails() {
+	localActivityFn := func(ctx context.Context) (int, error) {
+		processed := 0
+		if HasHeartbeatDetails(ctx) {
+			// resume from the progress recorded by the failed attempt
+			if err := GetHeartbeatDetails(ctx, &processed); err != nil {
+				return 0, err
+			}
+		}
+		// the result counts the rows processed by this attempt only
+		rows := 0
+		for processed < 10 {
+			if processed == 5 && GetActivityInfo(ctx).Attempt == 0 {
+				return 0, errors.New("connection lost")
+			}
+			processed++
+			rows++
+			RecordActivityHeartbeat(ctx, processed)
+		}
+		return rows, nil
+	}
+
+	workflowFn := func(ctx Context) (int, error) {
+		lao := LocalActivityOptions{
+			ScheduleToCloseTimeout: time.Hour,
+			RetryPolicy: &RetryPolicy{
+				MaximumAttempts:    3,
+				InitialInterval:    time.Second,
+				BackoffCoefficient: 2,
+			},
+		}
+		ctx = WithLocalActivityOptions(ctx, lao)
+		var processed int
+		err := ExecuteLocalActivity(ctx, localActivityFn).Get(ctx, &processed)
+		return processed, err
+	}
+
+	env := s.NewTestWorkflowEnvironment()
+	RegisterWorkflow(workflowFn)
+	env.ExecuteWorkflow(workflowFn)
+
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var processed int
+	s.NoError(env.GetWorkflowResult(&processed))
+	s.Equal(5, processed)
+}
+
+func (s *WorkflowTestSuiteUnitTest) Test_Retry() {
+	var attempts []string
+	workflowFn := func(ctx Context) (string, error) {
+		start := Now(ctx)
+		options := RetryOptions{
+			InitialInterval: time.Second,
+			MaximumAttempts: 5,
+			ShouldRetry: func(err error, attempt int32, backoff time.Duration) bool {
+				attempts = append(attempts, fmt.Sprintf("%v:%v:%v", err.(*CustomError).Reason(), attempt, backoff))
+				return err.(*CustomError).Reason() != "fatal"
+			},
+		}
+		count := 0
+		err := Retry(ctx, options, func(ctx Context) error {
+			count++
+			if count < 3 {
+				return NewCustomError("transient")
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		elapsed := Now(ctx).Sub(start)
+
+		err = Retry(ctx, options, func(ctx Context) error {
+			return NewCustomError("fatal")
+		})
+		if customErr, ok := err.(*CustomError); !ok || customErr.Reason() != "fatal" {
+			return "", fmt.Errorf("unexpected error %v", err)
+		}
+
+		if err := Retry(ctx, RetryOptions{InitialInterval: time.Second}, func(ctx Context) error {
+			return nil
+		}); err == nil {
+			return "", errors.New("retry options without limit are accepted")
+		}
+		return elapsed.String(), nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	var elapsed string
+	s.NoError(env.GetWorkflowResult(&elapsed))
+	s.Equal("3s", elapsed)
+	s.Equal([]string{"transient:0:1s", "transient:1:2s", "fatal:0:1s"}, attempts)
+}
+
 func (s *WorkflowTestSuiteUnitTest) Test_LocalActivityRetryOnCancel() {
 	attempts := 0
 	localActivityFn := func(ctx context.Context) (int32, error) {
@@ -0,0 +1,112 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+type (
+	// SagaOptions configure how a Saga runs its compensations.
+	SagaOptions struct {
+		// ParallelCompensation runs all compensations at the same time instead of one after another.
+		// Optional: default false.
+		ParallelCompensation bool
+
+		// ContinueWithError keeps running the remaining compensations when a sequential compensation fails.
+		// Compensations that run in parallel are always all executed.
+		// Optional: default false.
+		ContinueWithError bool
+	}
+
+	// Saga records compensation activities for the steps of a workflow that completed successfully, so they
+	// can be undone when a later step fails. Use NewSaga to create it.
+	// A Saga is not safe for use by multiple coroutines at the same time.
+	Saga struct {
+		options       SagaOptions
+		compensations []*sagaCompensation
+	}
+
+	sagaCompensation struct {
+		activity interface{}
+		args     []interface{}
+	}
+)
+
+// NewSaga creates a Saga for the workflow of ctx.
+//  saga := workflow.NewSaga(ctx, workflow.SagaOptions{})
+//  if err := workflow.ExecuteActivity(ctx, BookHotel, order).Get(ctx, nil); err != nil {
+//      return err
+//  }
+//  saga.AddCompensation(CancelHotel, order)
+//  if err := workflow.ExecuteActivity(ctx, ChargeCard, order).Get(ctx, nil); err != nil {
+//      _ = saga.Compensate(ctx)
+//      return err
+//  }
+func NewSaga(ctx Context, options SagaOptions) *Saga {
+	return &Saga{options: options}
+}
+
+// AddCompensation records an activity, either an activity name or a function, and the arguments it is executed
+// with when the saga is compensated.
+func (s *Saga) AddCompensation(activity interface{}, args ...interface{}) {
+	s.compensations = append(s.compensations, &sagaCompensation{activity: activity, args: args})
+}
+
+// Compensate executes the recorded compensations in the reverse order they were added and clears them.
+// The compensations are scheduled with the activity options of ctx, which must belong to the coroutine calling
+// Compensate. They run in a context disconnected from ctx, so they are executed even when the workflow was canceled.
+// If any compensation failed the returned error is *AggregateError with the errors of the compensations that were
+// executed, in execution order, and a message like "1 of 2 compensations failed: compensation 1: ...".
+func (s *Saga) Compensate(ctx Context) error {
+	ctx, cancel := NewDisconnectedContext(ctx)
+	defer cancel()
+
+	compensations := s.compensations
+	s.compensations = nil
+
+	var errs []error
+	failed := false
+	if s.options.ParallelCompensation {
+		futures := make([]Future, len(compensations))
+		for i := range compensations {
+			c := compensations[len(compensations)-1-i]
+			futures[i] = ExecuteActivity(ctx, c.activity, c.args...)
+		}
+		for _, f := range futures {
+			err := f.Get(ctx, nil)
+			errs = append(errs, err)
+			failed = failed || err != nil
+		}
+	} else {
+		for i := len(compensations) - 1; i >= 0; i-- {
+			c := compensations[i]
+			err := ExecuteActivity(ctx, c.activity, c.args...).Get(ctx, nil)
+			errs = append(errs, err)
+			if err != nil {
+				failed = true
+				if !s.options.ContinueWithError {
+					break
+				}
+			}
+		}
+	}
+	if failed {
+		return &AggregateError{errors: errs, operation: "compensation"}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SagaTestSuite struct {
+	*require.Assertions
+	suite.Suite
+	WorkflowTestSuite
+
+	lock        sync.Mutex
+	compensated []string
+}
+
+func (s *SagaTestSuite) SetupSuite() {
+	RegisterActivityWithOptions(s.undoActivity, RegisterActivityOptions{Name: "sagaUndoActivity"})
+	RegisterWorkflow(sagaTestWorkflow)
+}
+
+func (s *SagaTestSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+	s.compensated = nil
+}
+
+func TestSagaTestSuite(t *testing.T) {
+	suite.Run(t, new(SagaTestSuite))
+}
+
+func (s *SagaTestSuite) undoActivity(step string, fail bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compensated = append(s.compensated, step)
+	if fail {
+		return errors.New("undo " + step + " failed")
+	}
+	return nil
+}
+
+func sagaTestWorkflow(ctx Context, options SagaOptions, failing map[string]bool) error {
+	ctx = WithActivityOptions(ctx, ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+	})
+	saga := NewSaga(ctx, options)
+	for _, step := range []string{"book", "charge", "ship"} {
+		saga.AddCompensation("sagaUndoActivity", step, failing[step])
+	}
+	return saga.Compensate(ctx)
+}
+
+func (s *SagaTestSuite) executeSaga(options SagaOptions, failing map[string]bool) error {
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(sagaTestWorkflow, options, failing)
+	s.True(env.IsWorkflowCompleted())
+	return env.GetWorkflowError()
+}
+
+func (s *SagaTestSuite) TestCompensateSequential() {
+	s.NoError(s.executeSaga(SagaOptions{}, nil))
+	s.Equal([]string{"ship", "charge", "book"}, s.compensated)
+}
+
+func (s *SagaTestSuite) TestCompensateStopsOnError() {
+	err := s.executeSaga(SagaOptions{}, map[string]bool{"charge": true})
+	s.Error(err)
+	s.Contains(err.Error(), "1 of 2 compensations failed: compensation 1: undo charge failed")
+	s.Equal([]string{"ship", "charge"}, s.compensated)
+}
+
+func (s *SagaTestSuite) TestCompensateContinueWithError() {
+	err := s.executeSaga(SagaOptions{ContinueWithError: true}, map[string]bool{"ship": true, "charge": true})
+	s.Error(err)
+	s.Contains(err.Error(), "2 of 3 compensations failed")
+	s.Equal([]string{"ship", "charge", "book"}, s.compensated)
+}
+
+func (s *SagaTestSuite) TestCompensateParallel() {
+	err := s.executeSaga(SagaOptions{ParallelCompensation: true}, map[string]bool{"ship": true})
+	s.Error(err)
+	s.Contains(err.Error(), "1 of 3 compensations failed: compensation 0: undo ship failed")
+	s.ElementsMatch([]string{"ship", "charge", "book"}, s.compensated)
+}
+
+func (s *SagaTestSuite) TestCompensateAfterCancellation() {
+	workflowFn := func(ctx Context) error {
+		ctx = WithActivityOptions(ctx, ActivityOptions{
+			ScheduleToStartTimeout: time.Minute,
+			StartToCloseTimeout:    time.Minute,
+		})
+		saga := NewSaga(ctx, SagaOptions{})
+		saga.AddCompensation("sagaUndoActivity", "book", false)
+		if err := Sleep(ctx, time.Hour); err != nil {
+			if compensateErr := saga.Compensate(ctx); compensateErr != nil {
+				return compensateErr
+			}
+			return err
+		}
+		return nil
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.RegisterDelayedCallback(func() {
+		env.CancelWorkflow()
+	}, time.Minute)
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	_, ok := env.GetWorkflowError().(*CanceledError)
+	s.True(ok)
+	s.Equal([]string{"book"}, s.compensated)
+}
+
+func (s *SagaTestSuite) TestCompensateInAnotherCoroutine() {
+	workflowFn := func(ctx Context) error {
+		ctx = WithActivityOptions(ctx, ActivityOptions{
+			ScheduleToStartTimeout: time.Minute,
+			StartToCloseTimeout:    time.Minute,
+		})
+		saga := NewSaga(ctx, SagaOptions{})
+		saga.AddCompensation("sagaUndoActivity", "book", false)
+		saga.AddCompensation("sagaUndoActivity", "charge", false)
+
+		var compensateErr error
+		done := NewChannel(ctx)
+		Go(ctx, func(ctx Context) {
+			compensateErr = saga.Compensate(ctx)
+			done.Send(ctx, true)
+		})
+		done.Receive(ctx, nil)
+		return compensateErr
+	}
+	RegisterWorkflow(workflowFn)
+
+	env := s.NewTestWorkflowEnvironment()
+	env.ExecuteWorkflow(workflowFn)
+	s.True(env.IsWorkflowCompleted())
+	s.NoError(env.GetWorkflowError())
+	s.Equal([]string{"charge", "book"}, s.compensated)
+}
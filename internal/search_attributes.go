@@ -0,0 +1,323 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	s "go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/internal/common/backoff"
+)
+
+type (
+	// SearchAttributeKey is the name of a search attribute together with the type of its values, as registered on
+	// the cadence server.
+	SearchAttributeKey interface {
+		GetName() string
+		GetValueType() s.IndexedValueType
+	}
+
+	// SearchAttributeUpdate is a value for a search attribute, created by the ValueSet method of a typed key and
+	// passed to UpsertTypedSearchAttributes.
+	SearchAttributeUpdate struct {
+		key   SearchAttributeKey
+		value interface{}
+	}
+
+	// StringAttr is a search attribute key of type String, it is full text searchable.
+	StringAttr struct{ name string }
+	// KeywordAttr is a search attribute key of type Keyword, it is matched as a whole.
+	KeywordAttr struct{ name string }
+	// IntAttr is a search attribute key of type Int.
+	IntAttr struct{ name string }
+	// DoubleAttr is a search attribute key of type Double.
+	DoubleAttr struct{ name string }
+	// BoolAttr is a search attribute key of type Bool.
+	BoolAttr struct{ name string }
+	// DatetimeAttr is a search attribute key of type Datetime.
+	DatetimeAttr struct{ name string }
+
+	// searchAttributesSchema holds the search attributes registered on the server, it is used to validate upserts
+	// when WorkerOptions.ValidateSearchAttributes is set.
+	searchAttributesSchema struct {
+		keys map[string]s.IndexedValueType
+	}
+)
+
+var (
+	_ SearchAttributeKey = StringAttr{}
+	_ SearchAttributeKey = KeywordAttr{}
+	_ SearchAttributeKey = IntAttr{}
+	_ SearchAttributeKey = DoubleAttr{}
+	_ SearchAttributeKey = BoolAttr{}
+	_ SearchAttributeKey = DatetimeAttr{}
+)
+
+// NewStringAttr creates a key for a search attribute of type String.
+func NewStringAttr(name string) StringAttr {
+	return StringAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k StringAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k StringAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeString
+}
+
+// ValueSet creates an update that sets the search attribute to value.
+func (k StringAttr) ValueSet(value string) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value}
+}
+
+// NewKeywordAttr creates a key for a search attribute of type Keyword.
+func NewKeywordAttr(name string) KeywordAttr {
+	return KeywordAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k KeywordAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k KeywordAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeKeyword
+}
+
+// ValueSet creates an update that sets the search attribute to value.
+func (k KeywordAttr) ValueSet(value string) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value}
+}
+
+// ValuesSet creates an update that sets the search attribute to a list of values, each one of them matches.
+func (k KeywordAttr) ValuesSet(values []string) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: values}
+}
+
+// NewIntAttr creates a key for a search attribute of type Int.
+func NewIntAttr(name string) IntAttr {
+	return IntAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k IntAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k IntAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeInt
+}
+
+// ValueSet creates an update that sets the search attribute to value.
+func (k IntAttr) ValueSet(value int64) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value}
+}
+
+// NewDoubleAttr creates a key for a search attribute of type Double.
+func NewDoubleAttr(name string) DoubleAttr {
+	return DoubleAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k DoubleAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k DoubleAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeDouble
+}
+
+// ValueSet creates an update that sets the search attribute to value.
+func (k DoubleAttr) ValueSet(value float64) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value}
+}
+
+// NewBoolAttr creates a key for a search attribute of type Bool.
+func NewBoolAttr(name string) BoolAttr {
+	return BoolAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k BoolAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k BoolAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeBool
+}
+
+// ValueSet creates an update that sets the search attribute to value.
+func (k BoolAttr) ValueSet(value bool) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value}
+}
+
+// NewDatetimeAttr creates a key for a search attribute of type Datetime.
+func NewDatetimeAttr(name string) DatetimeAttr {
+	return DatetimeAttr{name: name}
+}
+
+// GetName returns the name of the search attribute.
+func (k DatetimeAttr) GetName() string {
+	return k.name
+}
+
+// GetValueType returns the type of the search attribute.
+func (k DatetimeAttr) GetValueType() s.IndexedValueType {
+	return s.IndexedValueTypeDatetime
+}
+
+// ValueSet creates an update that sets the search attribute to value. The value is stored in UTC with nanosecond
+// precision, in the RFC3339 format the server expects.
+func (k DatetimeAttr) ValueSet(value time.Time) SearchAttributeUpdate {
+	return SearchAttributeUpdate{key: k, value: value.UTC().Format(time.RFC3339Nano)}
+}
+
+// GetKey returns the key of the update.
+func (u SearchAttributeUpdate) GetKey() SearchAttributeKey {
+	return u.key
+}
+
+// UpsertTypedSearchAttributes is like UpsertSearchAttributes but takes updates created from typed keys, so the
+// values are always of the type registered on the server:
+//  customerID := workflow.NewKeywordAttr("CustomerId")
+//  orderCount := workflow.NewIntAttr("OrderCount")
+//  err := workflow.UpsertTypedSearchAttributes(ctx, customerID.ValueSet("customer-1"), orderCount.ValueSet(3))
+func UpsertTypedSearchAttributes(ctx Context, updates ...SearchAttributeUpdate) error {
+	attributes := make(map[string]interface{}, len(updates))
+	for _, u := range updates {
+		if u.key == nil {
+			return errors.New("search attribute update has no key")
+		}
+		attributes[u.key.GetName()] = u.value
+	}
+	return UpsertSearchAttributes(ctx, attributes)
+}
+
+// GetSearchAttribute decodes the current value of the search attribute key, including the upserts done by the
+// workflow so far, into valuePtr. Datetime values are decoded into time.Time or string. It returns an error when
+// the workflow has no such search attribute.
+//  var orderCount int64
+//  err := workflow.GetSearchAttribute(ctx, workflow.NewIntAttr("OrderCount"), &orderCount)
+func GetSearchAttribute(ctx Context, key SearchAttributeKey, valuePtr interface{}) error {
+	if key == nil {
+		return errors.New("search attribute key is nil")
+	}
+	attributes := GetWorkflowInfo(ctx).SearchAttributes
+	if attributes == nil || attributes.IndexedFields[key.GetName()] == nil {
+		return fmt.Errorf("search attribute %q not found", key.GetName())
+	}
+	return json.Unmarshal(attributes.IndexedFields[key.GetName()], valuePtr)
+}
+
+// load gets the search attributes registered on the server. It is called once by the worker before the workflow
+// worker starts, the worker fails to start when it fails.
+func (sc *searchAttributesSchema) load(service workflowserviceclient.Interface) error {
+	ctx := context.Background()
+	getSearchAttributesOp := func() error {
+		tchCtx, cancel, opt := newChannelContext(ctx)
+		defer cancel()
+		response, err := service.GetSearchAttributes(tchCtx, opt...)
+		if err != nil {
+			return err
+		}
+		sc.keys = response.Keys
+		return nil
+	}
+	return backoff.Retry(ctx, getSearchAttributesOp, createDynamicServiceRetryPolicy(ctx), isServiceTransientError)
+}
+
+// validate checks that the search attributes are registered on the server and the values are of the registered type.
+// Nothing is validated when the schema was not loaded.
+func (sc *searchAttributesSchema) validate(attributes map[string]interface{}) error {
+	if sc.keys == nil {
+		return nil
+	}
+	for key, value := range attributes {
+		if key == CadenceChangeVersion {
+			continue
+		}
+		valueType, ok := sc.keys[key]
+		if !ok {
+			return fmt.Errorf("search attribute %s is not registered on the server", key)
+		}
+		if !isValidSearchAttributeValue(valueType, value) {
+			return fmt.Errorf("search attribute %s of type %v does not accept value %v of type %T", key, valueType, value, value)
+		}
+	}
+	return nil
+}
+
+func isValidSearchAttributeValue(valueType s.IndexedValueType, value interface{}) bool {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return false
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		// a list of values is accepted as long as every value is valid
+		for i := 0; i < v.Len(); i++ {
+			if !isValidSearchAttributeValue(valueType, v.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	switch valueType {
+	case s.IndexedValueTypeString, s.IndexedValueTypeKeyword:
+		return v.Kind() == reflect.String
+	case s.IndexedValueTypeInt:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+	case s.IndexedValueTypeDouble:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+	case s.IndexedValueTypeBool:
+		return v.Kind() == reflect.Bool
+	case s.IndexedValueTypeDatetime:
+		if _, ok := value.(time.Time); ok {
+			return true
+		}
+		if str, ok := value.(string); ok {
+			_, err := time.Parse(time.RFC3339Nano, str)
+			return err == nil
+		}
+	}
+	return false
+}
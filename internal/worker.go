@@ -21,7 +21,6 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -39,6 +38,8 @@ import (
 	"go.uber.org/cadence/.gen/go/cadence/workflowservicetest"
 	"go.uber.org/cadence/.gen/go/shared"
 	"go.uber.org/cadence/internal/common"
+	"go.uber.org/cadence/internal/common/backoff"
+	"go.uber.org/cadence/internal/common/cache"
 	"go.uber.org/zap"
 )
 
@@ -159,6 +160,15 @@ type (
 		// default: false
 		EnableLoggingInReplay bool
 
+		// Optional: Validate the search attributes upserted by workflows.
+		// When set the worker loads the search attributes registered on the server when it starts, and an upsert of an
+		// unknown key or a value of the wrong type fails the decision task on the worker with an error that names the
+		// search attribute, before the decision is sent to the server, and the decision task is retried. The worker
+		// fails to start when the search attributes cannot be loaded. Upserts are not validated while the workflow is
+		// replayed.
+		// default: false
+		ValidateSearchAttributes bool
+
 		// Optional: Disable running workflow workers.
 		// default: false
 		DisableWorkflowWorker bool
@@ -182,6 +192,11 @@ type (
 		// The resolution is seconds. See details about StickyExecution on the comments for DisableStickyExecution.
 		StickyScheduleToStartTimeout time.Duration
 
+		// Optional: Sets the cache which keeps the state of sticky workflow executions for this worker. Create one
+		// with NewStickyWorkflowCache. The same cache can be shared by several workers.
+		// default: the process wide cache sized by SetStickyWorkflowCacheSize
+		StickyWorkflowCache *StickyWorkflowCache
+
 		// Optional: sets context for activity. The context can be used to pass any configuration to activity
 		// like common logger for all activities.
 		BackgroundActivityContext context.Context
@@ -191,6 +206,14 @@ type (
 		// default: NonDeterministicWorkflowPolicyBlockWorkflow, which just logs error but reply nothing back to server
 		NonDeterministicWorkflowPolicy NonDeterministicWorkflowPolicy
 
+		// Optional: Sets the max time workflow code can run without blocking on a workflow primitive like
+		// Future.Get, Channel.Receive or workflow.Sleep. Workflow code that busy loops or blocks on native Go
+		// primitives would otherwise hang the decision task until it times out. When the timeout is hit, the
+		// decision task fails with a PanicError carrying the stack trace of the offending coroutine. The coroutine
+		// itself can't be stopped and keeps running in the background.
+		// default: 0, which means no deadlock detection
+		DeadlockDetectionTimeout time.Duration
+
 		// Optional: Sets DataConverter to customize serialization/deserialization of arguments in Cadence
 		// default: defaultDataConverter, an combination of thriftEncoder and jsonEncoder
 		DataConverter DataConverter
@@ -222,6 +245,110 @@ type (
 		// Optional: Sets opentracing Tracer that is to be used to emit tracing information
 		// default: no tracer - opentracing.NoopTracer
 		Tracer opentracing.Tracer
+
+		// Optional: Sets the rules to inject faults like delays, dropped responses, failed decision tasks, activity
+		// panics or heartbeat failures into the tasks processed by this worker. It is meant to prove in test or
+		// staging environments that workflows survive worker crashes and timeouts, don't use it in production.
+		// default: no fault injection
+		FaultInjectionRules []FaultInjectionRule
+
+		// Optional: Sets how long pollers wait before polling again after failed polls. Which poll errors count
+		// as failures is decided by PollErrorClassifier, the policy gets the error of the last failed poll so it
+		// can back off longer on some errors, like ServiceBusyError, than on others.
+		// default: exponential backoff starting at 20ms and capped at 10s, see NewExponentialPollBackoffPolicy
+		PollBackoffPolicy PollBackoffPolicy
+
+		// Optional: Sets the classifier deciding which poll errors pollers back off on. Poll errors which are not
+		// classified as failures are retried right away.
+		// default: DefaultPollErrorClassifier
+		PollErrorClassifier PollErrorClassifier
+
+		// Optional: Sets the fraction of each poll backoff which is randomized, between 0 and 1, so that workers
+		// don't retry in lockstep after an outage. With 0.2, a 10s backoff turns into a random delay between 8s
+		// and 10s.
+		// default: 0.2, negative values disable jitter
+		PollBackoffJitter float64
+	}
+
+	// PollBackoffPolicy computes how long the pollers of a worker wait before polling again after failed polls.
+	PollBackoffPolicy interface {
+		// ComputeNextDelay returns the delay before the next poll, given the error of the last failed poll, the time
+		// elapsed since the last successful poll and the number of backoffs since then, starting at 0. A negative
+		// delay means no backoff. It is called concurrently by all pollers of a worker.
+		ComputeNextDelay(err error, elapsedTime time.Duration, numAttempts int) time.Duration
+	}
+
+	exponentialPollBackoffPolicy struct {
+		policy *backoff.ExponentialRetryPolicy
+	}
+
+	// PollErrorClassifier returns true if the pollers of a worker should back off on the poll error.
+	PollErrorClassifier func(err error) bool
+
+	// FaultInjectionRule describes a fault injected by a worker into the tasks it processes. A rule matches a task
+	// if all of its non empty scope fields (WorkflowType, ActivityType and TaskList) match the task. If several rules
+	// of the same FaultType match a task, the first one which fires is used.
+	FaultInjectionRule struct {
+		// Required: The kind of fault to inject.
+		Type FaultType
+
+		// Optional: Only inject the fault into tasks of this workflow type. Activity tasks are matched by the type
+		// of the workflow which scheduled them.
+		// default: empty, which matches any workflow type
+		WorkflowType string
+
+		// Optional: Only inject the fault into tasks of this activity type. Such rules never match decision tasks.
+		// default: empty, which matches any activity type
+		ActivityType string
+
+		// Optional: Only inject the fault into tasks polled from this task list.
+		// default: empty, which matches any task list
+		TaskList string
+
+		// Required: The probability, greater than 0 and at most 1, that the fault is injected into a matching task.
+		// Set it to 1 to inject the fault into every matching task.
+		Probability float64
+
+		// Optional: How long matching tasks are delayed, only used by FaultTypeDelay.
+		// default: 0s
+		Delay time.Duration
+	}
+
+	// StickyWorkflowCacheOptions is used to configure a sticky workflow cache created by NewStickyWorkflowCache.
+	StickyWorkflowCacheOptions struct {
+		// Optional: The maximum number of workflow executions kept in the cache.
+		// default: 10K
+		MaxSize int
+
+		// Optional: The budget for the estimated memory of all cached workflow executions. The estimate is based
+		// on the size of the history events processed by an execution and the number of coroutines it keeps
+		// alive. Least recently used executions are evicted when the budget is exceeded.
+		// default: 0, which means no limit
+		MaxMemoryBytes int64
+
+		// Optional: Workflow executions which did not process any decision task for this long are evicted.
+		// default: 0, which means executions never expire
+		IdleTimeout time.Duration
+	}
+
+	// StickyWorkflowCache keeps the state of workflow executions between decision tasks on a worker.
+	// Create one with NewStickyWorkflowCache.
+	StickyWorkflowCache struct {
+		cache cache.Cache
+	}
+
+	// ReplayOptions is used to configure the replay functions which take options, like ReplayWorkflowHistoryWithOptions.
+	ReplayOptions struct {
+		// Optional: The DataConverter of the workers which wrote the history. It needs to be the same to replay
+		// histories with custom encoded, encrypted or offloaded payloads.
+		// default: DefaultDataConverter
+		DataConverter DataConverter
+
+		// Optional: The ID of the workflow which wrote the history. Histories don't contain it, it is needed when the
+		// workflow results depend on it, like the results offloaded by the offloading DataConverter. It is ignored by
+		// ReplayWorkflowExecutionWithOptions, which replays the execution with its own ID.
+		// default: ReplayId
+		WorkflowID string
 	}
 )
 
@@ -247,6 +374,71 @@ const (
 	ReplayDomainName = "ReplayDomain"
 )
 
+// FaultType is an enum for the kind of fault injected by a FaultInjectionRule.
+type FaultType int
+
+const (
+	// FaultTypeInvalid is the zero value of FaultType, rules of this type are rejected when the worker starts.
+	FaultTypeInvalid FaultType = iota
+	// FaultTypeDelay delays the processing of matching decision and activity tasks by FaultInjectionRule.Delay.
+	FaultTypeDelay
+	// FaultTypeDropResponse never reports the result of matching decision and activity tasks back to the server, as
+	// if the worker crashed. Activity tasks are processed and their result dropped, decision tasks are dropped before
+	// they are processed so the cached workflow state stays in sync with the server. The server retries the task
+	// once it times out.
+	FaultTypeDropResponse
+	// FaultTypeFailDecisionTask fails matching decision tasks without processing them, as if the workflow code
+	// panicked. The server retries the decision task.
+	FaultTypeFailDecisionTask
+	// FaultTypeActivityPanic panics matching activity tasks before the activity function is invoked. The
+	// activity fails with a PanicError.
+	FaultTypeActivityPanic
+	// FaultTypeHeartbeatFailure fails the heartbeats of matching activity tasks without sending them to the
+	// server, so the activity eventually times out if it has a heartbeat timeout.
+	FaultTypeHeartbeatFailure
+)
+
+// String returns the name of the fault type.
+func (t FaultType) String() string {
+	switch t {
+	case FaultTypeInvalid:
+		return "Invalid"
+	case FaultTypeDelay:
+		return "Delay"
+	case FaultTypeDropResponse:
+		return "DropResponse"
+	case FaultTypeFailDecisionTask:
+		return "FailDecisionTask"
+	case FaultTypeActivityPanic:
+		return "ActivityPanic"
+	case FaultTypeHeartbeatFailure:
+		return "HeartbeatFailure"
+	}
+	return fmt.Sprintf("FaultType(%d)", int(t))
+}
+
+// NewExponentialPollBackoffPolicy creates a PollBackoffPolicy which starts at initialInterval and doubles with every
+// consecutive failed poll, up to maximumInterval, whatever the poll error.
+func NewExponentialPollBackoffPolicy(initialInterval, maximumInterval time.Duration) PollBackoffPolicy {
+	policy := backoff.NewExponentialRetryPolicy(initialInterval)
+	policy.SetMaximumInterval(maximumInterval)
+	// pollers back off for as long as polls keep failing
+	policy.SetExpirationInterval(backoff.NoInterval)
+	// the jitter is added by the retrier shared by the pollers, see WorkerOptions.PollBackoffJitter
+	policy.SetJitter(0)
+	return &exponentialPollBackoffPolicy{policy: policy}
+}
+
+func (p *exponentialPollBackoffPolicy) ComputeNextDelay(_ error, elapsedTime time.Duration, numAttempts int) time.Duration {
+	return p.policy.ComputeNextDelay(elapsedTime, numAttempts)
+}
+
+// DefaultPollErrorClassifier is the PollErrorClassifier used unless WorkerOptions.PollErrorClassifier is set. It
+// backs off on all errors except the ones which retrying can't fix, like BadRequestError or EntityNotExistsError.
+func DefaultPollErrorClassifier(err error) bool {
+	return isServiceTransientError(err)
+}
+
 // IsReplayDomain checks if the domainName is from replay
 func IsReplayDomain(dn string) bool {
 	return ReplayDomainName == dn
@@ -271,6 +463,14 @@ func NewWorker(
 // Use for testing the backwards compatibility of code changes and troubleshooting workflows in a debugger.
 // The logger is the only optional parameter. Defaults to the noop logger.
 func ReplayWorkflowExecution(ctx context.Context, service workflowserviceclient.Interface, logger *zap.Logger, domain string, execution WorkflowExecution) error {
+	return ReplayWorkflowExecutionWithOptions(ctx, service, logger, domain, execution, ReplayOptions{})
+}
+
+// ReplayWorkflowExecutionWithOptions loads a workflow execution history from the Cadence service and executes a single
+// decision task for it with the given options.
+// Use it to replay executions of workers with a custom DataConverter.
+// The logger is the only optional parameter. Defaults to the noop logger.
+func ReplayWorkflowExecutionWithOptions(ctx context.Context, service workflowserviceclient.Interface, logger *zap.Logger, domain string, execution WorkflowExecution, options ReplayOptions) error {
 	sharedExecution := &shared.WorkflowExecution{
 		RunId:      common.StringPtr(execution.RunID),
 		WorkflowId: common.StringPtr(execution.ID),
@@ -293,13 +493,21 @@ func ReplayWorkflowExecution(ctx context.Context, service workflowserviceclient.
 		hResponse.History = history
 	}
 
-	return replayWorkflowHistory(logger, service, domain, hResponse.History)
+	options.WorkflowID = execution.ID
+	return replayWorkflowHistory(logger, service, domain, hResponse.History, options)
 }
 
 // ReplayWorkflowHistory executes a single decision task for the given history.
 // Use for testing the backwards compatibility of code changes and troubleshooting workflows in a debugger.
 // The logger is an optional parameter. Defaults to the noop logger.
 func ReplayWorkflowHistory(logger *zap.Logger, history *shared.History) error {
+	return ReplayWorkflowHistoryWithOptions(logger, history, ReplayOptions{})
+}
+
+// ReplayWorkflowHistoryWithOptions executes a single decision task for the given history with the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayWorkflowHistoryWithOptions(logger *zap.Logger, history *shared.History, options ReplayOptions) error {
 
 	if logger == nil {
 		logger = zap.NewNop()
@@ -309,7 +517,7 @@ func ReplayWorkflowHistory(logger *zap.Logger, history *shared.History) error {
 	controller := gomock.NewController(testReporter)
 	service := workflowservicetest.NewMockClient(controller)
 
-	return replayWorkflowHistory(logger, service, ReplayDomainName, history)
+	return replayWorkflowHistory(logger, service, ReplayDomainName, history, options)
 }
 
 // ReplayWorkflowHistoryFromJSONFile executes a single decision task for the given json history file.
@@ -325,6 +533,22 @@ func ReplayWorkflowHistoryFromJSONFile(logger *zap.Logger, jsonfileName string)
 // Use for testing the backwards compatibility of code changes and troubleshooting workflows in a debugger.
 // The logger is an optional parameter. Defaults to the noop logger.
 func ReplayPartialWorkflowHistoryFromJSONFile(logger *zap.Logger, jsonfileName string, lastEventID int64) error {
+	return ReplayPartialWorkflowHistoryFromJSONFileWithOptions(logger, jsonfileName, lastEventID, ReplayOptions{})
+}
+
+// ReplayWorkflowHistoryFromJSONFileWithOptions executes a single decision task for the given json history file with
+// the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayWorkflowHistoryFromJSONFileWithOptions(logger *zap.Logger, jsonfileName string, options ReplayOptions) error {
+	return ReplayPartialWorkflowHistoryFromJSONFileWithOptions(logger, jsonfileName, 0, options)
+}
+
+// ReplayPartialWorkflowHistoryFromJSONFileWithOptions executes a single decision task for the given json history file
+// upto provided lastEventID(inclusive) with the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayPartialWorkflowHistoryFromJSONFileWithOptions(logger *zap.Logger, jsonfileName string, lastEventID int64, options ReplayOptions) error {
 
 	history, err := extractHistoryFromFile(jsonfileName, lastEventID)
 
@@ -340,10 +564,11 @@ func ReplayPartialWorkflowHistoryFromJSONFile(logger *zap.Logger, jsonfileName s
 	controller := gomock.NewController(testReporter)
 	service := workflowservicetest.NewMockClient(controller)
 
-	return replayWorkflowHistory(logger, service, ReplayDomainName, history)
+	return replayWorkflowHistory(logger, service, ReplayDomainName, history, options)
 }
 
-func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Interface, domain string, history *shared.History) error {
+func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Interface, domain string, history *shared.History,
+	options ReplayOptions) error {
 	taskList := "ReplayTaskList"
 	events := history.Events
 	if events == nil {
@@ -367,6 +592,9 @@ func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Int
 		RunId:      common.StringPtr(uuid.NewRandom().String()),
 		WorkflowId: common.StringPtr("ReplayId"),
 	}
+	if options.WorkflowID != "" {
+		execution.WorkflowId = common.StringPtr(options.WorkflowID)
+	}
 	if first.WorkflowExecutionStartedEventAttributes.GetOriginalExecutionRunId() != "" {
 		execution.RunId = common.StringPtr(first.WorkflowExecutionStartedEventAttributes.GetOriginalExecutionRunId())
 	}
@@ -393,9 +621,10 @@ func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Int
 		maxEventID:    task.GetStartedEventId(),
 	}
 	params := workerExecutionParameters{
-		TaskList: taskList,
-		Identity: "replayID",
-		Logger:   logger,
+		TaskList:      taskList,
+		Identity:      "replayID",
+		Logger:        logger,
+		DataConverter: options.DataConverter,
 	}
 	taskHandler := newWorkflowTaskHandler(domain, params, nil, getHostEnvironment())
 	resp, err := taskHandler.ProcessWorkflowTask(&workflowTask{task: task, historyIterator: iterator}, nil)
@@ -406,6 +635,10 @@ func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Int
 	if last.GetEventType() != shared.EventTypeWorkflowExecutionCompleted && last.GetEventType() != shared.EventTypeWorkflowExecutionContinuedAsNew {
 		return nil
 	}
+	dataConverter := options.DataConverter
+	if dataConverter == nil {
+		dataConverter = getDefaultDataConverter()
+	}
 	err = fmt.Errorf("replay workflow doesn't return the same result as the last event, resp: %v, last: %v", resp, last)
 	if resp != nil {
 		completeReq, ok := resp.(*shared.RespondDecisionTaskCompletedRequest)
@@ -415,7 +648,7 @@ func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Int
 					if last.GetEventType() == shared.EventTypeWorkflowExecutionContinuedAsNew {
 						inputA := d.ContinueAsNewWorkflowExecutionDecisionAttributes.Input
 						inputB := last.WorkflowExecutionContinuedAsNewEventAttributes.Input
-						if bytes.Compare(inputA, inputB) == 0 {
+						if isSamePayload(dataConverter, inputA, inputB) {
 							return nil
 						}
 					}
@@ -424,7 +657,7 @@ func replayWorkflowHistory(logger *zap.Logger, service workflowserviceclient.Int
 					if last.GetEventType() == shared.EventTypeWorkflowExecutionCompleted {
 						resultA := last.WorkflowExecutionCompletedEventAttributes.Result
 						resultB := d.CompleteWorkflowExecutionDecisionAttributes.Result
-						if bytes.Compare(resultA, resultB) == 0 {
+						if isSamePayload(dataConverter, resultA, resultB) {
 							return nil
 						}
 					}
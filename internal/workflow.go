@@ -23,12 +23,16 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"strings"
 	"time"
 
+	"github.com/pborman/uuid"
 	"github.com/uber-go/tally"
 	s "go.uber.org/cadence/.gen/go/shared"
 	"go.uber.org/cadence/internal/common"
+	"go.uber.org/cadence/internal/common/backoff"
 	"go.uber.org/zap"
 )
 
@@ -72,9 +76,13 @@ type (
 
 	// Selector must be used instead of native go select by workflow code.
 	// Use workflow.NewSelector(ctx) method to create a Selector instance.
+	// The cases of futures stay in the Selector after they were selected, so a Selector that is reused
+	// for many futures grows unless the selected ones are removed with RemoveSelectorFuture.
 	Selector interface {
 		AddReceive(c Channel, f func(c Channel, more bool)) Selector
 		AddSend(c Channel, v interface{}, f func()) Selector
+		// AddFuture adds a case which is selected once when the future is ready. The case is not removed
+		// after it was selected, use RemoveSelectorFuture to drop it from a long-lived Selector.
 		AddFuture(future Future, f func(f Future)) Selector
 		AddDefault(f func())
 		Select(ctx Context)
@@ -89,6 +97,40 @@ type (
 		Wait(ctx Context)
 	}
 
+	// Mutex must be used instead of native go sync.Mutex by workflow code. Use workflow.NewMutex(ctx) method to
+	// create a new Mutex instance. Coroutines blocked on Lock acquire the Mutex in the order they called Lock.
+	Mutex interface {
+		// Lock blocks until the Mutex is acquired. It returns *CanceledError without acquiring the Mutex if the
+		// ctx is canceled while waiting.
+		Lock(ctx Context) error
+
+		// TryLock acquires the Mutex and returns true if it is not locked and nobody waits for it, otherwise it
+		// returns false without blocking.
+		TryLock() bool
+
+		// Unlock releases the Mutex. It panics if the Mutex is not locked.
+		Unlock()
+
+		// IsLocked returns true if the Mutex is locked.
+		IsLocked() bool
+	}
+
+	// Semaphore must be used instead of native go semaphores by workflow code to limit the number of coroutines
+	// doing something concurrently. Use workflow.NewSemaphore(ctx, size) method to create a new Semaphore
+	// instance. Coroutines blocked on Acquire get their permits in the order they called Acquire.
+	Semaphore interface {
+		// Acquire blocks until n permits are acquired. It returns *CanceledError without acquiring any permit if
+		// the ctx is canceled while waiting. It panics if n is not positive or exceeds the size of the Semaphore.
+		Acquire(ctx Context, n int) error
+
+		// TryAcquire acquires n permits and returns true if they are available and nobody waits for permits,
+		// otherwise it returns false without blocking.
+		TryAcquire(n int) bool
+
+		// Release releases n permits. It panics if more permits are released than acquired.
+		Release(n int)
+	}
+
 	// Future represents the result of an asynchronous computation.
 	Future interface {
 		// Get blocks until the future is ready. When ready it either returns non nil error or assigns result value to
@@ -300,12 +342,114 @@ func NewNamedSelector(ctx Context, name string) Selector {
 	return &selectorImpl{name: name}
 }
 
+// SelectWithTimeout is like Selector.Select but gives up when none of the cases is ready within the timeout. It
+// returns true if a case (or the default) was selected, and false if the timeout expired or ctx was canceled first.
+// A timer is only started when no case is ready, and it is canceled as soon as a case is selected.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func SelectWithTimeout(ctx Context, selector Selector, timeout time.Duration) (bool, error) {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return false, err
+	}
+	return impl.selectWithTimeout(ctx, timeout), nil
+}
+
+// SelectorHasPending returns true when one of the cases of the selector, not counting the default, is ready and
+// Select would not block. An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func SelectorHasPending(selector Selector) (bool, error) {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return false, err
+	}
+	return impl.hasPending(), nil
+}
+
+// RemoveSelectorReceive removes the receive cases of the channel from the selector, so the Selector can be reused
+// with other cases. To replace the function of a case remove it and add it again.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorReceive(selector Selector, c Channel) error {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return err
+	}
+	impl.removeReceive(c)
+	return nil
+}
+
+// RemoveSelectorSend removes the send cases of the channel from the selector.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorSend(selector Selector, c Channel) error {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return err
+	}
+	impl.removeSend(c)
+	return nil
+}
+
+// RemoveSelectorFuture removes the case of the future from the selector. Cases of futures that were already
+// selected are kept by the Selector, so a long-lived Selector should remove them to not grow without bounds.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorFuture(selector Selector, future Future) error {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return err
+	}
+	impl.removeFuture(future)
+	return nil
+}
+
+// RemoveSelectorDefault removes the default case from the selector.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorDefault(selector Selector) error {
+	impl, err := getSelectorImpl(selector)
+	if err != nil {
+		return err
+	}
+	impl.removeDefault()
+	return nil
+}
+
+func getSelectorImpl(selector Selector) (*selectorImpl, error) {
+	impl, ok := selector.(*selectorImpl)
+	if !ok {
+		return nil, errors.New("Selector wasn't created with workflow.NewSelector")
+	}
+	return impl, nil
+}
+
 // NewWaitGroup creates a new WaitGroup instance.
 func NewWaitGroup(ctx Context) WaitGroup {
 	f, s := NewFuture(ctx)
 	return &waitGroupImpl{future: f, settable: s}
 }
 
+// NewMutex creates a new Mutex instance.
+func NewMutex(ctx Context) Mutex {
+	state := getState(ctx)
+	state.dispatcher.mutexSequence++
+	return NewNamedMutex(ctx, fmt.Sprintf("mutex-%v", state.dispatcher.mutexSequence))
+}
+
+// NewNamedMutex creates a new Mutex instance with a given human readable name.
+// Name appears in stack traces that are blocked on this Mutex.
+func NewNamedMutex(ctx Context, name string) Mutex {
+	return &mutexImpl{semaphore: newSemaphore(name, 1)}
+}
+
+// NewSemaphore creates a new Semaphore instance with the given number of permits.
+func NewSemaphore(ctx Context, size int) Semaphore {
+	state := getState(ctx)
+	state.dispatcher.semaphoreSequence++
+	return NewNamedSemaphore(ctx, fmt.Sprintf("semaphore-%v", state.dispatcher.semaphoreSequence), size)
+}
+
+// NewNamedSemaphore creates a new Semaphore instance with a given human readable name and number of permits.
+// Name appears in stack traces that are blocked on this Semaphore.
+func NewNamedSemaphore(ctx Context, name string, size int) Semaphore {
+	return newSemaphore(name, size)
+}
+
 // Go creates a new coroutine. It has similar semantic to goroutine in a context of the workflow.
 func Go(ctx Context, f func(ctx Context)) {
 	state := getState(ctx)
@@ -326,6 +470,224 @@ func NewFuture(ctx Context) (Future, Settable) {
 	return impl, impl
 }
 
+// AllOf returns a Future that becomes ready when all of the given futures are ready.
+// Its value is a slice holding the results of the futures in the order they were passed in:
+//  var results []string
+//  err := workflow.AllOf(ctx, f1, f2, f3).Get(ctx, &results)
+// If any of the futures failed the returned error is *AggregateError that contains the errors of all futures, the
+// results of the futures that succeeded are still assigned to the slice.
+func AllOf(ctx Context, futures ...Future) Future {
+	impl := &allOfFutureImpl{
+		futureImpl: &futureImpl{channel: NewChannel(ctx).(*channelImpl)},
+		futures:    futures,
+	}
+	GoNamed(ctx, "AllOf", func(ctx Context) {
+		errs := make([]error, len(futures))
+		failed := false
+		for i, f := range futures {
+			if errs[i] = f.Get(ctx, nil); errs[i] != nil {
+				failed = true
+			}
+		}
+		if failed {
+			impl.Set(nil, &AggregateError{errors: errs})
+			return
+		}
+		impl.Set(nil, nil)
+	})
+	return impl
+}
+
+// AnyOf returns a Future that becomes ready when the first of the given futures completes successfully. Its value
+// (or error) is the same as of that future. If all of the futures failed the returned error is *AggregateError that
+// contains the errors of all futures.
+// When several futures are ready at the same time the one that was passed in first wins.
+// Futures that weren't created by the workflow package are supported as well, the value of such a future is what
+// its Get assigns to an *interface{}.
+// AnyOf without any futures returns a Future that fails immediately.
+func AnyOf(ctx Context, futures ...Future) Future {
+	future, settable := NewFuture(ctx)
+	if len(futures) == 0 {
+		settable.SetError(errors.New("AnyOf requires at least one future"))
+		return future
+	}
+	GoNamed(ctx, "AnyOf", func(ctx Context) {
+		errs := make([]error, len(futures))
+		selector := NewSelector(ctx)
+		for i := range futures {
+			i := i
+			selector.AddFuture(toAsyncFuture(ctx, futures[i]), func(f Future) {
+				if errs[i] = f.Get(ctx, nil); errs[i] == nil && !future.IsReady() {
+					settable.Set(f.(asyncFuture).GetValueAndError())
+				}
+			})
+		}
+		for range futures {
+			selector.Select(ctx)
+			if future.IsReady() {
+				return
+			}
+		}
+		settable.SetError(&AggregateError{errors: errs})
+	})
+	return future
+}
+
+// toAsyncFuture returns a future that a Selector can wait on. Other implementations of Future are waited on in a
+// coroutine, which copies their result into a new future.
+func toAsyncFuture(ctx Context, f Future) asyncFuture {
+	if asyncF, ok := f.(asyncFuture); ok {
+		return asyncF
+	}
+	future, settable := NewFuture(ctx)
+	GoNamed(ctx, "AnyOf-future", func(ctx Context) {
+		var value interface{}
+		err := f.Get(ctx, &value)
+		settable.Set(value, err)
+	})
+	return future.(asyncFuture)
+}
+
+// ForEachParallel calls fn for every element of items, which must be a slice, and waits for the Futures returned
+// by fn to become ready. At most maxConcurrency Futures are in flight at the same time, a non positive
+// maxConcurrency means no limit. Elements are started in the order of the slice.
+// When one of the Futures fails (or ctx is canceled) no further elements are started and the context passed to fn
+// is canceled, which cancels the activities and child workflows that are still running. ForEachParallel waits for
+// the in flight Futures to complete and returns the first error.
+//  err := workflow.ForEachParallel(ctx, files, 10, func(ctx workflow.Context, item interface{}) workflow.Future {
+//      return workflow.ExecuteActivity(ctx, ProcessFile, item.(string))
+//  })
+func ForEachParallel(ctx Context, items interface{}, maxConcurrency int, fn func(ctx Context, item interface{}) Future) error {
+	values := reflect.ValueOf(items)
+	if values.Kind() != reflect.Slice {
+		panic(fmt.Sprintf("ForEachParallel: items must be a slice, got %T", items))
+	}
+	ctx, cancel := WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	selector := NewSelector(ctx)
+	next, inFlight := 0, 0
+	for {
+		if firstErr == nil && ctx.Err() != nil {
+			firstErr = ctx.Err()
+		}
+		for firstErr == nil && next < values.Len() && (maxConcurrency <= 0 || inFlight < maxConcurrency) {
+			future := fn(ctx, values.Index(next).Interface())
+			next++
+			inFlight++
+			selector.AddFuture(future, func(f Future) {
+				inFlight--
+				if err := f.Get(ctx, nil); err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+			})
+		}
+		if inFlight == 0 {
+			return firstErr
+		}
+		selector.Select(ctx)
+	}
+}
+
+// RetryPredicate decides whether to retry after a failure. It gets the error of the failed attempt, the attempt
+// number starting from 0 and the backoff before the next attempt, and returns false to stop retrying.
+type RetryPredicate func(err error, attempt int32, backoff time.Duration) bool
+
+// RetryOptions configure how Retry retries a function.
+type RetryOptions struct {
+	// Backoff interval for the first retry. If coefficient is 1.0 then it is used for all retries.
+	// Required, no default value.
+	InitialInterval time.Duration
+
+	// Coefficient used to calculate the next retry backoff interval.
+	// The next retry interval is previous interval multiplied by this coefficient.
+	// Must be 1 or larger. Default is 2.0.
+	BackoffCoefficient float64
+
+	// Maximum backoff interval between retries. Exponential backoff leads to interval increase.
+	// This value is the cap of the interval. Default is 100x of initial interval.
+	MaximumInterval time.Duration
+
+	// Maximum time to retry, measured from the call to Retry. Either ExpirationInterval or MaximumAttempts is required.
+	// When exceeded the retries stop even if maximum retries is not reached yet.
+	ExpirationInterval time.Duration
+
+	// Maximum number of attempts. When exceeded the retries stop even if not expired yet.
+	// If not set or set to 0, it means unlimited, and rely on ExpirationInterval to stop.
+	// Either MaximumAttempts or ExpirationInterval is required.
+	MaximumAttempts int32
+
+	// ShouldRetry decides whether a failure is retried, it can look at the typed error, for example the reason and
+	// details of a CustomError. It runs in the workflow so it must be deterministic.
+	// Optional: default retries every error.
+	ShouldRetry RetryPredicate
+}
+
+// Retry calls fn until it succeeds, backing off between the attempts with durable timers, so the retries survive
+// worker restarts and show up in the history. Cancellation is not a failure, so it is not retried. Retry returns nil
+// once fn succeeds, and otherwise the error of the last attempt, or CanceledError when ctx is canceled while backing
+// off.
+//  err := workflow.Retry(ctx, workflow.RetryOptions{
+//      InitialInterval: time.Second,
+//      MaximumAttempts: 5,
+//      ShouldRetry: func(err error, attempt int32, backoff time.Duration) bool {
+//          customErr, ok := err.(*workflow.CustomError)
+//          return !ok || customErr.Reason() != "invalid-input"
+//      },
+//  }, func(ctx workflow.Context) error {
+//      return workflow.ExecuteActivity(ctx, ChargeCard, order).Get(ctx, nil)
+//  })
+func Retry(ctx Context, options RetryOptions, fn func(ctx Context) error) error {
+	if options.InitialInterval <= 0 {
+		return errors.New("missing or negative InitialInterval on retry options")
+	}
+	if options.MaximumAttempts < 0 || options.ExpirationInterval < 0 || options.MaximumInterval < 0 {
+		return errors.New("negative MaximumAttempts, ExpirationInterval or MaximumInterval on retry options is invalid")
+	}
+	if options.MaximumAttempts == 0 && options.ExpirationInterval == 0 {
+		return errors.New("both MaximumAttempts and ExpirationInterval on retry options are not set, at least one of them must be set")
+	}
+	policy := &RetryPolicy{
+		InitialInterval:    options.InitialInterval,
+		BackoffCoefficient: options.BackoffCoefficient,
+		MaximumInterval:    options.MaximumInterval,
+		ExpirationInterval: options.ExpirationInterval,
+		MaximumAttempts:    options.MaximumAttempts,
+	}
+	if policy.BackoffCoefficient == 0 {
+		policy.BackoffCoefficient = backoff.DefaultBackoffCoefficient
+	}
+	if policy.BackoffCoefficient < 1 {
+		return errors.New("BackoffCoefficient on retry options cannot be less than 1.0")
+	}
+	if policy.MaximumInterval == 0 {
+		policy.MaximumInterval = 100 * policy.InitialInterval
+	}
+	var expireTime time.Time
+	if policy.ExpirationInterval > 0 {
+		expireTime = Now(ctx).Add(policy.ExpirationInterval)
+	}
+
+	for attempt := int32(0); ; attempt++ {
+		err := fn(ctx)
+		if err == nil || IsCanceledError(err) || ctx.Err() != nil {
+			return err
+		}
+		retryBackoff := getRetryBackoffWithNowTime(policy, attempt, "", Now(ctx), expireTime)
+		if retryBackoff == noRetryBackoff {
+			return err
+		}
+		if options.ShouldRetry != nil && !options.ShouldRetry(err, attempt, retryBackoff) {
+			return err
+		}
+		if err := Sleep(ctx, retryBackoff); err != nil {
+			return err
+		}
+	}
+}
+
 // ExecuteActivity requests activity execution in the context of a workflow.
 // Context can be used to pass the settings for this activity.
 // For example: task list that this need to be routed, timeouts that need to be configured.
@@ -385,6 +747,9 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 
 	// Retrieve headers from context to pass them on
 	header := getHeadersFromContext(ctx)
+	if options.EnableAutoHeartbeat {
+		header.Fields[autoHeartbeatHeaderKey] = []byte("true")
+	}
 
 	params := executeActivityParams{
 		activityOptions: *options,
@@ -393,6 +758,10 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 		DataConverter:   dataConverter,
 		Header:          header,
 	}
+	// operations scheduled under a context with deadline must not outlive it
+	params.ScheduleToCloseTimeoutSeconds = capTimeoutSeconds(ctx, params.ScheduleToCloseTimeoutSeconds)
+	params.ScheduleToStartTimeoutSeconds = capTimeoutSeconds(ctx, params.ScheduleToStartTimeoutSeconds)
+	params.StartToCloseTimeoutSeconds = capTimeoutSeconds(ctx, params.StartToCloseTimeoutSeconds)
 
 	ctxDone, cancellable := ctx.Done().(*channelImpl)
 	cancellationCallback := &receiveCallback{}
@@ -406,7 +775,7 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 
 	if cancellable {
 		cancellationCallback.fn = func(v interface{}, more bool) bool {
-			if ctx.Err() == ErrCanceled {
+			if ctx.Err() != nil {
 				getWorkflowEnvironment(ctx).RequestCancelActivity(a.activityID)
 			}
 			return false
@@ -426,7 +795,7 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 // * No need to register local activity.
 // * The parameter activity to ExecuteLocalActivity() must be a function.
 // * Local activity is for short living activities (usually finishes within seconds).
-// * Local activity cannot heartbeat.
+// * Local activity heartbeat details are kept by the worker, they are not sent to the server.
 //
 // Context can be used to pass the settings for this local activity.
 // For now there is only one setting for timeout to be set:
@@ -434,9 +803,12 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 // 	    ScheduleToCloseTimeout: 5 * time.Second,
 // 	}
 //	ctx := WithLocalActivityOptions(ctx, lao)
-// The timeout here should be relative shorter than the DecisionTaskStartToCloseTimeout of the workflow. If you need a
-// longer timeout, you probably should not use local activity and instead should use regular activity. Local activity is
-// designed to be used for short living activities (usually finishes within seconds).
+// The timeout can be longer than the DecisionTaskStartToCloseTimeout of the workflow. While a local activity is running
+// the worker keeps the decision task open by force completing it and picking up the new decision task before it times
+// out. The local activity can report its progress with activity.RecordHeartbeat(). The __stack_trace query shows when
+// the last details were reported, and the details are handed to the next attempt through
+// activity.GetHeartbeatDetails() when it is retried. They are recorded in the local activity marker when the retry
+// waits for a timer, so they survive the restart of the worker.
 //
 // Input args are the arguments that will to be passed to the local activity. The input args will be hand over directly
 // to local activity function without serialization/deserialization because we don't need to pass the input across process
@@ -472,6 +844,7 @@ func ExecuteLocalActivity(ctx Context, activity interface{}, args ...interface{}
 		DataConverter:        getDataConverterFromWorkflowContext(ctx),
 		ScheduledTime:        Now(ctx), // initial scheduled time
 	}
+	params.ScheduleToCloseTimeoutSeconds = capTimeoutSeconds(ctx, params.ScheduleToCloseTimeoutSeconds)
 
 	Go(ctx, func(ctx Context) {
 		for {
@@ -483,6 +856,7 @@ func ExecuteLocalActivity(ctx Context, activity interface{}, args ...interface{}
 				Sleep(ctx, retryErr.Backoff)
 				// increase the attempt, and retry the local activity
 				params.Attempt = retryErr.Attempt + 1
+				params.HeartbeatDetails = retryErr.HeartbeatDetails
 				continue
 			}
 
@@ -496,8 +870,9 @@ func ExecuteLocalActivity(ctx Context, activity interface{}, args ...interface{}
 }
 
 type needRetryError struct {
-	Backoff time.Duration
-	Attempt int32
+	Backoff          time.Duration
+	Attempt          int32
+	HeartbeatDetails []byte
 }
 
 func (e *needRetryError) Error() string {
@@ -520,13 +895,13 @@ func scheduleLocalActivity(ctx Context, params *executeLocalActivityParams) Futu
 		}
 
 		// set retry error, and it will be handled by workflow.ExecuteLocalActivity().
-		f.Set(nil, &needRetryError{Backoff: lar.backoff, Attempt: lar.attempt})
+		f.Set(nil, &needRetryError{Backoff: lar.backoff, Attempt: lar.attempt, HeartbeatDetails: lar.heartbeatDetails})
 		return
 	})
 
 	if cancellable {
 		cancellationCallback.fn = func(v interface{}, more bool) bool {
-			if ctx.Err() == ErrCanceled {
+			if ctx.Err() != nil {
 				getWorkflowEnvironment(ctx).RequestCancelLocalActivity(la.activityID)
 			}
 			return false
@@ -589,6 +964,8 @@ func ExecuteChildWorkflow(ctx Context, childWorkflow interface{}, args ...interf
 		header:          getWorkflowHeader(ctx, options.contextPropagators),
 		scheduledTime:   Now(ctx), /* this is needed for test framework, and is not send to server */
 	}
+	params.executionStartToCloseTimeoutSeconds = common.Int32Ptr(
+		capTimeoutSeconds(ctx, *params.executionStartToCloseTimeoutSeconds))
 
 	var childWorkflowExecution *WorkflowExecution
 
@@ -615,7 +992,7 @@ func ExecuteChildWorkflow(ctx Context, childWorkflow interface{}, args ...interf
 
 	if cancellable {
 		cancellationCallback.fn = func(v interface{}, more bool) bool {
-			if ctx.Err() == ErrCanceled && childWorkflowExecution != nil && !mainFuture.IsReady() {
+			if ctx.Err() != nil && childWorkflowExecution != nil && !mainFuture.IsReady() {
 				// child workflow started, and ctx cancelled
 				getWorkflowEnvironment(ctx).RequestCancelChildWorkflow(*options.domain, childWorkflowExecution.ID)
 			}
@@ -653,11 +1030,17 @@ type WorkflowInfo struct {
 	lastCompletionResult                []byte
 	CronSchedule                        *string
 	ContinuedExecutionRunID             *string
+	FirstRunID                          *string                   // The run ID of the first run of the chain of continued runs, like retries and cron runs.
+	Initiator                           *s.ContinueAsNewInitiator // What started this run when it continues a previous one.
+	ExpirationTime                      time.Time                 // The time the retries of the workflow expire, zero if there is no such limit.
 	ParentWorkflowDomain                *string
 	ParentWorkflowExecution             *WorkflowExecution
 	Memo                                *s.Memo // Value can be decoded using data converter (DefaultDataConverter, or custom one if set).
 	SearchAttributes                    *s.SearchAttributes // Value can be decoded using DefaultDataConverter.
 	BinaryChecksum                      *string
+	originalRunID                       string // the run ID the execution was started with, it survives resets
+	HistoryLength                       int64  // Number of events in the history as of the start of the current decision.
+	HistorySizeBytes                    int64  // Approximate serialized size of the history as of the start of the current decision.
 }
 
 // GetWorkflowInfo extracts info of a current workflow from a context.
@@ -665,6 +1048,16 @@ func GetWorkflowInfo(ctx Context) *WorkflowInfo {
 	return getWorkflowEnvironment(ctx).WorkflowInfo()
 }
 
+// GetMemo decodes the value of key in the memo the workflow was started with into valuePtr, using the data converter
+// of ctx. It returns an error when the memo has no such key.
+func GetMemo(ctx Context, key string, valuePtr interface{}) error {
+	memo := GetWorkflowInfo(ctx).Memo
+	if memo == nil || memo.Fields[key] == nil {
+		return fmt.Errorf("memo key %q not found", key)
+	}
+	return decodeArg(getDataConverterFromWorkflowContext(ctx), memo.Fields[key], valuePtr)
+}
+
 // GetLogger returns a logger to be used in workflow's context
 func GetLogger(ctx Context) *zap.Logger {
 	return getWorkflowEnvironment(ctx).GetLogger()
@@ -681,6 +1074,26 @@ func Now(ctx Context) time.Time {
 	return getWorkflowEnvironment(ctx).Now().UTC()
 }
 
+// NewRandom returns a pseudo random number generator which produces the same values when the workflow is replayed,
+// without recording markers in the workflow history like SideEffect does. Every call returns a generator with a
+// different seed, derived from the workflow run and the number of generators created before, so generators must be
+// created in the same order on every replay like any other workflow code. The generator must only be used by the
+// coroutine which created it and it is not suitable for anything security sensitive.
+func NewRandom(ctx Context) *rand.Rand {
+	return rand.New(rand.NewSource(newRandomSeed(ctx)))
+}
+
+// NewUUID returns a random version 4 UUID which is the same when the workflow is replayed, without recording markers
+// in the workflow history. It is meant for IDs like idempotency keys of the requests made by activities. It has the
+// same restrictions as NewRandom.
+func NewUUID(ctx Context) string {
+	u := make(uuid.UUID, 16)
+	NewRandom(ctx).Read(u)
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return u.String()
+}
+
 // NewTimer returns immediately and the future becomes ready after the specified duration d. The workflow needs to use
 // this NewTimer() to get the timer instead of the Go lang library one(timer.NewTimer()). You can cancel the pending
 // timer by cancel the Context (using context from workflow.WithCancel(ctx)) and that will cancel the timer. After timer
@@ -733,6 +1146,49 @@ func Sleep(ctx Context, d time.Duration) (err error) {
 	return
 }
 
+// Await blocks the calling thread until condition() returns true. The condition is evaluated every time the workflow
+// makes progress, for example after a signal is received or a coroutine is unblocked, so it must only depend on
+// workflow state and must not block. Await returns nil once the condition is true, or *CanceledError if the ctx is
+// canceled before that.
+func Await(ctx Context, condition func() bool) error {
+	state := getState(ctx)
+	defer state.unblocked()
+
+	for !condition() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		state.yield("Await")
+	}
+	return nil
+}
+
+// AwaitWithTimeout blocks the calling thread until condition() returns true or the timeout expires. The timeout is
+// backed by a workflow timer, which is canceled once the condition becomes true. It returns true if the condition
+// was met and false if the timeout expired first. The error is *CanceledError if the ctx is canceled before either
+// happens. The condition has the same restrictions as the one of Await.
+func AwaitWithTimeout(ctx Context, timeout time.Duration, condition func() bool) (ok bool, err error) {
+	state := getState(ctx)
+	defer state.unblocked()
+
+	if condition() {
+		return true, nil
+	}
+	timerCtx, cancel := WithCancel(ctx)
+	defer cancel()
+	timer := NewTimer(timerCtx, timeout)
+	for !condition() {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if timer.IsReady() {
+			return false, nil
+		}
+		state.yield("AwaitWithTimeout")
+	}
+	return true, nil
+}
+
 // RequestCancelExternalWorkflow can be used to request cancellation of an external workflow.
 // Input workflowID is the workflow ID of target workflow.
 // Input runID indicates the instance of a workflow. Input runID is optional (default is ""). When runID is not specified,
@@ -920,6 +1376,9 @@ func WithDataConverter(ctx Context, dc DataConverter) Context {
 	if dc == nil {
 		panic("data converter is nil for WithDataConverter")
 	}
+	if env, ok := ctx.Value(workflowEnvironmentContextKey).(workflowEnvironment); ok {
+		dc = dataConverterForWorkflow(dc, env.WorkflowInfo().WorkflowExecution.ID)
+	}
 	ctx1 := setWorkflowEnvOptionsIfNotExist(ctx)
 	getWorkflowEnvOptions(ctx1).dataConverter = dc
 	return ctx1
@@ -937,6 +1396,84 @@ func GetSignalChannel(ctx Context, signalName string) Channel {
 	return getWorkflowEnvOptions(ctx).getSignalChannel(ctx, signalName)
 }
 
+// SetSignalHandler registers a handler that is called for every signal with the given name. The handler must be a
+// function that takes workflow.Context as its first parameter, optionally followed by a serializable parameter the
+// signal input is decoded into, and it must not return anything:
+//  err := workflow.SetSignalHandler(ctx, "add-item", func(ctx workflow.Context, item string) {
+//      items = append(items, item)
+//  })
+// Each signal is delivered to the handler in its own coroutine, started in the order the signals were received. The
+// handler can call blocking functions like Future.Get() without delaying the signals received after it, so handlers
+// of concurrent signals can interleave at blocking calls. To handle the signals one at a time, receive them from
+// GetSignalChannel instead. Signals received before the handler was set are delivered too.
+// The handler consumes the signals from the channel returned by GetSignalChannel for the same name, so a signal
+// name should either be handled by a handler or received from the channel, not both. Calling SetSignalHandler again
+// for the same name replaces the handler.
+func SetSignalHandler(ctx Context, signalName string, handler interface{}) error {
+	return setSignalHandler(ctx, signalName, handler)
+}
+
+// DrainSignals blocks until all received signals that have a handler set through SetSignalHandler, including
+// updates, are delivered and the handlers returned. Call it before returning ContinueAsNewError so that no signal is lost. Signals consumed
+// through GetSignalChannel still have to be drained by the workflow code, for example with Channel.ReceiveAsync().
+// It returns CanceledError when ctx is canceled.
+func DrainSignals(ctx Context) error {
+	eo := getWorkflowEnvOptions(ctx)
+	return Await(ctx, func() bool {
+		return eo.signalHandlersIdle()
+	})
+}
+
+const (
+	defaultContinueAsNewHistoryLength    = 10000
+	defaultContinueAsNewHistorySizeBytes = 10 * 1024 * 1024
+)
+
+// ContinueAsNewThresholds configures when ShouldContinueAsNew suggests to continue as new.
+type ContinueAsNewThresholds struct {
+	// HistoryLength is the number of history events after which ShouldContinueAsNew returns true.
+	// Optional: default is 10000.
+	HistoryLength int64
+	// HistorySizeBytes is the size of the history in bytes after which ShouldContinueAsNew returns true.
+	// Optional: default is 10MB.
+	HistorySizeBytes int64
+}
+
+// WithContinueAsNewThresholds adds the thresholds used by ShouldContinueAsNew to the context.
+func WithContinueAsNewThresholds(ctx Context, thresholds ContinueAsNewThresholds) Context {
+	ctx1 := setWorkflowEnvOptionsIfNotExist(ctx)
+	getWorkflowEnvOptions(ctx1).continueAsNewThresholds = &thresholds
+	return ctx1
+}
+
+// ShouldContinueAsNew returns true when the history of the workflow grew past the thresholds set with
+// WithContinueAsNewThresholds, or the default ones, and the workflow should complete with ContinueAsNewError to
+// keep clear of the history limits of the server. Long running workflows driven by signals can check it in their
+// event loop:
+//  for !workflow.ShouldContinueAsNew(ctx) {
+//      selector.Select(ctx)
+//  }
+//  _ = workflow.DrainSignals(ctx)
+//  return workflow.NewContinueAsNewError(ctx, MyWorkflow, state)
+// The history is measured at the start of each decision, so the result is the same when the workflow is replayed.
+// WorkflowInfo.HistoryLength and WorkflowInfo.HistorySizeBytes hold the current values.
+func ShouldContinueAsNew(ctx Context) bool {
+	thresholds := ContinueAsNewThresholds{
+		HistoryLength:    defaultContinueAsNewHistoryLength,
+		HistorySizeBytes: defaultContinueAsNewHistorySizeBytes,
+	}
+	if eo := getWorkflowEnvOptions(ctx); eo != nil && eo.continueAsNewThresholds != nil {
+		if eo.continueAsNewThresholds.HistoryLength > 0 {
+			thresholds.HistoryLength = eo.continueAsNewThresholds.HistoryLength
+		}
+		if eo.continueAsNewThresholds.HistorySizeBytes > 0 {
+			thresholds.HistorySizeBytes = eo.continueAsNewThresholds.HistorySizeBytes
+		}
+	}
+	info := GetWorkflowInfo(ctx)
+	return info.HistoryLength >= thresholds.HistoryLength || info.HistorySizeBytes >= thresholds.HistorySizeBytes
+}
+
 func newEncodedValue(value []byte, dc DataConverter) Value {
 	if dc == nil {
 		dc = getDefaultDataConverter()
@@ -1109,7 +1646,9 @@ func GetVersion(ctx Context, changeID string, minSupported, maxSupported Version
 // The query handler will be invoked out of the context of the workflow, meaning that the handler code must not use cadence
 // context to do things like workflow.NewChannel(), workflow.Go() or to call any workflow blocking functions like
 // Channel.Get() or Future.Get(). Trying to do so in query handler code will fail the query and client will receive
-// QueryFailedError.
+// QueryFailedError. A CustomError returned by the handler reaches the client as a CustomError with the same reason
+// and details. Clients can list the query types of a workflow, with the types of their arguments and result, through
+// the built-in "__query_types" query.
 // Example of workflow code that support query type "current_state":
 //  func MyWorkflow(ctx workflow.Context, input string) error {
 //    currentState := "started" // this could be any serializable struct
@@ -1145,6 +1684,47 @@ func SetQueryHandler(ctx Context, queryType string, handler interface{}) error {
 	return setQueryHandler(ctx, queryType, handler)
 }
 
+// QueryTypeInfo describes a query type of a workflow, the result of the built-in QueryTypeQueryTypes query is a list of
+// them.
+type QueryTypeInfo struct {
+	Name string
+	// ArgTypes are the Go types of the query arguments, in order.
+	ArgTypes []string
+	// ResultType is the Go type of the query result.
+	ResultType string
+}
+
+// UpdateHandlerOptions are optional parameters of SetUpdateHandlerWithOptions.
+type UpdateHandlerOptions struct {
+	// Validator is a function that takes the same parameters as the update handler, without workflow.Context, and
+	// returns an error to reject the update. It is called before the update is written to the workflow history, and
+	// again when the update is delivered to the workflow. Like a query handler it must not change the workflow state
+	// or call any workflow blocking functions.
+	// Optional: default accepts every update.
+	Validator interface{}
+}
+
+// SetUpdateHandler sets the handler of the updates with the given name sent through Client.UpdateWorkflow(). An
+// update is a request that changes the workflow state and returns a result to the caller, for example "add item to
+// cart and return the new total". The handler must be a function that takes workflow.Context as its first parameter
+// followed by any number of serializable parameters, and returns either an error or a serializable result and an
+// error:
+//  err := workflow.SetUpdateHandler(ctx, "add_item", func(ctx workflow.Context, item Item) (int, error) {
+//      cart = append(cart, item)
+//      return len(cart), nil
+//  })
+// Each update runs in its own coroutine so the handler can call blocking functions like Future.Get().
+// Updates are delivered as signals named "__update", their outcomes are returned by the "__update_result" query.
+func SetUpdateHandler(ctx Context, updateName string, handler interface{}) error {
+	return setUpdateHandler(ctx, updateName, handler, UpdateHandlerOptions{})
+}
+
+// SetUpdateHandlerWithOptions sets the handler of the updates with the given name, see SetUpdateHandler. Use
+// options to set a validator that can reject updates before they are written to the workflow history.
+func SetUpdateHandlerWithOptions(ctx Context, updateName string, handler interface{}, options UpdateHandlerOptions) error {
+	return setUpdateHandler(ctx, updateName, handler, options)
+}
+
 // IsReplaying returns whether the current workflow code is replaying.
 //
 // Warning! Never make decisions, like schedule activity/childWorkflow/timer or send/wait on future/channel, based on
@@ -631,3 +631,19 @@ func (t *TestWorkflowEnvironment) SetSearchAttributesOnStart(searchAttributes ma
 	t.impl.workflowInfo.SearchAttributes = attr
 	return nil
 }
+
+// SetWorkflowInfoOnStart sets the fields of the WorkflowInfo returned by workflow.GetWorkflowInfo() that describe how
+// the workflow was started: Attempt, CronSchedule, ContinuedExecutionRunID, FirstRunID, Initiator, ExpirationTime,
+// ParentWorkflowDomain and ParentWorkflowExecution. The other fields of info are ignored, use SetMemoOnStart,
+// SetSearchAttributesOnStart and SetWorkflowTimeout for the memo, search attributes and timeout.
+func (t *TestWorkflowEnvironment) SetWorkflowInfoOnStart(info WorkflowInfo) {
+	workflowInfo := t.impl.workflowInfo
+	workflowInfo.Attempt = info.Attempt
+	workflowInfo.CronSchedule = info.CronSchedule
+	workflowInfo.ContinuedExecutionRunID = info.ContinuedExecutionRunID
+	workflowInfo.FirstRunID = info.FirstRunID
+	workflowInfo.Initiator = info.Initiator
+	workflowInfo.ExpirationTime = info.ExpirationTime
+	workflowInfo.ParentWorkflowDomain = info.ParentWorkflowDomain
+	workflowInfo.ParentWorkflowExecution = info.ParentWorkflowExecution
+}
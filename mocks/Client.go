@@ -510,3 +510,29 @@ func (_m *Client) TerminateWorkflow(ctx context.Context, workflowID string, runI
 
 	return r0
 }
+
+// UpdateWorkflow provides a mock function with given fields: ctx, workflowID, runID, updateName, args
+func (_m *Client) UpdateWorkflow(ctx context.Context, workflowID string, runID string, updateName string, args ...interface{}) (encoded.Value, error) {
+	var _ca []interface{}
+	_ca = append(_ca, ctx, workflowID, runID, updateName)
+	_ca = append(_ca, args...)
+	ret := _m.Called(_ca...)
+
+	var r0 encoded.Value
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, ...interface{}) encoded.Value); ok {
+		r0 = rf(ctx, workflowID, runID, updateName, args...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(encoded.Value)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, ...interface{}) error); ok {
+		r1 = rf(ctx, workflowID, runID, updateName, args...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
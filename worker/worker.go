@@ -23,6 +23,7 @@ package worker
 
 import (
 	"context"
+	"time"
 
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
 	"go.uber.org/cadence/.gen/go/shared"
@@ -38,9 +39,32 @@ type (
 	// Options is used to configure a worker instance.
 	Options = internal.WorkerOptions
 
+	// StickyWorkflowCache keeps the state of workflow executions between decision tasks on a worker.
+	StickyWorkflowCache = internal.StickyWorkflowCache
+
+	// StickyWorkflowCacheOptions is used to configure a sticky workflow cache created by NewStickyWorkflowCache.
+	StickyWorkflowCacheOptions = internal.StickyWorkflowCacheOptions
+
 	// NonDeterministicWorkflowPolicy is an enum for configuring how client's decision task handler deals with
 	// mismatched history events (presumably arising from non-deterministic workflow definitions).
 	NonDeterministicWorkflowPolicy = internal.NonDeterministicWorkflowPolicy
+
+	// FaultInjectionRule describes a fault injected by a worker into the tasks it processes. A rule matches a task
+	// if all of its non empty scope fields (WorkflowType, ActivityType and TaskList) match the task. If several rules
+	// of the same FaultType match a task, the first one which fires is used.
+	FaultInjectionRule = internal.FaultInjectionRule
+
+	// FaultType is an enum for the kind of fault injected by a FaultInjectionRule.
+	FaultType = internal.FaultType
+
+	// PollBackoffPolicy computes how long the pollers of a worker wait before polling again after failed polls.
+	PollBackoffPolicy = internal.PollBackoffPolicy
+
+	// PollErrorClassifier returns true if the pollers of a worker should back off on the poll error.
+	PollErrorClassifier = internal.PollErrorClassifier
+
+	// ReplayOptions is used to configure the replay functions which take options, like ReplayWorkflowHistoryWithOptions.
+	ReplayOptions = internal.ReplayOptions
 )
 
 const (
@@ -58,6 +82,25 @@ const (
 	NonDeterministicWorkflowPolicyFailWorkflow = internal.NonDeterministicWorkflowPolicyFailWorkflow
 )
 
+const (
+	// FaultTypeDelay delays the processing of matching decision and activity tasks by FaultInjectionRule.Delay.
+	FaultTypeDelay = internal.FaultTypeDelay
+	// FaultTypeDropResponse never reports the result of matching decision and activity tasks back to the server, as
+	// if the worker crashed. Activity tasks are processed and their result dropped, decision tasks are dropped before
+	// they are processed so the cached workflow state stays in sync with the server. The server retries the task
+	// once it times out.
+	FaultTypeDropResponse = internal.FaultTypeDropResponse
+	// FaultTypeFailDecisionTask fails matching decision tasks without processing them, as if the workflow code
+	// panicked. The server retries the decision task.
+	FaultTypeFailDecisionTask = internal.FaultTypeFailDecisionTask
+	// FaultTypeActivityPanic panics matching activity tasks before the activity function is invoked. The
+	// activity fails with a PanicError.
+	FaultTypeActivityPanic = internal.FaultTypeActivityPanic
+	// FaultTypeHeartbeatFailure fails the heartbeats of matching activity tasks without sending them to the
+	// server, so the activity eventually times out if it has a heartbeat timeout.
+	FaultTypeHeartbeatFailure = internal.FaultTypeHeartbeatFailure
+)
+
 // New creates an instance of worker for managing workflow and activity executions.
 //    service  - thrift connection to the cadence server
 //    domain   - the name of the cadence domain
@@ -88,6 +131,13 @@ func ReplayWorkflowHistory(logger *zap.Logger, history *shared.History) error {
 	return internal.ReplayWorkflowHistory(logger, history)
 }
 
+// ReplayWorkflowHistoryWithOptions executes a single decision task for the given history with the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayWorkflowHistoryWithOptions(logger *zap.Logger, history *shared.History, options ReplayOptions) error {
+	return internal.ReplayWorkflowHistoryWithOptions(logger, history, options)
+}
+
 // ReplayWorkflowHistoryFromJSONFile executes a single decision task for the json history file downloaded from the cli.
 // To download the history file: cadence workflow showid <workflow_id> -of <output_filename>
 // See https://github.com/uber/cadence/blob/master/tools/cli/README.md for full documentation
@@ -107,6 +157,22 @@ func ReplayPartialWorkflowHistoryFromJSONFile(logger *zap.Logger, jsonfileName s
 	return internal.ReplayPartialWorkflowHistoryFromJSONFile(logger, jsonfileName, lastEventID)
 }
 
+// ReplayWorkflowHistoryFromJSONFileWithOptions executes a single decision task for the json history file downloaded
+// from the cli with the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayWorkflowHistoryFromJSONFileWithOptions(logger *zap.Logger, jsonfileName string, options ReplayOptions) error {
+	return internal.ReplayWorkflowHistoryFromJSONFileWithOptions(logger, jsonfileName, options)
+}
+
+// ReplayPartialWorkflowHistoryFromJSONFileWithOptions executes a single decision task for the json history file upto
+// provided lastEventID(inclusive), downloaded from the cli, with the given options.
+// Use it to replay histories written by workers with a custom DataConverter.
+// The logger is an optional parameter. Defaults to the noop logger.
+func ReplayPartialWorkflowHistoryFromJSONFileWithOptions(logger *zap.Logger, jsonfileName string, lastEventID int64, options ReplayOptions) error {
+	return internal.ReplayPartialWorkflowHistoryFromJSONFileWithOptions(logger, jsonfileName, lastEventID, options)
+}
+
 // ReplayWorkflowExecution loads a workflow execution history from the Cadence service and executes a single decision task for it.
 // Use for testing the backwards compatibility of code changes and troubleshooting workflows in a debugger.
 // The logger is the only optional parameter. Defaults to the noop logger.
@@ -114,6 +180,14 @@ func ReplayWorkflowExecution(ctx context.Context, service workflowserviceclient.
 	return internal.ReplayWorkflowExecution(ctx, service, logger, domain, execution)
 }
 
+// ReplayWorkflowExecutionWithOptions loads a workflow execution history from the Cadence service and executes a single
+// decision task for it with the given options.
+// Use it to replay executions of workers with a custom DataConverter.
+// The logger is the only optional parameter. Defaults to the noop logger.
+func ReplayWorkflowExecutionWithOptions(ctx context.Context, service workflowserviceclient.Interface, logger *zap.Logger, domain string, execution workflow.Execution, options ReplayOptions) error {
+	return internal.ReplayWorkflowExecutionWithOptions(ctx, service, logger, domain, execution, options)
+}
+
 // SetStickyWorkflowCacheSize sets the cache size for sticky workflow cache. Sticky workflow execution is the affinity
 // between decision tasks of a specific workflow execution to a specific worker. The affinity is set if sticky execution
 // is enabled via Worker.Options (It is enabled by default unless disabled explicitly). The benefit of sticky execution
@@ -125,6 +199,25 @@ func SetStickyWorkflowCacheSize(cacheSize int) {
 	internal.SetStickyWorkflowCacheSize(cacheSize)
 }
 
+// NewStickyWorkflowCache creates a sticky workflow cache which can be assigned to Options.StickyWorkflowCache.
+// Unlike the process wide cache sized by SetStickyWorkflowCacheSize, it is only used by the workers it is assigned to
+// and it can bound the estimated memory of the cached workflow executions and evict executions which stay idle.
+func NewStickyWorkflowCache(options StickyWorkflowCacheOptions) *StickyWorkflowCache {
+	return internal.NewStickyWorkflowCache(options)
+}
+
+// NewExponentialPollBackoffPolicy creates a PollBackoffPolicy which starts at initialInterval and doubles with every
+// consecutive failed poll, up to maximumInterval, whatever the poll error.
+func NewExponentialPollBackoffPolicy(initialInterval, maximumInterval time.Duration) PollBackoffPolicy {
+	return internal.NewExponentialPollBackoffPolicy(initialInterval, maximumInterval)
+}
+
+// DefaultPollErrorClassifier is the PollErrorClassifier used unless Options.PollErrorClassifier is set. It
+// backs off on all errors except the ones which retrying can't fix, like BadRequestError or EntityNotExistsError.
+func DefaultPollErrorClassifier(err error) bool {
+	return internal.DefaultPollErrorClassifier(err)
+}
+
 // SetBinaryChecksum sets the identifier of the binary(aka BinaryChecksum).
 // The identifier is mainly used in recording reset points when respondDecisionTaskCompleted. For each workflow, the very first
 // decision completed by a binary will be associated as a auto-reset point for the binary. So that when a customer wants to
@@ -21,6 +21,8 @@
 package workflow
 
 import (
+	"time"
+
 	"go.uber.org/cadence/internal"
 )
 
@@ -54,6 +56,39 @@ func WithCancel(parent Context) (ctx Context, cancel CancelFunc) {
 	return internal.WithCancel(parent)
 }
 
+// WithDeadline returns a copy of the parent context with the deadline adjusted
+// to be no later than deadline.  If the parent's deadline is already earlier than deadline,
+// WithDeadline(parent, deadline) is semantically equivalent to parent.  The returned
+// context's Done channel is closed when the deadline expires, when the returned
+// cancel function is called, or when the parent context's Done channel is
+// closed, whichever happens first. When the deadline expires the context's Err
+// returns ErrDeadlineExceeded.
+//
+// The deadline is enforced by a durable workflow timer, so it survives worker
+// restarts and replays deterministically. Activities and child workflows
+// scheduled with the returned context have their timeouts capped to the time
+// left until the deadline.
+//
+// Canceling this context releases resources associated with it, so code should
+// call cancel as soon as the operations running in this Context complete.
+func WithDeadline(parent Context, deadline time.Time) (ctx Context, cancel CancelFunc) {
+	return internal.WithDeadline(parent, deadline)
+}
+
+// WithTimeout returns WithDeadline(parent, workflow.Now(parent).Add(timeout)).
+//
+// Canceling this context releases resources associated with it, so code should
+// call cancel as soon as the operations running in this Context complete:
+//
+// 	func slowOperationWithTimeout(ctx workflow.Context) error {
+// 		ctx, cancel := workflow.WithTimeout(ctx, time.Hour)
+// 		defer cancel()  // releases resources if slowOperation completes before timeout elapses
+// 		return slowOperation(ctx)
+// 	}
+func WithTimeout(parent Context, timeout time.Duration) (ctx Context, cancel CancelFunc) {
+	return internal.WithTimeout(parent, timeout)
+}
+
 // WithValue returns a copy of parent in which the value associated with key is
 // val.
 //
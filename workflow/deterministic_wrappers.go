@@ -21,6 +21,7 @@
 package workflow
 
 import (
+	"math/rand"
 	"time"
 
 	"go.uber.org/cadence/internal"
@@ -34,6 +35,8 @@ type (
 
 	// Selector must be used instead of native go select by workflow code.
 	// Use workflow.NewSelector(ctx) method to create a Selector instance.
+	// The cases of futures stay in the Selector after they were selected, so a Selector that is reused
+	// for many futures grows unless the selected ones are removed with RemoveSelectorFuture.
 	Selector = internal.Selector
 
 	// Future represents the result of an asynchronous computation.
@@ -46,6 +49,14 @@ type (
 	// WaitGroup is used to wait for a collection of
 	// coroutines to finish
 	WaitGroup = internal.WaitGroup
+
+	// Mutex must be used instead of native go sync.Mutex by workflow code.
+	// Use workflow.NewMutex(ctx) method to create a Mutex instance.
+	Mutex = internal.Mutex
+
+	// Semaphore is used to limit the number of coroutines doing something concurrently.
+	// Use workflow.NewSemaphore(ctx, size) method to create a Semaphore instance.
+	Semaphore = internal.Semaphore
 )
 
 // NewChannel create new Channel instance
@@ -81,11 +92,73 @@ func NewNamedSelector(ctx Context, name string) Selector {
 	return internal.NewNamedSelector(ctx, name)
 }
 
+// SelectWithTimeout is like Selector.Select but gives up when none of the cases is ready within the timeout. It
+// returns true if a case (or the default) was selected, and false if the timeout expired or ctx was canceled first.
+// A timer is only started when no case is ready, and it is canceled as soon as a case is selected.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func SelectWithTimeout(ctx Context, selector Selector, timeout time.Duration) (bool, error) {
+	return internal.SelectWithTimeout(ctx, selector, timeout)
+}
+
+// SelectorHasPending returns true when one of the cases of the selector, not counting the default, is ready and
+// Select would not block. An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func SelectorHasPending(selector Selector) (bool, error) {
+	return internal.SelectorHasPending(selector)
+}
+
+// RemoveSelectorReceive removes the receive cases of the channel from the selector, so the Selector can be reused
+// with other cases. To replace the function of a case remove it and add it again.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorReceive(selector Selector, c Channel) error {
+	return internal.RemoveSelectorReceive(selector, c)
+}
+
+// RemoveSelectorSend removes the send cases of the channel from the selector.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorSend(selector Selector, c Channel) error {
+	return internal.RemoveSelectorSend(selector, c)
+}
+
+// RemoveSelectorFuture removes the case of the future from the selector. Cases of futures that were already
+// selected are kept by the Selector, so a long-lived Selector should remove them to not grow without bounds.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorFuture(selector Selector, future Future) error {
+	return internal.RemoveSelectorFuture(selector, future)
+}
+
+// RemoveSelectorDefault removes the default case from the selector.
+// An error is returned if the selector wasn't created with NewSelector or NewNamedSelector.
+func RemoveSelectorDefault(selector Selector) error {
+	return internal.RemoveSelectorDefault(selector)
+}
+
 // NewWaitGroup creates a new WaitGroup instance.
 func NewWaitGroup(ctx Context) WaitGroup {
 	return internal.NewWaitGroup(ctx)
 }
 
+// NewMutex creates a new Mutex instance.
+func NewMutex(ctx Context) Mutex {
+	return internal.NewMutex(ctx)
+}
+
+// NewNamedMutex creates a new Mutex instance with a given human readable name.
+// Name appears in stack traces that are blocked on this Mutex.
+func NewNamedMutex(ctx Context, name string) Mutex {
+	return internal.NewNamedMutex(ctx, name)
+}
+
+// NewSemaphore creates a new Semaphore instance with the given number of permits.
+func NewSemaphore(ctx Context, size int) Semaphore {
+	return internal.NewSemaphore(ctx, size)
+}
+
+// NewNamedSemaphore creates a new Semaphore instance with a given human readable name and number of permits.
+// Name appears in stack traces that are blocked on this Semaphore.
+func NewNamedSemaphore(ctx Context, name string, size int) Semaphore {
+	return internal.NewNamedSemaphore(ctx, name, size)
+}
+
 // Go creates a new coroutine. It has similar semantic to goroutine in a context of the workflow.
 func Go(ctx Context, f func(ctx Context)) {
 	internal.Go(ctx, f)
@@ -103,12 +176,61 @@ func NewFuture(ctx Context) (Future, Settable) {
 	return internal.NewFuture(ctx)
 }
 
+// AllOf returns a Future that becomes ready when all of the given futures are ready.
+// Its value is a slice holding the results of the futures in the order they were passed in:
+//  var results []string
+//  err := workflow.AllOf(ctx, f1, f2, f3).Get(ctx, &results)
+// If any of the futures failed the returned error is *AggregateError that contains the errors of all futures, the
+// results of the futures that succeeded are still assigned to the slice.
+func AllOf(ctx Context, futures ...Future) Future {
+	return internal.AllOf(ctx, futures...)
+}
+
+// AnyOf returns a Future that becomes ready when the first of the given futures completes successfully. Its value
+// (or error) is the same as of that future. If all of the futures failed the returned error is *AggregateError that
+// contains the errors of all futures.
+// When several futures are ready at the same time the one that was passed in first wins.
+// Futures that weren't created by the workflow package are supported as well, the value of such a future is what
+// its Get assigns to an *interface{}.
+func AnyOf(ctx Context, futures ...Future) Future {
+	return internal.AnyOf(ctx, futures...)
+}
+
+// ForEachParallel calls fn for every element of items, which must be a slice, and waits for the Futures returned
+// by fn to become ready. At most maxConcurrency Futures are in flight at the same time, a non positive
+// maxConcurrency means no limit. Elements are started in the order of the slice.
+// When one of the Futures fails (or ctx is canceled) no further elements are started and the context passed to fn
+// is canceled, which cancels the activities and child workflows that are still running. ForEachParallel waits for
+// the in flight Futures to complete and returns the first error.
+//  err := workflow.ForEachParallel(ctx, files, 10, func(ctx workflow.Context, item interface{}) workflow.Future {
+//      return workflow.ExecuteActivity(ctx, ProcessFile, item.(string))
+//  })
+func ForEachParallel(ctx Context, items interface{}, maxConcurrency int, fn func(ctx Context, item interface{}) Future) error {
+	return internal.ForEachParallel(ctx, items, maxConcurrency, fn)
+}
+
 // Now returns the current time when the decision is started or replayed.
 // The workflow needs to use this Now() to get the wall clock time instead of the Go lang library one.
 func Now(ctx Context) time.Time {
 	return internal.Now(ctx)
 }
 
+// NewRandom returns a pseudo random number generator which produces the same values when the workflow is replayed,
+// without recording markers in the workflow history like SideEffect does. Every call returns a generator with a
+// different seed, derived from the workflow run and the number of generators created before, so generators must be
+// created in the same order on every replay like any other workflow code. The generator must only be used by the
+// coroutine which created it and it is not suitable for anything security sensitive.
+func NewRandom(ctx Context) *rand.Rand {
+	return internal.NewRandom(ctx)
+}
+
+// NewUUID returns a random version 4 UUID which is the same when the workflow is replayed, without recording markers
+// in the workflow history. It is meant for IDs like idempotency keys of the requests made by activities. It has the
+// same restrictions as NewRandom.
+func NewUUID(ctx Context) string {
+	return internal.NewUUID(ctx)
+}
+
 // NewTimer returns immediately and the future becomes ready after the specified duration d. The workflow needs to use
 // this NewTimer() to get the timer instead of the Go lang library one(timer.NewTimer()). You can cancel the pending
 // timer by cancel the Context (using context from workflow.WithCancel(ctx)) and that will cancel the timer. After timer
@@ -130,3 +252,19 @@ func NewTimer(ctx Context, d time.Duration) Future {
 func Sleep(ctx Context, d time.Duration) (err error) {
 	return internal.Sleep(ctx, d)
 }
+
+// Await blocks the calling thread until condition() returns true. The condition is evaluated every time the workflow
+// makes progress, for example after a signal is received or a coroutine is unblocked, so it must only depend on
+// workflow state and must not block. Await returns nil once the condition is true, or *CanceledError if the ctx is
+// canceled before that.
+func Await(ctx Context, condition func() bool) error {
+	return internal.Await(ctx, condition)
+}
+
+// AwaitWithTimeout blocks the calling thread until condition() returns true or the timeout expires. The timeout is
+// backed by a workflow timer, which is canceled once the condition becomes true. It returns true if the condition
+// was met and false if the timeout expired first. The error is *CanceledError if the ctx is canceled before either
+// happens. The condition has the same restrictions as the one of Await.
+func AwaitWithTimeout(ctx Context, timeout time.Duration, condition func() bool) (ok bool, err error) {
+	return internal.AwaitWithTimeout(ctx, timeout, condition)
+}
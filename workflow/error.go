@@ -103,6 +103,10 @@ type (
 
 	// UnknownExternalWorkflowExecutionError can be returned when external workflow doesn't exist
 	UnknownExternalWorkflowExecutionError = internal.UnknownExternalWorkflowExecutionError
+
+	// AggregateError is returned when some operations of a group failed, for example by the futures created
+	// through AllOf and AnyOf or by Saga.Compensate.
+	AggregateError = internal.AggregateError
 )
 
 // NewContinueAsNewError creates ContinueAsNewError instance
@@ -0,0 +1,53 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package workflow
+
+import (
+	"go.uber.org/cadence/internal"
+)
+
+type (
+	// SagaOptions configure how a Saga runs its compensations.
+	//  ParallelCompensation: optional, default false
+	//      Runs all compensations at the same time instead of one after another.
+	//  ContinueWithError: optional, default false
+	//      Keeps running the remaining compensations when a sequential compensation fails.
+	SagaOptions = internal.SagaOptions
+
+	// Saga records compensation activities for the steps of a workflow that completed successfully, so they
+	// can be undone when a later step fails. Use NewSaga to create it.
+	Saga = internal.Saga
+)
+
+// NewSaga creates a Saga for the workflow of ctx. The compensations are scheduled with the activity options of the context passed to
+// Saga.Compensate.
+//  saga := workflow.NewSaga(ctx, workflow.SagaOptions{})
+//  if err := workflow.ExecuteActivity(ctx, BookHotel, order).Get(ctx, nil); err != nil {
+//      return err
+//  }
+//  saga.AddCompensation(CancelHotel, order)
+//  if err := workflow.ExecuteActivity(ctx, ChargeCard, order).Get(ctx, nil); err != nil {
+//      _ = saga.Compensate(ctx)
+//      return err
+//  }
+func NewSaga(ctx Context, options SagaOptions) *Saga {
+	return internal.NewSaga(ctx, options)
+}
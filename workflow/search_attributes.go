@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package workflow
+
+import (
+	"go.uber.org/cadence/internal"
+)
+
+type (
+	// SearchAttributeKey is the name of a search attribute together with the type of its values, as registered on
+	// the cadence server.
+	SearchAttributeKey = internal.SearchAttributeKey
+
+	// SearchAttributeUpdate is a value for a search attribute, created by the ValueSet method of a typed key and
+	// passed to UpsertTypedSearchAttributes.
+	SearchAttributeUpdate = internal.SearchAttributeUpdate
+
+	// StringAttr is a search attribute key of type String, it is full text searchable.
+	StringAttr = internal.StringAttr
+	// KeywordAttr is a search attribute key of type Keyword, it is matched as a whole.
+	KeywordAttr = internal.KeywordAttr
+	// IntAttr is a search attribute key of type Int.
+	IntAttr = internal.IntAttr
+	// DoubleAttr is a search attribute key of type Double.
+	DoubleAttr = internal.DoubleAttr
+	// BoolAttr is a search attribute key of type Bool.
+	BoolAttr = internal.BoolAttr
+	// DatetimeAttr is a search attribute key of type Datetime.
+	DatetimeAttr = internal.DatetimeAttr
+)
+
+// NewStringAttr creates a key for a search attribute of type String.
+func NewStringAttr(name string) StringAttr {
+	return internal.NewStringAttr(name)
+}
+
+// NewKeywordAttr creates a key for a search attribute of type Keyword.
+func NewKeywordAttr(name string) KeywordAttr {
+	return internal.NewKeywordAttr(name)
+}
+
+// NewIntAttr creates a key for a search attribute of type Int.
+func NewIntAttr(name string) IntAttr {
+	return internal.NewIntAttr(name)
+}
+
+// NewDoubleAttr creates a key for a search attribute of type Double.
+func NewDoubleAttr(name string) DoubleAttr {
+	return internal.NewDoubleAttr(name)
+}
+
+// NewBoolAttr creates a key for a search attribute of type Bool.
+func NewBoolAttr(name string) BoolAttr {
+	return internal.NewBoolAttr(name)
+}
+
+// NewDatetimeAttr creates a key for a search attribute of type Datetime.
+func NewDatetimeAttr(name string) DatetimeAttr {
+	return internal.NewDatetimeAttr(name)
+}
+
+// UpsertTypedSearchAttributes is like UpsertSearchAttributes but takes updates created from typed keys, so the
+// values are always of the type registered on the server:
+//  customerID := workflow.NewKeywordAttr("CustomerId")
+//  orderCount := workflow.NewIntAttr("OrderCount")
+//  err := workflow.UpsertTypedSearchAttributes(ctx, customerID.ValueSet("customer-1"), orderCount.ValueSet(3))
+func UpsertTypedSearchAttributes(ctx Context, updates ...SearchAttributeUpdate) error {
+	return internal.UpsertTypedSearchAttributes(ctx, updates...)
+}
+
+// GetSearchAttribute decodes the current value of the search attribute key, including the upserts done by the
+// workflow so far, into valuePtr. Datetime values are decoded into time.Time or string. It returns an error when
+// the workflow has no such search attribute.
+//  var orderCount int64
+//  err := workflow.GetSearchAttribute(ctx, workflow.NewIntAttr("OrderCount"), &orderCount)
+func GetSearchAttribute(ctx Context, key SearchAttributeKey, valuePtr interface{}) error {
+	return internal.GetSearchAttribute(ctx, key, valuePtr)
+}
@@ -49,6 +49,39 @@ type (
 
 	// Info information about currently executing workflow
 	Info = internal.WorkflowInfo
+
+	// UpdateHandlerOptions are optional parameters of SetUpdateHandlerWithOptions.
+	//  Validator: optional, default accepts every update
+	//      A function that takes the same parameters as the update handler, without workflow.Context, and returns
+	//      an error to reject the update. It must not change the workflow state or call blocking functions.
+	UpdateHandlerOptions = internal.UpdateHandlerOptions
+
+	// ContinueAsNewThresholds configures when ShouldContinueAsNew suggests to continue as new.
+	//  HistoryLength: optional, default is 10000
+	//      The number of history events after which ShouldContinueAsNew returns true.
+	//  HistorySizeBytes: optional, default is 10MB
+	//      The size of the history in bytes after which ShouldContinueAsNew returns true.
+	ContinueAsNewThresholds = internal.ContinueAsNewThresholds
+
+	// RetryPredicate decides whether to retry after a failure. It gets the error of the failed attempt, the attempt
+	// number starting from 0 and the backoff before the next attempt, and returns false to stop retrying.
+	RetryPredicate = internal.RetryPredicate
+
+	// RetryOptions configure how Retry retries a function.
+	//  InitialInterval: required
+	//      Backoff interval for the first retry. If coefficient is 1.0 then it is used for all retries.
+	//  BackoffCoefficient: optional, default is 2.0
+	//      The next retry interval is previous interval multiplied by this coefficient, must be 1 or larger.
+	//  MaximumInterval: optional, default is 100x of InitialInterval
+	//      The cap of the backoff interval.
+	//  ExpirationInterval: either ExpirationInterval or MaximumAttempts is required
+	//      Maximum time to retry, measured from the call to Retry.
+	//  MaximumAttempts: either ExpirationInterval or MaximumAttempts is required
+	//      Maximum number of attempts, 0 means unlimited.
+	//  ShouldRetry: optional, default retries every error
+	//      Decides whether a failure is retried, it can look at the typed error, for example the reason and details
+	//      of a CustomError. It runs in the workflow so it must be deterministic.
+	RetryOptions = internal.RetryOptions
 )
 
 // Register - registers a workflow function with the framework.
@@ -121,7 +154,7 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 //
 // • Local activity is for short living activities (usually finishes within seconds).
 //
-// • Local activity cannot heartbeat.
+// • Local activity heartbeat details are kept by the worker, they are not sent to the server.
 //
 // Context can be used to pass the settings for this local activity.
 // For now there is only one setting for timeout to be set:
@@ -129,9 +162,12 @@ func ExecuteActivity(ctx Context, activity interface{}, args ...interface{}) Fut
 //  	ScheduleToCloseTimeout: 5 * time.Second,
 //  }
 //  ctx := WithLocalActivityOptions(ctx, lao)
-// The timeout here should be relative shorter than the DecisionTaskStartToCloseTimeout of the workflow. If you need a
-// longer timeout, you probably should not use local activity and instead should use regular activity. Local activity is
-// designed to be used for short living activities (usually finishes within seconds).
+// The timeout can be longer than the DecisionTaskStartToCloseTimeout of the workflow. While a local activity is running
+// the worker keeps the decision task open by force completing it and picking up the new decision task before it times
+// out. The local activity can report its progress with activity.RecordHeartbeat(). The __stack_trace query shows when
+// the last details were reported, and the details are handed to the next attempt through
+// activity.GetHeartbeatDetails() when it is retried. They are recorded in the local activity marker when the retry
+// waits for a timer, so they survive the restart of the worker.
 //
 // Input args are the arguments that will to be passed to the local activity. The input args will be hand over directly
 // to local activity function without serialization/deserialization because we don't need to pass the input across process
@@ -174,6 +210,12 @@ func GetInfo(ctx Context) *Info {
 	return internal.GetWorkflowInfo(ctx)
 }
 
+// GetMemo decodes the value of key in the memo the workflow was started with into valuePtr, using the data converter
+// of ctx. It returns an error when the memo has no such key.
+func GetMemo(ctx Context, key string, valuePtr interface{}) error {
+	return internal.GetMemo(ctx, key, valuePtr)
+}
+
 // GetLogger returns a logger to be used in workflow's context
 func GetLogger(ctx Context) *zap.Logger {
 	return internal.GetLogger(ctx)
@@ -213,6 +255,69 @@ func GetSignalChannel(ctx Context, signalName string) Channel {
 	return internal.GetSignalChannel(ctx, signalName)
 }
 
+// SetSignalHandler registers a handler that is called for every signal with the given name. The handler must be a
+// function that takes workflow.Context as its first parameter, optionally followed by a serializable parameter the
+// signal input is decoded into, and it must not return anything:
+//  err := workflow.SetSignalHandler(ctx, "add-item", func(ctx workflow.Context, item string) {
+//      items = append(items, item)
+//  })
+// Each signal is delivered to the handler in its own coroutine, started in the order the signals were received. The
+// handler can call blocking functions like Future.Get() without delaying the signals received after it, so handlers
+// of concurrent signals can interleave at blocking calls. To handle the signals one at a time, receive them from
+// GetSignalChannel instead. Signals received before the handler was set are delivered too.
+// The handler consumes the signals from the channel returned by GetSignalChannel for the same name, so a signal
+// name should either be handled by a handler or received from the channel, not both. Calling SetSignalHandler again
+// for the same name replaces the handler.
+func SetSignalHandler(ctx Context, signalName string, handler interface{}) error {
+	return internal.SetSignalHandler(ctx, signalName, handler)
+}
+
+// DrainSignals blocks until all received signals that have a handler set through SetSignalHandler, including
+// updates, are delivered and the handlers returned. Call it before returning ContinueAsNewError so that no signal is lost. Signals consumed
+// through GetSignalChannel still have to be drained by the workflow code, for example with Channel.ReceiveAsync().
+// It returns CanceledError when ctx is canceled.
+func DrainSignals(ctx Context) error {
+	return internal.DrainSignals(ctx)
+}
+
+// WithContinueAsNewThresholds adds the thresholds used by ShouldContinueAsNew to the context.
+func WithContinueAsNewThresholds(ctx Context, thresholds ContinueAsNewThresholds) Context {
+	return internal.WithContinueAsNewThresholds(ctx, thresholds)
+}
+
+// ShouldContinueAsNew returns true when the history of the workflow grew past the thresholds set with
+// WithContinueAsNewThresholds, or the default ones, and the workflow should complete with ContinueAsNewError to
+// keep clear of the history limits of the server. Long running workflows driven by signals can check it in their
+// event loop:
+//  for !workflow.ShouldContinueAsNew(ctx) {
+//      selector.Select(ctx)
+//  }
+//  _ = workflow.DrainSignals(ctx)
+//  return workflow.NewContinueAsNewError(ctx, MyWorkflow, state)
+// The history is measured at the start of each decision, so the result is the same when the workflow is replayed.
+// WorkflowInfo.HistoryLength and WorkflowInfo.HistorySizeBytes hold the current values.
+func ShouldContinueAsNew(ctx Context) bool {
+	return internal.ShouldContinueAsNew(ctx)
+}
+
+// Retry calls fn until it succeeds, backing off between the attempts with durable timers, so the retries survive
+// worker restarts and show up in the history. Cancellation is not a failure, so it is not retried. Retry returns nil
+// once fn succeeds, and otherwise the error of the last attempt, or CanceledError when ctx is canceled while backing
+// off.
+//  err := workflow.Retry(ctx, workflow.RetryOptions{
+//      InitialInterval: time.Second,
+//      MaximumAttempts: 5,
+//      ShouldRetry: func(err error, attempt int32, backoff time.Duration) bool {
+//          customErr, ok := err.(*workflow.CustomError)
+//          return !ok || customErr.Reason() != "invalid-input"
+//      },
+//  }, func(ctx workflow.Context) error {
+//      return workflow.ExecuteActivity(ctx, ChargeCard, order).Get(ctx, nil)
+//  })
+func Retry(ctx Context, options RetryOptions, fn func(ctx Context) error) error {
+	return internal.Retry(ctx, options, fn)
+}
+
 // SideEffect executes the provided function once, records its result into the workflow history. The recorded result on
 // history will be returned without executing the provided function during replay. This guarantees the deterministic
 // requirement for workflow as the exact same result will be returned in replay.
@@ -345,7 +450,9 @@ func GetVersion(ctx Context, changeID string, minSupported, maxSupported Version
 // The query handler will be invoked out of the context of the workflow, meaning that the handler code must not use workflow
 // context to do things like workflow.NewChannel(), workflow.Go() or to call any workflow blocking functions like
 // Channel.Get() or Future.Get(). Trying to do so in query handler code will fail the query and client will receive
-// QueryFailedError.
+// QueryFailedError. A CustomError returned by the handler reaches the client as a CustomError with the same reason
+// and details. Clients can list the query types of a workflow, with the types of their arguments and result, through
+// the built-in "__query_types" query.
 // Example of workflow code that support query type "current_state":
 //  func MyWorkflow(ctx workflow.Context, input string) error {
 //    currentState := "started" // this could be any serializable struct
@@ -378,6 +485,27 @@ func SetQueryHandler(ctx Context, queryType string, handler interface{}) error {
 	return internal.SetQueryHandler(ctx, queryType, handler)
 }
 
+// SetUpdateHandler sets the handler of the updates with the given name sent through Client.UpdateWorkflow(). An
+// update is a request that changes the workflow state and returns a result to the caller, for example "add item to
+// cart and return the new total". The handler must be a function that takes workflow.Context as its first parameter
+// followed by any number of serializable parameters, and returns either an error or a serializable result and an
+// error:
+//  err := workflow.SetUpdateHandler(ctx, "add_item", func(ctx workflow.Context, item Item) (int, error) {
+//      cart = append(cart, item)
+//      return len(cart), nil
+//  })
+// Each update runs in its own coroutine so the handler can call blocking functions like Future.Get().
+// Updates are delivered as signals named "__update", their outcomes are returned by the "__update_result" query.
+func SetUpdateHandler(ctx Context, updateName string, handler interface{}) error {
+	return internal.SetUpdateHandler(ctx, updateName, handler)
+}
+
+// SetUpdateHandlerWithOptions sets the handler of the updates with the given name, see SetUpdateHandler. Use
+// options to set a validator that can reject updates before they are written to the workflow history.
+func SetUpdateHandlerWithOptions(ctx Context, updateName string, handler interface{}, options UpdateHandlerOptions) error {
+	return internal.SetUpdateHandlerWithOptions(ctx, updateName, handler, options)
+}
+
 // IsReplaying returns whether the current workflow code is replaying.
 //
 // Warning! Never make decisions, like schedule activity/childWorkflow/timer or send/wait on future/channel, based on